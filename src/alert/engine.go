@@ -0,0 +1,470 @@
+package alert
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrRuleNotFound = errors.New("rule not found")
+
+type routeKey struct {
+	probeId     int
+	destination netip.Addr
+}
+
+type stateKey struct {
+	ruleId string
+	route  routeKey
+}
+
+// alertState tracks a rule for a single route
+type alertState struct {
+	// pendingSince is when the comparison of the rule started holding. It is zero while the comparison does not hold.
+	pendingSince time.Time
+	firing       bool
+	firingSince  time.Time
+	// notified is true if a notification was sent for the current firing, so a resolved notification should follow
+	notified     bool
+	lastNotified time.Time
+	value        float64
+}
+
+// routeEvent is an event counted by event rules
+type routeEvent struct {
+	metric    Metric
+	severity  traceroute.AnomalySeverity
+	timestamp time.Time
+}
+
+// Alert is a rule which is currently firing for a route
+type Alert struct {
+	RuleId      string
+	ProbeId     int
+	Destination netip.Addr
+	Since       time.Time
+	Value       float64
+}
+
+// Engine holds the alerting rules and evaluates them against the traceroute data. Rules are saved to a JSON file each
+// time they are changed so they are kept across restarts.
+type Engine struct {
+	path     string
+	notifier Notifier
+
+	rules  map[string]*Rule
+	states map[stateKey]*alertState
+	// Recent events of each route along with the sequence numbers to continue reading each event log from
+	events             map[routeKey][]routeEvent
+	pathChangeSequence uint64
+	loopSequence       uint64
+	anomalySequence    uint64
+
+	lock sync.Mutex
+}
+
+// LoadEngine reads the rules stored at the given path. A missing file results in an engine without any rules, and an
+// empty path disables saving rules.
+func LoadEngine(path string, notifier Notifier) (*Engine, error) {
+	engine := &Engine{
+		path:     path,
+		notifier: notifier,
+		rules:    make(map[string]*Rule),
+		states:   make(map[stateKey]*alertState),
+		events:   make(map[routeKey][]routeEvent),
+	}
+
+	if path == "" {
+		return engine, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return engine, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	for index := range rules {
+		rule := rules[index]
+		if err = rule.Validate(); err != nil {
+			return nil, err
+		}
+
+		engine.rules[rule.Id] = &rule
+	}
+
+	return engine, nil
+}
+
+func (engine *Engine) save() error {
+	if engine.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(engine.sortedRules(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interrupted save does not corrupt the existing rules
+	temporaryPath := engine.path + ".tmp"
+	if err = os.WriteFile(temporaryPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryPath, engine.path)
+}
+
+func (engine *Engine) sortedRules() []Rule {
+	rules := make([]Rule, 0, len(engine.rules))
+	for _, rule := range engine.rules {
+		rules = append(rules, *rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Id < rules[j].Id
+	})
+
+	return rules
+}
+
+func generateRuleId() (string, error) {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+// Rules lists every rule ordered by id
+func (engine *Engine) Rules() []Rule {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	return engine.sortedRules()
+}
+
+func (engine *Engine) GetRule(id string) (Rule, bool) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	if rule, ok := engine.rules[id]; ok {
+		return *rule, true
+	}
+
+	return Rule{}, false
+}
+
+// AddRule validates and stores a new rule under a newly generated id
+func (engine *Engine) AddRule(rule Rule) (Rule, error) {
+	if err := rule.Validate(); err != nil {
+		return rule, err
+	}
+
+	id, err := generateRuleId()
+	if err != nil {
+		return rule, err
+	}
+	rule.Id = id
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.rules[id] = &rule
+	if err = engine.save(); err != nil {
+		delete(engine.rules, id)
+		return rule, err
+	}
+
+	return rule, nil
+}
+
+// UpdateRule replaces an existing rule. The alerts of the previous version of the rule are dropped without sending
+// resolved notifications.
+func (engine *Engine) UpdateRule(id string, rule Rule) (Rule, error) {
+	if err := rule.Validate(); err != nil {
+		return rule, err
+	}
+	rule.Id = id
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	previous, ok := engine.rules[id]
+	if !ok {
+		return rule, ErrRuleNotFound
+	}
+
+	engine.rules[id] = &rule
+	if err := engine.save(); err != nil {
+		engine.rules[id] = previous
+		return rule, err
+	}
+
+	engine.dropStates(id)
+	return rule, nil
+}
+
+func (engine *Engine) DeleteRule(id string) error {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	previous, ok := engine.rules[id]
+	if !ok {
+		return ErrRuleNotFound
+	}
+
+	delete(engine.rules, id)
+	if err := engine.save(); err != nil {
+		engine.rules[id] = previous
+		return err
+	}
+
+	engine.dropStates(id)
+	return nil
+}
+
+func (engine *Engine) dropStates(ruleId string) {
+	for key := range engine.states {
+		if key.ruleId == ruleId {
+			delete(engine.states, key)
+		}
+	}
+}
+
+// Active lists the alerts which are currently firing from the oldest to the newest
+func (engine *Engine) Active() (alerts []Alert) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	for key, state := range engine.states {
+		if state.firing {
+			alerts = append(alerts, Alert{
+				RuleId:      key.ruleId,
+				ProbeId:     key.route.probeId,
+				Destination: key.route.destination,
+				Since:       state.firingSince,
+				Value:       state.value,
+			})
+		}
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Since.Before(alerts[j].Since)
+	})
+
+	return
+}
+
+// observeEvents reads the events recorded since the last call so they can be counted by event rules
+func (engine *Engine) observeEvents(events *traceroute.Events, now time.Time) {
+	var pathChanges []traceroute.PathChange
+	pathChanges, engine.pathChangeSequence = events.PathChanges.Since(engine.pathChangeSequence)
+	for _, change := range pathChanges {
+		engine.addEvent(change.ProbeId, change.Destination, routeEvent{metric: MetricPathChange, timestamp: change.Timestamp})
+	}
+
+	var loops []traceroute.LoopEvent
+	loops, engine.loopSequence = events.Loops.Since(engine.loopSequence)
+	for _, loop := range loops {
+		engine.addEvent(loop.ProbeId, loop.Destination, routeEvent{metric: MetricLoop, timestamp: loop.Timestamp})
+	}
+
+	var anomalies []traceroute.Anomaly
+	anomalies, engine.anomalySequence = events.Anomalies.Since(engine.anomalySequence)
+	for _, anomaly := range anomalies {
+		engine.addEvent(anomaly.ProbeId, anomaly.Destination, routeEvent{
+			metric:    MetricAnomaly,
+			severity:  anomaly.Severity,
+			timestamp: anomaly.Timestamp,
+		})
+	}
+
+}
+
+// evictEvents drops the events of a route which are older than the statistics period since no rule window can reach them
+func (engine *Engine) evictEvents(key routeKey, now time.Time) {
+	oldestAllowed := now.Add(-config.StatisticsPeriod.GetDuration())
+	routeEvents := engine.events[key]
+
+	index := 0
+	for index < len(routeEvents) && routeEvents[index].timestamp.Before(oldestAllowed) {
+		index++
+	}
+
+	if index == len(routeEvents) {
+		delete(engine.events, key)
+	} else {
+		engine.events[key] = routeEvents[index:]
+	}
+}
+
+// addEvent inserts an event in timestamp order since results are not always received in order
+func (engine *Engine) addEvent(probeId int, destination netip.Addr, event routeEvent) {
+	key := routeKey{probeId: probeId, destination: destination}
+	routeEvents := engine.events[key]
+
+	index := sort.Search(len(routeEvents), func(i int) bool {
+		return routeEvents[i].timestamp.After(event.timestamp)
+	})
+
+	routeEvents = append(routeEvents, routeEvent{})
+	copy(routeEvents[index+1:], routeEvents[index:])
+	routeEvents[index] = event
+	engine.events[key] = routeEvents
+}
+
+// countEvents counts the events of a route matching an event rule within the window
+func (engine *Engine) countEvents(rule *Rule, key routeKey, window traceroute.TimeRange) (count float64) {
+	for _, event := range engine.events[key] {
+		if event.metric != rule.Metric || event.timestamp.Before(window.Start) || event.timestamp.After(window.End) {
+			continue
+		}
+
+		if rule.Metric == MetricAnomaly && !event.severity.IsAtLeast(rule.Severity) {
+			continue
+		}
+
+		count += 1
+	}
+
+	return
+}
+
+// EvaluateRoute checks every rule which applies to a route. It is called after each result is added to the route, and
+// the traceroute data lock must be held.
+func (engine *Engine) EvaluateRoute(events *traceroute.Events, routeData *traceroute.RouteData, now time.Time) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.observeEvents(events, now)
+	// Only the events of this route are evicted, since walking every route for each result would be slow
+	engine.evictEvents(routeKey{probeId: routeData.GetProbeId(), destination: routeData.GetDestination()}, now)
+	engine.evaluateRoute(routeData, now)
+}
+
+// EvaluateAll checks every rule against every route. This catches conditions which change over time without new results,
+// such as events leaving the window of a rule. The traceroute data lock must be held.
+func (engine *Engine) EvaluateAll(data *traceroute.TracerouteData, now time.Time) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.observeEvents(data.Events, now)
+	for key := range engine.events {
+		engine.evictEvents(key, now)
+	}
+
+	for _, routeData := range data.Routes() {
+		engine.evaluateRoute(routeData, now)
+	}
+}
+
+func (engine *Engine) evaluateRoute(routeData *traceroute.RouteData, now time.Time) {
+	key := routeKey{probeId: routeData.GetProbeId(), destination: routeData.GetDestination()}
+	aligned := false
+
+	for _, rule := range engine.rules {
+		if !rule.appliesTo(key.probeId, key.destination) {
+			continue
+		}
+
+		window := traceroute.TimeRange{Start: now.Add(-rule.Window), End: now}
+
+		var value float64
+		ok := true
+
+		switch rule.Metric {
+		case MetricDestinationRtt, MetricReachability:
+			// Statistics need to be aligned with the window before they can be read
+			if !aligned {
+				routeData.AlignStatisticsEndTime(now)
+				aligned = true
+			}
+
+			if rule.Metric == MetricDestinationRtt {
+				value, ok = routeData.GetDestinationRttWithin(window)
+			} else {
+				value, ok = routeData.GetReachabilityWithin(window)
+			}
+		default:
+			value = engine.countEvents(rule, key, window)
+		}
+
+		// Leave the alert as it is when there is no data to evaluate
+		if ok {
+			engine.update(rule, key, value, now)
+		}
+	}
+}
+
+func (engine *Engine) update(rule *Rule, route routeKey, value float64, now time.Time) {
+	key := stateKey{ruleId: rule.Id, route: route}
+	state, exists := engine.states[key]
+
+	if !rule.Comparison.matches(value, rule.Threshold) {
+		if !exists {
+			return
+		}
+
+		if state.notified {
+			engine.notify(rule, route, Resolved, value, now)
+		}
+
+		// Keep the time of the last notification so the cooldown still applies
+		state.pendingSince, state.firing, state.notified = time.Time{}, false, false
+		state.value = value
+		return
+	}
+
+	if !exists {
+		state = new(alertState)
+		engine.states[key] = state
+	}
+
+	if state.pendingSince.IsZero() {
+		state.pendingSince = now
+	}
+	state.value = value
+
+	if state.firing || now.Sub(state.pendingSince) < rule.Duration {
+		return
+	}
+
+	state.firing = true
+	state.firingSince = now
+
+	if state.lastNotified.IsZero() || now.Sub(state.lastNotified) >= rule.Cooldown {
+		engine.notify(rule, route, Firing, value, now)
+		state.notified = true
+		state.lastNotified = now
+	}
+}
+
+func (engine *Engine) notify(rule *Rule, route routeKey, status Status, value float64, now time.Time) {
+	engine.notifier.Notify(rule.Webhook, Notification{
+		RuleId:        rule.Id,
+		RuleName:      rule.Name,
+		Status:        status,
+		ProbeId:       route.probeId,
+		DestinationIp: route.destination.String(),
+		Metric:        rule.Metric,
+		Value:         value,
+		Threshold:     rule.Threshold,
+		Timestamp:     now.Unix(),
+	})
+}
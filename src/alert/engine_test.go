@@ -0,0 +1,243 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testDestination = "192.0.2.1"
+
+// buildResult creates a result from probe 100 which crosses the given hop before reaching the destination
+func buildResult(t *testing.T, timestamp time.Time, hop string, destinationRtt float64) *measurement.Result {
+	encoded, err := json.Marshal(map[string]any{
+		"type":      "traceroute",
+		"af":        4,
+		"msm_id":    1,
+		"prb_id":    100,
+		"timestamp": timestamp.Unix(),
+		"paris_id":  1,
+		"src_addr":  "10.0.0.1",
+		"dst_addr":  testDestination,
+		"dst_name":  testDestination,
+		"result": []map[string]any{
+			{"hop": 1, "result": []map[string]any{{"from": hop, "rtt": 1.0, "ttl": 250, "size": 28}}},
+			{"hop": 2, "result": []map[string]any{{"from": testDestination, "rtt": destinationRtt, "ttl": 250, "size": 28}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed measurement.Result
+	if err = json.Unmarshal(encoded, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	return &parsed
+}
+
+// startWebhook starts a local stand-in for a webhook which forwards each notification it receives
+func startWebhook(t *testing.T) (string, <-chan Notification) {
+	received := make(chan Notification, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var notification Notification
+		if err := json.NewDecoder(request.Body).Decode(&notification); err != nil {
+			t.Errorf("Failed to read notification: %v", err)
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		received <- notification
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, received
+}
+
+func startNotifier(t *testing.T) *WebhookNotifier {
+	notifier := MakeWebhookNotifier(time.Second, 16)
+	go notifier.Run()
+	t.Cleanup(notifier.Close)
+	return notifier
+}
+
+func expectNotification(t *testing.T, received <-chan Notification, status Status) Notification {
+	select {
+	case notification := <-received:
+		if notification.Status != status {
+			t.Fatalf("Expected %s notification, but got %+v", status, notification)
+		}
+
+		return notification
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s notification", status)
+		return Notification{}
+	}
+}
+
+func expectNoNotification(t *testing.T, received <-chan Notification) {
+	select {
+	case notification := <-received:
+		t.Fatalf("Expected no notification, but got %+v", notification)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestStatisticRuleDurationAndCooldown(t *testing.T) {
+	webhook, received := startWebhook(t)
+	engine, err := LoadEngine("", startNotifier(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := engine.AddRule(Rule{
+		Name:        "Slow destination",
+		Destination: netip.MustParseAddr(testDestination),
+		Metric:      MetricDestinationRtt,
+		Threshold:   50,
+		Duration:    10 * time.Minute,
+		Cooldown:    time.Hour,
+		Webhook:     webhook,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := traceroute.MakeTracerouteData()
+	now := time.Unix(1672531200, 0)
+	appendResults := func(count int, rtt float64) {
+		for i := 0; i < count; i++ {
+			now = now.Add(30 * time.Second)
+			data.AppendMeasurement(buildResult(t, now, "10.0.1.1", rtt))
+			routeData, _ := data.GetRouteData(100, netip.MustParseAddr(testDestination))
+			engine.EvaluateRoute(data.Events, routeData, now)
+		}
+	}
+
+	// The condition has to hold for the duration of the rule before it fires
+	appendResults(10, 100)
+	expectNoNotification(t, received)
+
+	appendResults(15, 100)
+	notification := expectNotification(t, received, Firing)
+	if notification.RuleId != rule.Id || notification.ProbeId != 100 || notification.Value != 100 {
+		t.Errorf("Unexpected notification: %+v", notification)
+	}
+
+	if active := engine.Active(); len(active) != 1 || active[0].RuleId != rule.Id {
+		t.Errorf("Expected a single active alert, but got %+v", active)
+	}
+
+	appendResults(40, 10)
+	expectNotification(t, received, Resolved)
+
+	// Firing again within the cooldown should not send another notification
+	appendResults(30, 200)
+	expectNoNotification(t, received)
+
+	if active := engine.Active(); len(active) != 1 {
+		t.Errorf("Expected the alert to be active during the cooldown, but got %+v", active)
+	}
+}
+
+func TestEventRule(t *testing.T) {
+	webhook, received := startWebhook(t)
+	engine, err := LoadEngine("", startNotifier(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = engine.AddRule(Rule{
+		Metric:    MetricPathChange,
+		ProbeIds:  []int{100},
+		Threshold: 0,
+		Window:    time.Hour,
+		Webhook:   webhook,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := traceroute.MakeTracerouteData()
+	now := time.Unix(1672531200, 0)
+	for _, hop := range []string{"10.0.1.1", "10.0.1.1", "10.0.1.1", "10.0.2.1", "10.0.2.1"} {
+		now = now.Add(time.Minute)
+		data.AppendMeasurement(buildResult(t, now, hop, 10))
+		routeData, _ := data.GetRouteData(100, netip.MustParseAddr(testDestination))
+		engine.EvaluateRoute(data.Events, routeData, now)
+	}
+
+	if notification := expectNotification(t, received, Firing); notification.Value != 1 {
+		t.Errorf("Expected a single path change, but got %v", notification.Value)
+	}
+
+	// The alert resolves once the path change leaves the window
+	engine.EvaluateAll(&data, now.Add(2*time.Hour))
+	expectNotification(t, received, Resolved)
+}
+
+func TestRulePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	engine, err := LoadEngine(path, startNotifier(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.AddRule(Rule{Metric: "bandwidth", Webhook: "http://localhost"}); err == nil {
+		t.Error("Expected rule with an unknown metric to be rejected")
+	}
+
+	if _, err = engine.AddRule(Rule{Metric: MetricReachability}); !errors.Is(err, errMissingWebhook) {
+		t.Errorf("Expected rule without a webhook to be rejected, but got %v", err)
+	}
+
+	// Optional fields are left out so their defaults are used
+	var kept Rule
+	if err = json.Unmarshal([]byte(`{"metric": "reachability", "comparison": "below", "threshold": 0.9, "webhook": "http://localhost"}`), &kept); err != nil {
+		t.Fatal(err)
+	}
+
+	if kept, err = engine.AddRule(kept); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := engine.AddRule(Rule{Metric: MetricLoop, Webhook: "http://localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept.Threshold = 0.5
+	if _, err = engine.UpdateRule(kept.Id, kept); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = engine.DeleteRule(removed.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = engine.DeleteRule(removed.Id); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("Expected deleting a missing rule to fail, but got %v", err)
+	}
+
+	reloaded, err := LoadEngine(path, startNotifier(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := reloaded.Rules()
+	if len(rules) != 1 || rules[0].Id != kept.Id || rules[0].Threshold != 0.5 || rules[0].Comparison != Below {
+		t.Errorf("Expected only the updated rule to be saved, but got %+v", rules)
+	}
+
+	if rules[0].Window != defaultWindow || rules[0].Cooldown != defaultCooldown {
+		t.Errorf("Expected defaults to be kept, but got window %v and cooldown %v", rules[0].Window, rules[0].Cooldown)
+	}
+}
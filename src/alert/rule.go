@@ -0,0 +1,213 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// Metric is the value of a route a rule is evaluated against
+type Metric string
+
+const (
+	// MetricDestinationRtt is the average RTT to the destination in milliseconds
+	MetricDestinationRtt Metric = "destinationRtt"
+	// MetricReachability is the fraction of results which reached the destination
+	MetricReachability Metric = "reachability"
+	// MetricPathChange, MetricLoop and MetricAnomaly count the events of each type recorded for the route
+	MetricPathChange Metric = "pathChange"
+	MetricLoop       Metric = "loop"
+	MetricAnomaly    Metric = "anomaly"
+)
+
+// isEvent checks if the metric counts events instead of reading a statistic of the route
+func (metric Metric) isEvent() bool {
+	return metric == MetricPathChange || metric == MetricLoop || metric == MetricAnomaly
+}
+
+func (metric Metric) isValid() bool {
+	return metric.isEvent() || metric == MetricDestinationRtt || metric == MetricReachability
+}
+
+type Comparison string
+
+const (
+	Above Comparison = "above"
+	Below Comparison = "below"
+)
+
+func (comparison Comparison) matches(value, threshold float64) bool {
+	if comparison == Below {
+		return value < threshold
+	}
+
+	return value > threshold
+}
+
+// Default values for optional rule fields
+const (
+	defaultWindow   = time.Hour
+	defaultCooldown = time.Hour
+)
+
+// Rule describes when to send a notification about a route. The metric of each matching route is computed over the last
+// Window and compared against the threshold. Once the comparison has held for Duration, a notification is sent to the
+// webhook. Further notifications for the same route are held back until Cooldown has passed.
+type Rule struct {
+	Id   string
+	Name string
+	// Destination and ProbeIds limit which routes the rule applies to. They match every route when left empty.
+	Destination netip.Addr
+	ProbeIds    []int
+	Metric      Metric
+	// Severity is the minimum severity counted by anomaly rules
+	Severity   traceroute.AnomalySeverity
+	Comparison Comparison
+	Threshold  float64
+	Window     time.Duration
+	Duration   time.Duration
+	Cooldown   time.Duration
+	Webhook    string
+}
+
+var errMissingWebhook = errors.New("a webhook URL is required")
+
+// Validate checks the rule can be evaluated and fills in defaults for the optional fields
+func (rule *Rule) Validate() error {
+	if !rule.Metric.isValid() {
+		return fmt.Errorf("unknown metric %q", rule.Metric)
+	}
+
+	if rule.Comparison == "" {
+		rule.Comparison = Above
+	} else if rule.Comparison != Above && rule.Comparison != Below {
+		return fmt.Errorf("comparison must be %q or %q", Above, Below)
+	}
+
+	if rule.Severity == "" {
+		rule.Severity = traceroute.AnomalyWarning
+	} else if !rule.Severity.IsValid() {
+		return fmt.Errorf("unknown severity %q", rule.Severity)
+	}
+
+	if rule.Window == 0 {
+		rule.Window = defaultWindow
+	}
+
+	// Nothing older than the statistics period is kept
+	if rule.Window < 0 || rule.Window > config.StatisticsPeriod.GetDuration() {
+		return fmt.Errorf("window must be positive and at most the statistics period")
+	}
+
+	if rule.Duration < 0 || rule.Cooldown < 0 {
+		return errors.New("duration and cooldown can not be negative")
+	}
+
+	if rule.Webhook == "" {
+		return errMissingWebhook
+	}
+
+	if parsed, err := url.Parse(rule.Webhook); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("webhook %q is not an http or https URL", rule.Webhook)
+	}
+
+	return nil
+}
+
+// appliesTo checks if the rule covers the route between a probe and destination
+func (rule *Rule) appliesTo(probeId int, destination netip.Addr) bool {
+	if rule.Destination.IsValid() && rule.Destination != destination {
+		return false
+	}
+
+	if len(rule.ProbeIds) == 0 {
+		return true
+	}
+
+	for _, id := range rule.ProbeIds {
+		if id == probeId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ruleJson is the format rules are read and written in. Durations are given in seconds.
+type ruleJson struct {
+	Id          string                     `json:"id"`
+	Name        string                     `json:"name"`
+	Destination string                     `json:"destinationIp,omitempty"`
+	ProbeIds    []int                      `json:"probeIds,omitempty"`
+	Metric      Metric                     `json:"metric"`
+	Severity    traceroute.AnomalySeverity `json:"severity,omitempty"`
+	Comparison  Comparison                 `json:"comparison"`
+	Threshold   float64                    `json:"threshold"`
+	Window      int64                      `json:"window"`
+	Duration    int64                      `json:"duration"`
+	Cooldown    *int64                     `json:"cooldown"`
+	Webhook     string                     `json:"webhook"`
+}
+
+func (rule Rule) MarshalJSON() ([]byte, error) {
+	cooldown := int64(rule.Cooldown.Seconds())
+	buffer := ruleJson{
+		Id:         rule.Id,
+		Name:       rule.Name,
+		ProbeIds:   rule.ProbeIds,
+		Metric:     rule.Metric,
+		Comparison: rule.Comparison,
+		Threshold:  rule.Threshold,
+		Window:     int64(rule.Window.Seconds()),
+		Duration:   int64(rule.Duration.Seconds()),
+		Cooldown:   &cooldown,
+		Webhook:    rule.Webhook,
+	}
+
+	if rule.Destination.IsValid() {
+		buffer.Destination = rule.Destination.String()
+	}
+
+	if rule.Metric == MetricAnomaly {
+		buffer.Severity = rule.Severity
+	}
+
+	return json.Marshal(buffer)
+}
+
+func (rule *Rule) UnmarshalJSON(bytes []byte) (err error) {
+	var buffer ruleJson
+	if err = json.Unmarshal(bytes, &buffer); err != nil {
+		return
+	}
+
+	*rule = Rule{
+		Id:         buffer.Id,
+		Name:       buffer.Name,
+		ProbeIds:   buffer.ProbeIds,
+		Metric:     buffer.Metric,
+		Severity:   buffer.Severity,
+		Comparison: buffer.Comparison,
+		Threshold:  buffer.Threshold,
+		Window:     time.Duration(buffer.Window) * time.Second,
+		Duration:   time.Duration(buffer.Duration) * time.Second,
+		Cooldown:   defaultCooldown,
+		Webhook:    buffer.Webhook,
+	}
+
+	// A cooldown of 0 is allowed, so only a missing cooldown uses the default
+	if buffer.Cooldown != nil {
+		rule.Cooldown = time.Duration(*buffer.Cooldown) * time.Second
+	}
+
+	if buffer.Destination != "" {
+		rule.Destination, err = netip.ParseAddr(buffer.Destination)
+	}
+
+	return
+}
@@ -0,0 +1,111 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+type Status string
+
+const (
+	Firing   Status = "firing"
+	Resolved Status = "resolved"
+)
+
+// Notification is the body sent to the webhook of a rule when an alert starts firing or is resolved
+type Notification struct {
+	RuleId        string  `json:"ruleId"`
+	RuleName      string  `json:"ruleName"`
+	Status        Status  `json:"status"`
+	ProbeId       int     `json:"probeId"`
+	DestinationIp string  `json:"destinationIp"`
+	Metric        Metric  `json:"metric"`
+	Value         float64 `json:"value"`
+	Threshold     float64 `json:"threshold"`
+	Timestamp     int64   `json:"timestamp"`
+}
+
+// Notifier delivers notifications. Notify is called while evaluating rules, so it must not block.
+type Notifier interface {
+	Notify(webhook string, notification Notification)
+}
+
+type delivery struct {
+	webhook      string
+	notification Notification
+}
+
+// webhookAttempts is the number of times delivering a notification is attempted before it is dropped
+const webhookAttempts = 3
+
+// WebhookNotifier posts notifications as JSON from a background queue so slow webhooks do not hold up evaluation
+type WebhookNotifier struct {
+	client *http.Client
+	queue  chan delivery
+}
+
+func MakeWebhookNotifier(timeout time.Duration, queueSize int) *WebhookNotifier {
+	return &WebhookNotifier{
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan delivery, queueSize),
+	}
+}
+
+func (notifier *WebhookNotifier) Notify(webhook string, notification Notification) {
+	select {
+	case notifier.queue <- delivery{webhook: webhook, notification: notification}:
+	default:
+		log.Printf("Alert queue is full, dropping %s notification for rule %s\n", notification.Status, notification.RuleId)
+	}
+}
+
+// Run delivers queued notifications until the queue is closed
+func (notifier *WebhookNotifier) Run() {
+	for item := range notifier.queue {
+		var err error
+		for attempt := 0; attempt < webhookAttempts; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+
+			if err = notifier.post(item); err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			log.Printf("Failed to deliver alert for rule %s to %s: %v\n", item.notification.RuleId, item.webhook, err)
+		}
+	}
+}
+
+// Close stops Run once the remaining notifications have been delivered
+func (notifier *WebhookNotifier) Close() {
+	close(notifier.queue)
+}
+
+func (notifier *WebhookNotifier) post(item delivery) error {
+	body, err := json.Marshal(item.notification)
+	if err != nil {
+		return err
+	}
+
+	response, err := notifier.client.Post(item.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer util.CloseAndLogErrors("Failed to close webhook response:", response.Body)
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package alias
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Link is a pair of addresses which replied at consecutive hops of a traceroute
+type Link struct {
+	From, To netip.Addr
+}
+
+// HostnameLookup gets the reverse DNS hostname of an address if it is known
+type HostnameLookup func(addr netip.Addr) (string, bool)
+
+// Evidence holds the observations aliases are inferred from
+type Evidence struct {
+	// Addresses holds every interface address which has been observed
+	Addresses map[netip.Addr]struct{}
+	Links     map[Link]struct{}
+	// Hostnames is optional and is skipped when nil
+	Hostnames HostnameLookup
+}
+
+func MakeEvidence() Evidence {
+	return Evidence{
+		Addresses: make(map[netip.Addr]struct{}),
+		Links:     make(map[Link]struct{}),
+	}
+}
+
+func (evidence *Evidence) AddLink(from, to netip.Addr) {
+	evidence.Addresses[from] = struct{}{}
+	evidence.Addresses[to] = struct{}{}
+	evidence.Links[Link{From: from, To: to}] = struct{}{}
+}
+
+// Infer groups addresses into routers using the given evidence
+func Infer(evidence Evidence) Resolver {
+	resolver := MakeResolver()
+	inferPointToPointAliases(&resolver, evidence)
+
+	if evidence.Hostnames != nil {
+		inferHostnameAliases(&resolver, evidence)
+	}
+
+	return resolver
+}
+
+// pointToPointMates gets the addresses which could be on the other end of a point-to-point link using the given
+// address. Links are usually numbered from a /31 (or /127), or from the two usable addresses of a /30 (or /126).
+func pointToPointMates(addr netip.Addr) (mates []netip.Addr) {
+	bytes := addr.AsSlice()
+	last := len(bytes) - 1
+
+	mate31 := append([]byte(nil), bytes...)
+	mate31[last] ^= 1
+	if mate, ok := netip.AddrFromSlice(mate31); ok {
+		mates = append(mates, mate.WithZone(addr.Zone()))
+	}
+
+	// The network and broadcast addresses of a /30 can not be assigned to an interface
+	if hostBits := bytes[last] & 3; hostBits == 1 || hostBits == 2 {
+		mate30 := append([]byte(nil), bytes...)
+		mate30[last] ^= 3
+		if mate, ok := netip.AddrFromSlice(mate30); ok {
+			mates = append(mates, mate.WithZone(addr.Zone()))
+		}
+	}
+
+	return
+}
+
+// inferPointToPointAliases applies the subnet mate heuristic. Traceroute reports the interface a router received a probe
+// on, so for a link A -> B, the other end of the point-to-point subnet containing B is likely the interface A used to
+// send the probe. If that address has been observed elsewhere, it must be an alias of A.
+func inferPointToPointAliases(resolver *Resolver, evidence Evidence) {
+	for link := range evidence.Links {
+		var candidate netip.Addr
+		candidates := 0
+
+		for _, mate := range pointToPointMates(link.To) {
+			if _, ok := evidence.Addresses[mate]; ok && mate != link.From {
+				candidate = mate
+				candidates += 1
+			}
+		}
+
+		// Skip links where the subnet size is ambiguous
+		if candidates == 1 {
+			resolver.Union(link.From, candidate)
+		}
+	}
+}
+
+// inferHostnameAliases groups addresses whose hostnames only differ by their first label. Router hostnames generally
+// name the interface first (ex: ae1.cr2.lax1.example.net) followed by the router itself.
+func inferHostnameAliases(resolver *Resolver, evidence Evidence) {
+	routers := make(map[string]netip.Addr)
+
+	for addr := range evidence.Addresses {
+		hostname, ok := evidence.Hostnames(addr)
+		if !ok {
+			continue
+		}
+
+		router, ok := routerName(hostname, addr)
+		if !ok {
+			continue
+		}
+
+		if existing, ok := routers[router]; ok {
+			resolver.Union(existing, addr)
+		} else {
+			routers[router] = addr
+		}
+	}
+}
+
+// routerName gets the part of a hostname which identifies the router. Hostnames which are too short to hold an
+// interface name, or which are generated from the address itself, do not identify a router.
+func routerName(hostname string, addr netip.Addr) (string, bool) {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if embedsAddress(hostname, addr) {
+		return "", false
+	}
+
+	labels := strings.Split(hostname, ".")
+	if len(labels) < 4 || labels[0] == "" || !unicode.IsLetter(rune(labels[0][0])) {
+		return "", false
+	}
+
+	return strings.Join(labels[1:], "."), true
+}
+
+// embedsAddress checks if a hostname was generated from the octets of an IPv4 address
+// (ex: 192-0-2-1.static.example.net)
+func embedsAddress(hostname string, addr netip.Addr) bool {
+	if !addr.Is4() {
+		return false
+	}
+
+	octets := addr.As4()
+	forward := make([]string, 4)
+	reverse := make([]string, 4)
+	for index, octet := range octets {
+		forward[index] = strconv.Itoa(int(octet))
+		reverse[3-index] = forward[index]
+	}
+
+	for _, separator := range []string{"-", ".", "_"} {
+		if strings.Contains(hostname, strings.Join(forward, separator)) ||
+			strings.Contains(hostname, strings.Join(reverse, separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReadItdkNodes reads the aliases from an ITDK (or MIDAR) nodes file. Each line groups the interfaces of a single
+// router, such as "node N1:  192.0.2.1 198.51.100.7". Lines starting with # are comments.
+func ReadItdkNodes(reader io.Reader) (Resolver, error) {
+	resolver := MakeResolver()
+	scanner := bufio.NewScanner(reader)
+	// Large routers can have thousands of interfaces on a single line
+	scanner.Buffer(nil, 1<<24)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "node" || !strings.HasSuffix(fields[1], ":") {
+			return resolver, fmt.Errorf("malformed node on line %d: %q", lineNumber, line)
+		}
+
+		var first netip.Addr
+		for _, field := range fields[2:] {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return resolver, fmt.Errorf("invalid address on line %d: %w", lineNumber, err)
+			}
+
+			if first.IsValid() {
+				resolver.Union(first, addr)
+			} else {
+				first = addr
+			}
+		}
+	}
+
+	return resolver, scanner.Err()
+}
@@ -0,0 +1,133 @@
+package alias
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func addrs(addresses ...string) (parsed []netip.Addr) {
+	for _, address := range addresses {
+		parsed = append(parsed, netip.MustParseAddr(address))
+	}
+
+	return
+}
+
+func TestResolverUsesLowestAddress(t *testing.T) {
+	resolver := MakeResolver()
+	resolver.Union(netip.MustParseAddr("10.0.0.9"), netip.MustParseAddr("10.0.0.5"))
+	resolver.Union(netip.MustParseAddr("10.0.0.7"), netip.MustParseAddr("10.0.0.9"))
+
+	if router := resolver.Find(netip.MustParseAddr("10.0.0.7")); router != netip.MustParseAddr("10.0.0.5") {
+		t.Errorf("Expected router to be identified by 10.0.0.5, but got %v", router)
+	}
+
+	expected := addrs("10.0.0.5", "10.0.0.7", "10.0.0.9")
+	if aliases := resolver.Aliases(netip.MustParseAddr("10.0.0.9")); !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("Expected aliases %v, but got %v", expected, aliases)
+	}
+
+	if router := resolver.Find(netip.MustParseAddr("10.0.0.1")); router != netip.MustParseAddr("10.0.0.1") {
+		t.Errorf("Expected address without aliases to be its own router, but got %v", router)
+	}
+}
+
+func TestPointToPointMates(t *testing.T) {
+	cases := map[string][]netip.Addr{
+		"10.0.0.0":    addrs("10.0.0.1"),
+		"10.0.0.1":    addrs("10.0.0.0", "10.0.0.2"),
+		"10.0.0.2":    addrs("10.0.0.3", "10.0.0.1"),
+		"10.0.0.3":    addrs("10.0.0.2"),
+		"2001:db8::1": addrs("2001:db8::", "2001:db8::2"),
+	}
+
+	for address, expected := range cases {
+		if mates := pointToPointMates(netip.MustParseAddr(address)); !reflect.DeepEqual(mates, expected) {
+			t.Errorf("Expected mates of %s to be %v, but got %v", address, expected, mates)
+		}
+	}
+}
+
+func TestInferPointToPointAliases(t *testing.T) {
+	evidence := MakeEvidence()
+
+	// Router A replies from 10.0.0.1 on one path. On another path we see it leave through 10.0.1.0 to reach router B at
+	// 10.0.1.1, and 10.0.1.0 is seen replying on a third path.
+	evidence.AddLink(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.1.1"))
+	evidence.AddLink(netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("10.0.1.0"))
+
+	// The mate of 10.0.2.2 could be either 10.0.2.3 (/31) or 10.0.2.1 (/30), so nothing can be inferred
+	evidence.AddLink(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.2.2"))
+	evidence.AddLink(netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("10.0.2.3"))
+	evidence.AddLink(netip.MustParseAddr("192.0.2.3"), netip.MustParseAddr("10.0.2.1"))
+
+	resolver := Infer(evidence)
+
+	expected := addrs("10.0.0.1", "10.0.1.0")
+	if aliases := resolver.Aliases(netip.MustParseAddr("10.0.0.1")); !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("Expected aliases %v, but got %v", expected, aliases)
+	}
+}
+
+func TestInferHostnameAliases(t *testing.T) {
+	hostnames := map[netip.Addr]string{
+		netip.MustParseAddr("198.51.100.1"): "ae1.cr2.lax1.example.net.",
+		netip.MustParseAddr("198.51.100.9"): "xe-0-0-1.cr2.lax1.example.net",
+		netip.MustParseAddr("198.51.100.5"): "ae1.cr1.lax1.example.net",
+		// Hostnames generated from the address should never be grouped
+		netip.MustParseAddr("203.0.113.1"): "203-0-113-1.static.example.net",
+		netip.MustParseAddr("203.0.113.2"): "203-0-113-2.static.example.net",
+	}
+
+	evidence := MakeEvidence()
+	for addr := range hostnames {
+		evidence.Addresses[addr] = struct{}{}
+	}
+
+	evidence.Hostnames = func(addr netip.Addr) (string, bool) {
+		hostname, ok := hostnames[addr]
+		return hostname, ok
+	}
+
+	resolver := Infer(evidence)
+
+	expected := addrs("198.51.100.1", "198.51.100.9")
+	if aliases := resolver.Aliases(netip.MustParseAddr("198.51.100.9")); !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("Expected aliases %v, but got %v", expected, aliases)
+	}
+
+	for _, address := range []string{"198.51.100.5", "203.0.113.1", "203.0.113.2"} {
+		if aliases := resolver.Aliases(netip.MustParseAddr(address)); len(aliases) != 1 {
+			t.Errorf("Expected %s to not have aliases, but got %v", address, aliases)
+		}
+	}
+}
+
+func TestReadItdkNodes(t *testing.T) {
+	nodes := strings.NewReader(`# ITDK nodes file
+node N1:  192.0.2.1 198.51.100.7 2001:db8::1
+node N2:  203.0.113.5
+
+node N3:  203.0.113.9 203.0.113.10
+`)
+
+	resolver, err := ReadItdkNodes(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := addrs("192.0.2.1", "198.51.100.7", "2001:db8::1")
+	if aliases := resolver.Aliases(netip.MustParseAddr("2001:db8::1")); !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("Expected aliases %v, but got %v", expected, aliases)
+	}
+
+	if router := resolver.Find(netip.MustParseAddr("203.0.113.10")); router != netip.MustParseAddr("203.0.113.9") {
+		t.Errorf("Expected 203.0.113.10 to belong to 203.0.113.9, but got %v", router)
+	}
+
+	if _, err = ReadItdkNodes(strings.NewReader("node N1: not-an-address\n")); err == nil {
+		t.Error("Expected invalid address to produce an error")
+	}
+}
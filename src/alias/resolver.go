@@ -0,0 +1,89 @@
+package alias
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// Resolver groups interface addresses which belong to the same router. Each group is identified by its lowest address.
+type Resolver struct {
+	parent map[netip.Addr]netip.Addr
+}
+
+func MakeResolver() Resolver {
+	return Resolver{
+		parent: make(map[netip.Addr]netip.Addr),
+	}
+}
+
+// Find gets the address used to identify the router an interface belongs to. Addresses without any known aliases are
+// their own router. Find does not modify the resolver, so it is safe to call from multiple goroutines at once.
+func (resolver *Resolver) Find(addr netip.Addr) netip.Addr {
+	for {
+		parent, ok := resolver.parent[addr]
+		if !ok || parent == addr {
+			return addr
+		}
+
+		addr = parent
+	}
+}
+
+// compress finds the root of an address and points every address on the way directly at it, so later lookups are
+// faster
+func (resolver *Resolver) compress(addr netip.Addr) netip.Addr {
+	root := resolver.Find(addr)
+	for addr != root {
+		next := resolver.parent[addr]
+		resolver.parent[addr] = root
+		addr = next
+	}
+
+	return root
+}
+
+// Union records that two addresses are interfaces of the same router
+func (resolver *Resolver) Union(a, b netip.Addr) {
+	rootA, rootB := resolver.compress(a), resolver.compress(b)
+	if rootA == rootB {
+		return
+	}
+
+	// Always keep the lowest address as the root so router ids do not depend on the order evidence was added in
+	if rootB.Less(rootA) {
+		rootA, rootB = rootB, rootA
+	}
+
+	resolver.parent[rootA] = rootA
+	resolver.parent[rootB] = rootA
+}
+
+// Merge adds all the aliases known by another resolver
+func (resolver *Resolver) Merge(other *Resolver) {
+	for addr := range other.parent {
+		resolver.Union(addr, other.Find(addr))
+	}
+}
+
+// Aliases gets every known interface address of the router an address belongs to, including the address itself
+func (resolver *Resolver) Aliases(addr netip.Addr) []netip.Addr {
+	root := resolver.Find(addr)
+	aliases := []netip.Addr{addr}
+
+	for member := range resolver.parent {
+		if member != addr && resolver.Find(member) == root {
+			aliases = append(aliases, member)
+		}
+	}
+
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Less(aliases[j])
+	})
+
+	return aliases
+}
+
+// Len gets the number of addresses which have at least one known alias
+func (resolver *Resolver) Len() int {
+	return len(resolver.parent)
+}
@@ -0,0 +1,110 @@
+package catchment
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// SiteLookup finds the anycast site a router belongs to
+type SiteLookup func(addr netip.Addr) (string, bool)
+
+// Site lists the probes which are currently routed to an anycast site
+type Site struct {
+	Name   string
+	Probes []int
+}
+
+// Change records when a probe started being routed to a different site
+type Change struct {
+	ProbeId   int
+	Timestamp time.Time
+	From, To  string
+}
+
+// Catchments describes how probes are split between the sites of an anycast destination
+type Catchments struct {
+	// Sites are sorted from the most to the least probes
+	Sites []Site
+	// Unmapped holds the probes whose latest penultimate hop could not be assigned to a site
+	Unmapped []int
+	// Changes are sorted by timestamp
+	Changes []Change
+}
+
+// Analyze finds the site each probe is routed to from the penultimate hops of its results. Each probe is assigned to the
+// site of its latest result, and a change is recorded whenever consecutive results are routed to different sites.
+// Results whose penultimate hop can not be assigned to a site are ignored when finding changes.
+func Analyze(penultimateHops map[int][]traceroute.PenultimateHop, lookup SiteLookup) (catchments Catchments) {
+	// Many probes share the same penultimate hops, so only look each one up once
+	knownSites := make(map[netip.Addr]string)
+	siteOf := func(addr netip.Addr) string {
+		site, ok := knownSites[addr]
+		if !ok {
+			site, _ = lookup(addr)
+			knownSites[addr] = site
+		}
+
+		return site
+	}
+
+	probesBySite := make(map[string][]int)
+
+	for probeId, hops := range penultimateHops {
+		if len(hops) == 0 {
+			continue
+		}
+
+		previous := ""
+		for _, hop := range hops {
+			site := siteOf(hop.Addr)
+			if site == "" {
+				continue
+			}
+
+			if previous != "" && site != previous {
+				catchments.Changes = append(catchments.Changes, Change{
+					ProbeId:   probeId,
+					Timestamp: hop.Timestamp,
+					From:      previous,
+					To:        site,
+				})
+			}
+
+			previous = site
+		}
+
+		if current := siteOf(hops[len(hops)-1].Addr); current != "" {
+			probesBySite[current] = append(probesBySite[current], probeId)
+		} else {
+			catchments.Unmapped = append(catchments.Unmapped, probeId)
+		}
+	}
+
+	for name, probes := range probesBySite {
+		sort.Ints(probes)
+		catchments.Sites = append(catchments.Sites, Site{Name: name, Probes: probes})
+	}
+
+	sort.Slice(catchments.Sites, func(i, j int) bool {
+		a, b := catchments.Sites[i], catchments.Sites[j]
+		if len(a.Probes) != len(b.Probes) {
+			return len(a.Probes) > len(b.Probes)
+		}
+
+		return a.Name < b.Name
+	})
+
+	sort.Ints(catchments.Unmapped)
+	sort.Slice(catchments.Changes, func(i, j int) bool {
+		a, b := catchments.Changes[i], catchments.Changes[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+
+		return a.ProbeId < b.ProbeId
+	})
+
+	return
+}
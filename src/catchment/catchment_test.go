@@ -0,0 +1,95 @@
+package catchment
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSiteMap = `# Sample site mapping
+192.0.2.1        LAX
+198.51.100.0/24  FRA
+*.ams1.example.net AMS
+`
+
+func TestReadSiteMap(t *testing.T) {
+	sites, err := ReadSiteMap(strings.NewReader(testSiteMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		addr, hostname, expected string
+	}{
+		{"192.0.2.1", "", "LAX"},
+		{"198.51.100.20", "ae1.cr1.ams1.example.net", "FRA"},
+		{"203.0.113.1", "AE1.CR1.AMS1.Example.Net.", "AMS"},
+		{"203.0.113.1", "ams1.example.net", "AMS"},
+		{"203.0.113.1", "xams1.example.net", ""},
+		{"192.0.2.2", "", ""},
+	}
+
+	for _, testCase := range cases {
+		site, _ := sites.Lookup(netip.MustParseAddr(testCase.addr), testCase.hostname)
+		if site != testCase.expected {
+			t.Errorf("Expected %s (%q) to be mapped to %q, but got %q", testCase.addr, testCase.hostname, testCase.expected, site)
+		}
+	}
+
+	if _, err = ReadSiteMap(strings.NewReader("192.0.2.1\n")); err == nil {
+		t.Error("Expected line without a site to be rejected")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	start := time.Unix(1672531200, 0)
+	hop := func(offset int, addr string) traceroute.PenultimateHop {
+		return traceroute.PenultimateHop{
+			Timestamp: start.Add(time.Duration(offset) * time.Minute),
+			Addr:      netip.MustParseAddr(addr),
+		}
+	}
+
+	sites := map[string]string{
+		"10.0.0.1": "LAX",
+		"10.0.0.2": "LAX",
+		"10.0.1.1": "SJC",
+	}
+
+	lookup := func(addr netip.Addr) (string, bool) {
+		site, ok := sites[addr.String()]
+		return site, ok
+	}
+
+	catchments := Analyze(map[int][]traceroute.PenultimateHop{
+		// Moving between routers within the same site is not a change
+		1: {hop(0, "10.0.0.1"), hop(15, "10.0.0.2")},
+		// Unmapped hops between results on different sites are skipped
+		2: {hop(0, "10.0.0.1"), hop(15, "10.9.9.9"), hop(30, "10.0.1.1")},
+		3: {hop(0, "10.0.1.1"), hop(15, "10.0.0.1")},
+		4: {hop(0, "10.0.1.1"), hop(15, "10.9.9.9")},
+	}, lookup)
+
+	expectedSites := []Site{
+		{Name: "LAX", Probes: []int{1, 3}},
+		{Name: "SJC", Probes: []int{2}},
+	}
+	if !reflect.DeepEqual(catchments.Sites, expectedSites) {
+		t.Errorf("Expected sites %+v, but got %+v", expectedSites, catchments.Sites)
+	}
+
+	if !reflect.DeepEqual(catchments.Unmapped, []int{4}) {
+		t.Errorf("Expected probe 4 to be unmapped, but got %v", catchments.Unmapped)
+	}
+
+	expectedChanges := []Change{
+		{ProbeId: 3, Timestamp: start.Add(15 * time.Minute), From: "SJC", To: "LAX"},
+		{ProbeId: 2, Timestamp: start.Add(30 * time.Minute), From: "LAX", To: "SJC"},
+	}
+	if !reflect.DeepEqual(catchments.Changes, expectedChanges) {
+		t.Errorf("Expected changes %+v, but got %+v", expectedChanges, catchments.Changes)
+	}
+}
@@ -0,0 +1,80 @@
+package catchment
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/asn"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+type hostnameSite struct {
+	suffix string
+	site   string
+}
+
+// SiteMap assigns router addresses to the anycast sites they belong to, either by the address itself or by its hostname
+type SiteMap struct {
+	prefixes  asn.PrefixMap[string]
+	hostnames []hostnameSite
+}
+
+func MakeSiteMap() SiteMap {
+	return SiteMap{prefixes: asn.MakePrefixMap[string]()}
+}
+
+// ReadSiteMap reads one mapping per line in the form "<address, network or hostname suffix> <site>". Empty lines and
+// lines starting with # are skipped. When multiple hostname suffixes match, the first one listed is used.
+func ReadSiteMap(reader io.Reader) (sites SiteMap, err error) {
+	sites = MakeSiteMap()
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return sites, fmt.Errorf("expected an address and site on line %d, but got %q", lineNumber, line)
+		}
+
+		if prefix, err := netip.ParsePrefix(fields[0]); err == nil {
+			sites.prefixes.Set(prefix.Masked(), fields[1])
+		} else if addr, err := netip.ParseAddr(fields[0]); err == nil {
+			sites.prefixes.Set(netip.PrefixFrom(addr, addr.BitLen()), fields[1])
+		} else {
+			suffix := strings.TrimPrefix(strings.ToLower(strings.TrimSuffix(fields[0], ".")), "*")
+			sites.hostnames = append(sites.hostnames, hostnameSite{
+				suffix: strings.TrimPrefix(suffix, "."),
+				site:   fields[1],
+			})
+		}
+	}
+
+	err = scanner.Err()
+	return
+}
+
+// Lookup finds the site of an address. Addresses and networks take priority over hostname suffixes. The hostname may be
+// left empty if it is not known.
+func (sites *SiteMap) Lookup(addr netip.Addr, hostname string) (string, bool) {
+	if site, ok := sites.prefixes.GetAddr(addr); ok {
+		return site, true
+	}
+
+	if hostname == "" {
+		return "", false
+	}
+
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	for _, entry := range sites.hostnames {
+		if hostname == entry.suffix || strings.HasSuffix(hostname, "."+entry.suffix) {
+			return entry.site, true
+		}
+	}
+
+	return "", false
+}
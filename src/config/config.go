@@ -8,21 +8,122 @@ var (
 	// StatisticsPeriod refers to the duration statistics are stored/collected for on measurements
 	StatisticsPeriod = makeConfig("STATISTICS_PERIOD", 14*24*time.Hour)
 
+	// StatisticsMode selects how moving statistics are kept over STATISTICS_PERIOD. With "bins", values are grouped into
+	// a fixed number of bins so any window within the period can be queried. With "ewma", values are kept as an
+	// exponentially weighted sum which reacts to recent changes right away, but windows shorter than the period are
+	// estimated by assuming values are spread evenly over it. Distributions always use bins.
+	StatisticsMode = makeConfig("STATISTICS_MODE", "bins")
+
+	// SummationBinCount and DistributionBinCount are the number of bins STATISTICS_PERIOD is split into for each type of
+	// moving statistic. More bins give a finer time resolution at the cost of memory. Averages share the bins of
+	// summations since they are read for the nodes and edges a summation found within a window, so both need to round
+	// the window out the same way.
+	SummationBinCount    = makeConfig("SUMMATION_BIN_COUNT", 100)
+	DistributionBinCount = makeConfig("DISTRIBUTION_BIN_COUNT", 100)
+
 	// LogTracerouteProgress enables logging the progress of traceroute ingestion
 	LogTracerouteProgress = makeConfig("LOG_TRACEROUTE_PROGRESS", false)
 
-	// CacheDirectory and CacheStoreDuration control where and how long cached files traceroute data are stored
-	CacheDirectory     = makeConfig("CACHE_DIR", ".cache")
-	CacheStoreDuration = makeConfig("CACHE_DURATION", 12*time.Hour)
+	// CacheDirectory controls where cached traceroute data is stored. Results are cached in segments of
+	// CacheSegmentDuration per measurement. A segment is only treated as complete once CacheSettleDuration has passed
+	// since the end of the segment, giving probes time to upload late results. Consecutive missing segments are fetched
+	// together, up to CacheSegmentsPerRequest segments in a single request.
+	CacheDirectory          = makeConfig("CACHE_DIR", ".cache")
+	CacheSegmentDuration    = makeConfig("CACHE_SEGMENT_DURATION", time.Hour)
+	CacheSettleDuration     = makeConfig("CACHE_SETTLE_DURATION", time.Hour)
+	CacheSegmentsPerRequest = makeConfig("CACHE_SEGMENTS_PER_REQUEST", 24)
+
+	// DedupIndexSize is the number of results remembered per measurement in order to ignore results which are received
+	// more than once. The default covers 100 probes reporting every 15 minutes over the default statistics period.
+	DedupIndexSize = makeConfig("DEDUP_INDEX_SIZE", 150000)
+
+	// EventLogSize is the maximum number of events of each type, such as path changes, which are kept in memory
+	EventLogSize = makeConfig("EVENT_LOG_SIZE", 10000)
+
+	// PathChangeWindow is the number of recent results used to find the dominant path between a probe and destination.
+	// A path change is only recorded once a new path is used by the majority of these results.
+	PathChangeWindow = makeConfig("PATH_CHANGE_WINDOW", 3)
+
+	// LoopPersistenceThreshold is the number of consecutive results a routing loop must appear in before it is treated
+	// as persistent instead of transient
+	LoopPersistenceThreshold = makeConfig("LOOP_PERSISTENCE_THRESHOLD", 3)
+
+	// AsymmetricPathThreshold is the number of hops the estimated return path length of a node may differ from its
+	// forward path length before the node is flagged as having an asymmetric return path
+	AsymmetricPathThreshold = makeConfig("ASYMMETRIC_PATH_THRESHOLD", 3.0)
+
+	// LatencyIncreaseThreshold is the RTT increase in milliseconds at least 90% of the results using an edge need to see
+	// for the edge to be flagged as adding latency
+	LatencyIncreaseThreshold = makeConfig("LATENCY_INCREASE_THRESHOLD", 10.0)
+
+	// AnomalyThreshold and AnomalyCriticalThreshold are the robust z-scores the RTT of a hop needs to reach above its
+	// baseline to be recorded as a warning or critical anomaly. Baselines are taken from up to AnomalyBaselineSamples of
+	// the most recent results within the statistics period, need AnomalyMinSamples results and are recomputed every
+	// AnomalyBaselineRefresh. Each sample takes 8 bytes for every node of every route. AnomalyMinDeviation is the smallest
+	// spread in milliseconds assumed for a baseline, so very stable hops do not flag differences which are too small to
+	// matter.
+	AnomalyThreshold         = makeConfig("ANOMALY_THRESHOLD", 3.5)
+	AnomalyCriticalThreshold = makeConfig("ANOMALY_CRITICAL_THRESHOLD", 7.0)
+	AnomalyMinSamples        = makeConfig("ANOMALY_MIN_SAMPLES", 30)
+	AnomalyBaselineSamples   = makeConfig("ANOMALY_BASELINE_SAMPLES", 1000)
+	AnomalyMinDeviation      = makeConfig("ANOMALY_MIN_DEVIATION", 1.0)
+	AnomalyBaselineRefresh   = makeConfig("ANOMALY_BASELINE_REFRESH", time.Hour)
+
+	// AlertEvaluationPeriod is how often every alerting rule is checked against every route, in addition to checking the
+	// rules of a route whenever a result is added to it. AlertWebhookTimeout limits how long delivering a notification
+	// may take.
+	AlertEvaluationPeriod = makeConfig("ALERT_EVALUATION_PERIOD", time.Minute)
+	AlertWebhookTimeout   = makeConfig("ALERT_WEBHOOK_TIMEOUT", 10*time.Second)
+
+	// StreamBufferSize is the number of messages held for each client of the live update stream. Clients which fall this
+	// far behind are disconnected. StreamKeepalivePeriod is how often a comment is sent on idle streams so proxies do not
+	// close them.
+	StreamBufferSize      = makeConfig("STREAM_BUFFER_SIZE", 64)
+	StreamKeepalivePeriod = makeConfig("STREAM_KEEPALIVE_PERIOD", 15*time.Second)
+
+	// AliasFile is an optional ITDK or MIDAR nodes file listing known router aliases. AliasRefreshPeriod is how often
+	// aliases are inferred again from the collected traceroute data.
+	AliasFile          = makeConfig("ALIAS_FILE", "")
+	AliasRefreshPeriod = makeConfig("ALIAS_REFRESH_PERIOD", 10*time.Minute)
+
+	// RdnsServer is the address (host:port) of the DNS server used for reverse lookups. The system resolver is used if it
+	// is left empty. Results are cached under CACHE_DIR for RdnsCacheDuration.
+	RdnsServer        = makeConfig("RDNS_SERVER", "")
+	RdnsCacheDuration = makeConfig("RDNS_CACHE_DURATION", 7*24*time.Hour)
+
+	// RdnsRulesFile is an optional file of regular expressions used to find the location of a router from its hostname,
+	// one per line. Each expression should have a named iata or city group. The default rules are used if it is empty.
+	RdnsRulesFile = makeConfig("RDNS_RULES_FILE", "")
+
+	// GeoIpDatabase is an optional geolocation database used to find the location of traceroute hops. Files ending in
+	// .mmdb are read as MaxMind databases, and other files are read as CSV. The file is reloaded when it changes.
+	GeoIpDatabase = makeConfig("GEOIP_DATABASE", "")
+
+	// CatchmentSiteFile optionally maps router addresses, networks or hostname suffixes to the anycast site they belong
+	// to. Routers which are not listed fall back to the location found from their reverse DNS hostname.
+	CatchmentSiteFile = makeConfig("CATCHMENT_SITE_FILE", "")
 
 	MinCleanEdgeWeight = makeConfig("MIN_CLEAN_EDGE_WEIGHT", 0.1)
 
+	// MinAggregateEdgeWeight is the share of the traffic leaving a node an edge needs in the aggregate graph of a
+	// destination, divided evenly between the outbound edges of the node the same way as MIN_CLEAN_EDGE_WEIGHT.
+	MinAggregateEdgeWeight = makeConfig("MIN_AGGREGATE_EDGE_WEIGHT", 0.1)
+
 	ProbeCollectionRefreshPeriod = makeConfig("PROBE_COLLECTION_REFRESH_PERIOD", 24*time.Hour)
 
 	RequestByteLimit = makeConfig("REQUEST_BYTE_LIMIT", 4096)
 
 	DebugMeasurementList = makeConfig("ATLAS_DEBUG_MEASUREMENTS", []int{47072659, 47072660})
 
+	// LiveReconnectMinBackoff and LiveReconnectMaxBackoff bound how long to wait before reconnecting to the RIPE Atlas
+	// stream after a live collection stream is dropped
+	LiveReconnectMinBackoff = makeConfig("LIVE_RECONNECT_MIN_BACKOFF", 5*time.Second)
+	LiveReconnectMaxBackoff = makeConfig("LIVE_RECONNECT_MAX_BACKOFF", 5*time.Minute)
+
+	// LiveBackfillOverlap is how long before the last received result to start backfilling after reconnecting to a
+	// stream. Results are not published in timestamp order, so some results older than the last one may still be missing.
+	LiveBackfillOverlap = makeConfig("LIVE_BACKFILL_OVERLAP", 15*time.Minute)
+
 	// CleanupPeriod refers to how often we clean up our data
 	CleanupPeriod = makeConfig("CLEANUP_PERIOD", 24*time.Hour)
 )
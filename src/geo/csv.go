@@ -0,0 +1,77 @@
+package geo
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/asn"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+)
+
+// csvDatabase holds locations read from a CSV file with the columns: network, latitude, longitude, country, city. The
+// country and city columns may be left empty. An optional header row starting with "network" is skipped.
+type csvDatabase struct {
+	locations asn.PrefixMap[Location]
+}
+
+func openCsv(path string) (Database, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer util.CloseAndLogErrors("Failed to close geolocation database:", file)
+
+	return readCsv(file)
+}
+
+func readCsv(input io.Reader) (Database, error) {
+	database := csvDatabase{locations: asn.MakePrefixMap[Location]()}
+
+	reader := csv.NewReader(input)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 5
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if record[0] == "network" {
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		prefix, err := netip.ParsePrefix(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid network on line %d: %w", line, err)
+		}
+
+		location := Location{Country: record[3], City: record[4]}
+		if location.Latitude, err = strconv.ParseFloat(record[1], 64); err != nil {
+			return nil, fmt.Errorf("invalid latitude on line %d: %w", line, err)
+		}
+
+		if location.Longitude, err = strconv.ParseFloat(record[2], 64); err != nil {
+			return nil, fmt.Errorf("invalid longitude on line %d: %w", line, err)
+		}
+
+		database.locations.Set(prefix.Masked(), location)
+	}
+
+	return database, nil
+}
+
+func (database csvDatabase) Lookup(addr netip.Addr) (Location, bool) {
+	return database.locations.GetAddr(addr)
+}
+
+func (csvDatabase) Close() error {
+	return nil
+}
@@ -0,0 +1,58 @@
+package geo
+
+import (
+	"math"
+	"net/netip"
+	"path/filepath"
+	"strings"
+)
+
+// Location is the position an address has been geolocated to
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Country   string  `json:"country,omitempty"`
+	City      string  `json:"city,omitempty"`
+}
+
+// Database finds the location of addresses. Implementations must be safe to use from multiple goroutines.
+type Database interface {
+	Lookup(addr netip.Addr) (Location, bool)
+	Close() error
+}
+
+// Open reads a geolocation database from disk. Files ending in .mmdb are read as MaxMind databases and everything else is
+// read as CSV.
+func Open(path string) (Database, error) {
+	if strings.EqualFold(filepath.Ext(path), ".mmdb") {
+		return openMmdb(path)
+	}
+
+	return openCsv(path)
+}
+
+const earthRadiusKm = 6371.0
+
+// DistanceKm finds the great circle distance between two locations
+func DistanceKm(a, b Location) float64 {
+	toRadians := func(degrees float64) float64 {
+		return degrees * math.Pi / 180
+	}
+
+	latA, latB := toRadians(a.Latitude), toRadians(b.Latitude)
+	deltaLat := latB - latA
+	deltaLon := toRadians(b.Longitude - a.Longitude)
+
+	h := math.Pow(math.Sin(deltaLat/2), 2) + math.Cos(latA)*math.Cos(latB)*math.Pow(math.Sin(deltaLon/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// maxKmPerRttMs is the furthest a packet can travel away from the probe per millisecond of RTT. Light in fiber travels
+// about 200km per millisecond, and the packet needs to make the trip in both directions.
+const maxKmPerRttMs = 100.0
+
+// IsPlausible checks if a reply could have come from the given location within the RTT it was received in. A location
+// which is further away than light could have travelled indicates the geolocation is wrong.
+func IsPlausible(probe, hop Location, rttMs float64) bool {
+	return DistanceKm(probe, hop) <= rttMs*maxKmPerRttMs
+}
@@ -0,0 +1,59 @@
+package geo
+
+import (
+	"math"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const testCsv = `# Sample geolocation data
+network,latitude,longitude,country,city
+192.0.2.0/24,51.5074,-0.1278,GB,London
+192.0.2.128/25,52.3676,4.9041,NL,Amsterdam
+2001:db8::/32,40.7128,-74.0060,US,
+`
+
+func TestCsvDatabase(t *testing.T) {
+	database, err := readCsv(strings.NewReader(testCsv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]Location{
+		"192.0.2.1":   {Latitude: 51.5074, Longitude: -0.1278, Country: "GB", City: "London"},
+		"192.0.2.200": {Latitude: 52.3676, Longitude: 4.9041, Country: "NL", City: "Amsterdam"},
+		"2001:db8::1": {Latitude: 40.7128, Longitude: -74.0060, Country: "US"},
+	}
+
+	for addr, expected := range cases {
+		if location, ok := database.Lookup(netip.MustParseAddr(addr)); !ok || location != expected {
+			t.Errorf("Expected %s to be located at %+v, but got %+v", addr, expected, location)
+		}
+	}
+
+	if location, ok := database.Lookup(netip.MustParseAddr("198.51.100.1")); ok {
+		t.Errorf("Expected no location, but got %+v", location)
+	}
+
+	if _, err = readCsv(strings.NewReader("192.0.2.0/24,north,0,GB,London\n")); err == nil {
+		t.Error("Expected invalid latitude to be rejected")
+	}
+}
+
+func TestIsPlausible(t *testing.T) {
+	london := Location{Latitude: 51.5074, Longitude: -0.1278}
+	newYork := Location{Latitude: 40.7128, Longitude: -74.0060}
+
+	if distance := DistanceKm(london, newYork); math.Abs(distance-5570) > 10 {
+		t.Errorf("Expected London to be about 5570km from New York, but got %.0fkm", distance)
+	}
+
+	if !IsPlausible(london, newYork, 70) {
+		t.Error("Expected a 70ms RTT from London to New York to be plausible")
+	}
+
+	if IsPlausible(london, newYork, 5) {
+		t.Error("Expected a 5ms RTT from London to New York to be impossible")
+	}
+}
@@ -0,0 +1,52 @@
+package geo
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	"net/netip"
+)
+
+// mmdbRecord holds the fields used from the MaxMind GeoIP2/GeoLite2 City format
+type mmdbRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type mmdbDatabase struct {
+	reader *maxminddb.Reader
+}
+
+func openMmdb(path string) (Database, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return mmdbDatabase{reader: reader}, nil
+}
+
+func (database mmdbDatabase) Lookup(addr netip.Addr) (Location, bool) {
+	var record mmdbRecord
+	_, ok, err := database.reader.LookupNetwork(addr.AsSlice(), &record)
+	if err != nil || !ok || record.Location.Latitude == nil || record.Location.Longitude == nil {
+		return Location{}, false
+	}
+
+	return Location{
+		Latitude:  *record.Location.Latitude,
+		Longitude: *record.Location.Longitude,
+		Country:   record.Country.IsoCode,
+		City:      record.City.Names["en"],
+	}, true
+}
+
+func (database mmdbDatabase) Close() error {
+	return database.reader.Close()
+}
@@ -0,0 +1,173 @@
+package geo
+
+import (
+	"encoding/binary"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// encodeMmdbValue encodes strings, doubles, unsigned integers, arrays and maps in the MaxMind DB data section format.
+// Only values with fewer than 29 entries or bytes are supported.
+func encodeMmdbValue(t *testing.T, value any) []byte {
+	control := func(kind, size int) []byte {
+		if size >= 29 {
+			t.Fatalf("Value of size %d is too large for a test database", size)
+		}
+
+		// Types above 7 are stored in an extra byte after the size
+		if kind <= 7 {
+			return []byte{byte(kind<<5 | size)}
+		}
+
+		return []byte{byte(size), byte(kind - 7)}
+	}
+
+	switch value := value.(type) {
+	case string:
+		return append(control(2, len(value)), value...)
+	case float64:
+		return binary.BigEndian.AppendUint64(control(3, 8), math.Float64bits(value))
+	case uint32:
+		return binary.BigEndian.AppendUint32(control(6, 4), value)
+	case []any:
+		encoded := control(11, len(value))
+		for _, item := range value {
+			encoded = append(encoded, encodeMmdbValue(t, item)...)
+		}
+		return encoded
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		encoded := control(7, len(value))
+		for _, key := range keys {
+			encoded = append(encoded, encodeMmdbValue(t, key)...)
+			encoded = append(encoded, encodeMmdbValue(t, value[key])...)
+		}
+		return encoded
+	default:
+		t.Fatalf("Unsupported value %#v", value)
+		return nil
+	}
+}
+
+// writeTestMmdb writes an IPv4 MaxMind database with 24 bit records to a temporary file. Networks must not overlap.
+func writeTestMmdb(t *testing.T, networks map[string]map[string]any) string {
+	const emptyRecord = -1
+
+	// Records holding data are stored as -2 - offset until the number of nodes is known
+	var data []byte
+	nodes := [][2]int{{emptyRecord, emptyRecord}}
+	for network, record := range networks {
+		prefix := netip.MustParsePrefix(network)
+		bytes := prefix.Addr().As4()
+
+		node := 0
+		for depth := 0; depth < prefix.Bits(); depth++ {
+			bit := int(bytes[depth/8]>>(7-depth%8)) & 1
+			if depth+1 == prefix.Bits() {
+				nodes[node][bit] = -2 - len(data)
+				break
+			}
+
+			if nodes[node][bit] == emptyRecord {
+				nodes = append(nodes, [2]int{emptyRecord, emptyRecord})
+				nodes[node][bit] = len(nodes) - 1
+			}
+
+			node = nodes[node][bit]
+		}
+
+		data = append(data, encodeMmdbValue(t, record)...)
+	}
+
+	var file []byte
+	for _, records := range nodes {
+		for _, record := range records {
+			switch {
+			case record == emptyRecord:
+				record = len(nodes)
+			case record < emptyRecord:
+				// Pointers into the data section skip over the 16 byte separator
+				record = len(nodes) + 16 + (-2 - record)
+			}
+
+			file = append(file, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+
+	file = append(file, make([]byte, 16)...)
+	file = append(file, data...)
+	file = append(file, "\xAB\xCD\xEFMaxMind.com"...)
+	file = append(file, encodeMmdbValue(t, map[string]any{
+		"binary_format_major_version": uint32(2),
+		"binary_format_minor_version": uint32(0),
+		"build_epoch":                 uint32(1672531200),
+		"database_type":               "Test-City",
+		"description":                 map[string]any{"en": "Test database"},
+		"ip_version":                  uint32(4),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint32(24),
+	})...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestMmdbDatabase(t *testing.T) {
+	path := writeTestMmdb(t, map[string]map[string]any{
+		"192.0.2.0/25": {
+			"city":     map[string]any{"names": map[string]any{"en": "London", "de": "London"}},
+			"country":  map[string]any{"iso_code": "GB"},
+			"location": map[string]any{"latitude": 51.5074, "longitude": -0.1278},
+		},
+		"192.0.2.128/25": {
+			"country":  map[string]any{"iso_code": "NL"},
+			"location": map[string]any{"latitude": 52.3676, "longitude": 4.9041},
+		},
+		// Some networks are only known down to their country
+		"198.51.100.0/24": {
+			"country": map[string]any{"iso_code": "US"},
+		},
+	})
+
+	database, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := database.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	cases := map[string]Location{
+		"192.0.2.1":   {Latitude: 51.5074, Longitude: -0.1278, Country: "GB", City: "London"},
+		"192.0.2.200": {Latitude: 52.3676, Longitude: 4.9041, Country: "NL"},
+	}
+
+	for addr, expected := range cases {
+		if location, ok := database.Lookup(netip.MustParseAddr(addr)); !ok || location != expected {
+			t.Errorf("Expected %s to be located at %+v, but got %+v", addr, expected, location)
+		}
+	}
+
+	for _, addr := range []string{"198.51.100.1", "203.0.113.1"} {
+		if location, ok := database.Lookup(netip.MustParseAddr(addr)); ok {
+			t.Errorf("Expected no location for %s, but got %+v", addr, location)
+		}
+	}
+}
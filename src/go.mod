@@ -7,6 +7,8 @@ require (
 	github.com/gin-gonic/gin v1.8.2
 	github.com/jmeggitt/nradix v0.0.0-20221104060745-76d400a5df10
 	github.com/joho/godotenv v1.4.0
+	github.com/oschwald/maxminddb-golang v1.10.0
+	golang.org/x/net v0.4.0
 )
 
 require (
@@ -27,7 +29,6 @@ require (
 	github.com/ugorji/go/codec v1.2.7 // indirect
 	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3 // indirect
 	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4 // indirect
-	golang.org/x/net v0.4.0 // indirect
 	golang.org/x/sys v0.3.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
 	golang.org/x/tools v0.1.12 // indirect
@@ -0,0 +1,112 @@
+package rdns
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const cacheFileName = "rdns.json"
+
+type cacheEntry struct {
+	// Hostname is empty if the address does not have a PTR record
+	Hostname string    `json:"hostname"`
+	Resolved time.Time `json:"resolved"`
+}
+
+// Cache stores the results of reverse DNS lookups and persists them to a JSON file so they are kept across restarts
+type Cache struct {
+	path    string
+	maxAge  time.Duration
+	entries map[netip.Addr]cacheEntry
+	dirty   bool
+	lock    sync.RWMutex
+}
+
+// LoadCache reads the cache stored in the given directory. A missing or corrupt cache file results in an empty cache,
+// since every entry can be resolved again.
+func LoadCache(directory string, maxAge time.Duration) (*Cache, error) {
+	cache := &Cache{
+		path:    filepath.Join(directory, cacheFileName),
+		maxAge:  maxAge,
+		entries: make(map[netip.Addr]cacheEntry),
+	}
+
+	data, err := os.ReadFile(cache.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &cache.entries); err != nil {
+		log.Println("Ignoring corrupt reverse DNS cache", cache.path, ":", err)
+		cache.entries = make(map[netip.Addr]cacheEntry)
+	}
+
+	return cache, nil
+}
+
+// Get gets the hostname of an address. The second return value is false if the address has not been resolved or the
+// previous result has expired.
+func (cache *Cache) Get(addr netip.Addr) (hostname string, present bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	entry, ok := cache.entries[addr]
+	if !ok || time.Since(entry.Resolved) > cache.maxAge {
+		return "", false
+	}
+
+	return entry.Hostname, true
+}
+
+func (cache *Cache) Set(addr netip.Addr, hostname string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.entries[addr] = cacheEntry{
+		Hostname: hostname,
+		Resolved: time.Now(),
+	}
+	cache.dirty = true
+}
+
+// Save writes the cache to disk if it has changed since it was last saved. Expired entries are dropped.
+func (cache *Cache) Save() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if !cache.dirty {
+		return nil
+	}
+
+	for addr, entry := range cache.entries {
+		if time.Since(entry.Resolved) > cache.maxAge {
+			delete(cache.entries, addr)
+		}
+	}
+
+	data, err := json.Marshal(cache.entries)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interrupted save does not corrupt the existing cache
+	temporaryPath := cache.path + ".tmp"
+	if err = os.WriteFile(temporaryPath, data, 0644); err != nil {
+		return err
+	}
+
+	if err = os.Rename(temporaryPath, cache.path); err != nil {
+		return err
+	}
+
+	cache.dirty = false
+	return nil
+}
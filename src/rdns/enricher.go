@@ -0,0 +1,137 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lookupTimeout is the longest a single reverse lookup may take before it is treated as failed
+const lookupTimeout = 5 * time.Second
+
+// MakeNetResolver creates a resolver which sends queries to the given DNS server. If the server is empty, the system
+// resolver is used instead.
+func MakeNetResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// Enricher resolves the hostnames of addresses in the background. Lookups only read from the cache and queue addresses
+// which have not been resolved yet, so they never wait on the network.
+type Enricher struct {
+	cache    *Cache
+	resolver *net.Resolver
+	rules    []Rule
+
+	queue   chan netip.Addr
+	pending map[netip.Addr]struct{}
+	lock    sync.Mutex
+}
+
+func MakeEnricher(cache *Cache, resolver *net.Resolver, rules []Rule, queueSize int) *Enricher {
+	return &Enricher{
+		cache:    cache,
+		resolver: resolver,
+		rules:    rules,
+		queue:    make(chan netip.Addr, queueSize),
+		pending:  make(map[netip.Addr]struct{}),
+	}
+}
+
+// GetHostname gets the cached hostname of an address. If it has not been resolved, it is queued to be resolved and
+// false is returned.
+func (enricher *Enricher) GetHostname(addr netip.Addr) (string, bool) {
+	if hostname, ok := enricher.cache.Get(addr); ok {
+		return hostname, hostname != ""
+	}
+
+	enricher.enqueue(addr)
+	return "", false
+}
+
+// GetLocation gets the location of an address based on its cached hostname
+func (enricher *Enricher) GetLocation(addr netip.Addr) (Location, bool) {
+	hostname, ok := enricher.GetHostname(addr)
+	if !ok {
+		return Location{}, false
+	}
+
+	return FindLocation(enricher.rules, hostname)
+}
+
+func (enricher *Enricher) enqueue(addr netip.Addr) {
+	// Private addresses will not have public PTR records
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return
+	}
+
+	enricher.lock.Lock()
+	defer enricher.lock.Unlock()
+
+	if _, ok := enricher.pending[addr]; ok {
+		return
+	}
+
+	select {
+	case enricher.queue <- addr:
+		enricher.pending[addr] = struct{}{}
+	default:
+		// The queue is full, so the address will be queued again the next time it is looked up
+	}
+}
+
+// Run resolves queued addresses until the context is cancelled
+func (enricher *Enricher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case addr := <-enricher.queue:
+			enricher.resolve(ctx, addr)
+		}
+	}
+}
+
+func (enricher *Enricher) resolve(ctx context.Context, addr netip.Addr) {
+	defer func() {
+		enricher.lock.Lock()
+		delete(enricher.pending, addr)
+		enricher.lock.Unlock()
+	}()
+
+	lookupCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	names, err := enricher.resolver.LookupAddr(lookupCtx, addr.String())
+
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		// Leave addresses uncached after temporary failures so they can be retried later
+		return
+	}
+
+	hostname := ""
+	if len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+	}
+
+	enricher.cache.Set(addr, hostname)
+}
+
+// Save persists the hostnames which have been resolved
+func (enricher *Enricher) Save() error {
+	return enricher.cache.Save()
+}
@@ -0,0 +1,202 @@
+package rdns
+
+import (
+	"context"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTestDnsServer serves PTR records from the given map of reverse names to hostnames over UDP. Names which are not
+// in the map get an NXDOMAIN response.
+func startTestDnsServer(t *testing.T, records map[string]string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+
+			var request dnsmessage.Message
+			if err = request.Unpack(buffer[:n]); err != nil || len(request.Questions) != 1 {
+				continue
+			}
+
+			question := request.Questions[0]
+			response := dnsmessage.Message{
+				Header: dnsmessage.Header{
+					ID:                 request.ID,
+					Response:           true,
+					Authoritative:      true,
+					RecursionDesired:   request.RecursionDesired,
+					RecursionAvailable: true,
+				},
+				Questions: request.Questions,
+			}
+
+			if hostname, ok := records[question.Name.String()]; ok && question.Type == dnsmessage.TypePTR {
+				response.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{
+						Name:  question.Name,
+						Type:  dnsmessage.TypePTR,
+						Class: dnsmessage.ClassINET,
+						TTL:   60,
+					},
+					Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(hostname)},
+				}}
+			} else {
+				response.RCode = dnsmessage.RCodeNameError
+			}
+
+			packed, err := response.Pack()
+			if err != nil {
+				continue
+			}
+
+			_, _ = conn.WriteTo(packed, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func waitForHostname(t *testing.T, enricher *Enricher, addr netip.Addr) (string, bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if hostname, ok := enricher.cache.Get(addr); ok {
+			return hostname, hostname != ""
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Timed out waiting for %v to be resolved", addr)
+	return "", false
+}
+
+func TestEnricherResolvesInBackground(t *testing.T) {
+	server := startTestDnsServer(t, map[string]string{
+		"1.2.0.192.in-addr.arpa.": "ae1.cr2.lax1.example.net.",
+	})
+
+	cacheDir := t.TempDir()
+	cache, err := LoadCache(cacheDir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enricher := MakeEnricher(cache, MakeNetResolver(server), DefaultRules(), 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go enricher.Run(ctx)
+
+	named := netip.MustParseAddr("192.0.2.1")
+	if _, ok := enricher.GetHostname(named); ok {
+		t.Fatal("Expected hostname to not be known before it is resolved")
+	}
+
+	if hostname, ok := waitForHostname(t, enricher, named); !ok || hostname != "ae1.cr2.lax1.example.net" {
+		t.Fatalf("Expected hostname ae1.cr2.lax1.example.net, but got %q", hostname)
+	}
+
+	if location, ok := enricher.GetLocation(named); !ok || location.Iata != "LAX" {
+		t.Errorf("Expected location to be LAX, but got %+v", location)
+	}
+
+	// Addresses without a PTR record are cached so they are not looked up again
+	unnamed := netip.MustParseAddr("192.0.2.2")
+	enricher.GetHostname(unnamed)
+	if _, ok := waitForHostname(t, enricher, unnamed); ok {
+		t.Error("Expected address without a PTR record to not have a hostname")
+	}
+
+	// Hostnames should be read back from the cache after a restart
+	if err = enricher.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadCache(cacheDir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hostname, ok := reloaded.Get(named); !ok || hostname != "ae1.cr2.lax1.example.net" {
+		t.Errorf("Expected hostname to be cached, but got %q", hostname)
+	}
+
+	if hostname, ok := reloaded.Get(unnamed); !ok || hostname != "" {
+		t.Errorf("Expected missing hostname to be cached, but got %q (present: %v)", hostname, ok)
+	}
+}
+
+func TestFindLocation(t *testing.T) {
+	custom, err := ParseRule(`^[^.]+\.(?P<city>[a-z]+)\.example\.org$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := append([]Rule{custom}, DefaultRules()...)
+
+	cases := map[string]Location{
+		"ae1.cr2.lax1.example.net.":  {Iata: "LAX"},
+		"xe-0-0-1.fra03.example.net": {Iata: "FRA"},
+		"be10.amsterdam.example.org": {City: "amsterdam"},
+		"TenGig1.JFK2.Example.Net":   {Iata: "JFK"},
+	}
+
+	for hostname, expected := range cases {
+		if location, ok := FindLocation(rules, hostname); !ok || location != expected {
+			t.Errorf("Expected %q to be located at %+v, but got %+v", hostname, expected, location)
+		}
+	}
+
+	if location, ok := FindLocation(rules, "customer.example.com"); ok {
+		t.Errorf("Expected no location, but got %+v", location)
+	}
+
+	if _, err = ParseRule(`(?P<site>[a-z]+)`); err == nil {
+		t.Error("Expected rule without an iata or city group to be rejected")
+	}
+}
+
+func TestCorruptCacheIsIgnored(t *testing.T) {
+	cacheDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(cacheDir, cacheFileName), []byte(`{"192.0.2.1":{"hostname":"ae1.cr2`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A cache truncated by an interrupted write should not prevent the server from starting
+	cache, err := LoadCache(cacheDir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Get(netip.MustParseAddr("192.0.2.1")); ok {
+		t.Error("Expected corrupt cache to be empty")
+	}
+
+	cache.Set(netip.MustParseAddr("192.0.2.2"), "ae2.cr1.ams1.example.net")
+	if err = cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadCache(cacheDir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hostname, ok := reloaded.Get(netip.MustParseAddr("192.0.2.2")); !ok || hostname != "ae2.cr1.ams1.example.net" {
+		t.Errorf("Expected corrupt cache to be replaced on save, but got %q", hostname)
+	}
+}
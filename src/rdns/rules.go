@@ -0,0 +1,92 @@
+package rdns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Location is the place a router is believed to be in based on its hostname
+type Location struct {
+	Iata string `json:"iata,omitempty"`
+	City string `json:"city,omitempty"`
+}
+
+// Rule extracts a location from hostnames matching a regular expression. The named groups "iata" and "city" are used to
+// fill in the matching fields of the location.
+type Rule struct {
+	pattern *regexp.Regexp
+}
+
+func ParseRule(pattern string) (Rule, error) {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	if compiled.SubexpIndex("iata") < 0 && compiled.SubexpIndex("city") < 0 {
+		return Rule{}, fmt.Errorf("rule %q does not have an iata or city group", pattern)
+	}
+
+	return Rule{pattern: compiled}, nil
+}
+
+func (rule Rule) apply(hostname string) (location Location, ok bool) {
+	match := rule.pattern.FindStringSubmatch(hostname)
+	if match == nil {
+		return
+	}
+
+	if index := rule.pattern.SubexpIndex("iata"); index >= 0 {
+		location.Iata = strings.ToUpper(match[index])
+	}
+
+	if index := rule.pattern.SubexpIndex("city"); index >= 0 {
+		location.City = match[index]
+	}
+
+	return location, location.Iata != "" || location.City != ""
+}
+
+// DefaultRules finds IATA codes used as a label or label prefix followed by a site number, as in ae1.cr2.lax1.example.net
+func DefaultRules() []Rule {
+	return []Rule{
+		{pattern: regexp.MustCompile(`(?:^|[.-])(?P<iata>[a-z]{3})\d{1,2}(?:[.-]|$)`)},
+	}
+}
+
+// ReadRules reads one rule per line. Empty lines and lines starting with # are skipped.
+func ReadRules(reader io.Reader) (rules []Rule, err error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule Rule
+		if rule, err = ParseRule(line); err != nil {
+			return
+		}
+
+		rules = append(rules, rule)
+	}
+
+	err = scanner.Err()
+	return
+}
+
+// FindLocation applies the first rule which matches the hostname
+func FindLocation(rules []Rule, hostname string) (Location, bool) {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+
+	for _, rule := range rules {
+		if location, ok := rule.apply(hostname); ok {
+			return location, true
+		}
+	}
+
+	return Location{}, false
+}
@@ -0,0 +1,196 @@
+package rest_api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+type aggregateRequest struct {
+	DestinationIp netip.Addr
+	// ProbeAsn and Country only include probes in the given network or country when set
+	ProbeAsn uint32
+	Country  string
+	// MinEdgeWeight overrides MIN_AGGREGATE_EDGE_WEIGHT when set
+	MinEdgeWeight *float64
+	Window        traceroute.TimeRange
+}
+
+func (request *aggregateRequest) UnmarshalJSON(bytes []byte) (err error) {
+	var buffer struct {
+		DestinationIp string   `json:"destinationIp"`
+		ProbeAsn      uint32   `json:"probeAsn"`
+		Country       string   `json:"country"`
+		MinEdgeWeight *float64 `json:"minEdgeWeight"`
+		Start         *int64   `json:"start"`
+		End           *int64   `json:"end"`
+	}
+
+	if err = json.Unmarshal(bytes, &buffer); err != nil {
+		return
+	}
+
+	if request.DestinationIp, err = netip.ParseAddr(buffer.DestinationIp); err != nil {
+		return
+	}
+
+	if buffer.MinEdgeWeight != nil && (*buffer.MinEdgeWeight < 0 || *buffer.MinEdgeWeight > 1) {
+		return errors.New("minEdgeWeight must be between 0 and 1")
+	}
+
+	request.ProbeAsn = buffer.ProbeAsn
+	request.Country = strings.ToUpper(buffer.Country)
+	request.MinEdgeWeight = buffer.MinEdgeWeight
+	request.Window, err = parseWindow(buffer.Start, buffer.End)
+	return
+}
+
+func (request aggregateRequest) hasProbeFilter() bool {
+	return request.ProbeAsn != 0 || request.Country != ""
+}
+
+// findMatchingProbes finds the probes targeting the destination which match the ASN and country filters of the request
+func (state DataRoute) findMatchingProbes(request aggregateRequest) map[int]struct{} {
+	state.ProbeDataLock.RLock()
+	defer state.ProbeDataLock.RUnlock()
+
+	probes := make(map[int]struct{})
+	for _, usage := range state.DestinationToProbeMap[request.DestinationIp] {
+		probeAsn := usage.Probe.Asn4
+		if request.DestinationIp.Is6() {
+			probeAsn = usage.Probe.Asn6
+		}
+
+		if request.ProbeAsn != 0 && request.ProbeAsn != probeAsn {
+			continue
+		}
+
+		if request.Country != "" && request.Country != strings.ToUpper(usage.Probe.CountryCode) {
+			continue
+		}
+
+		probes[usage.Probe.Id] = struct{}{}
+	}
+
+	return probes
+}
+
+// GetTracerouteAggregate merges the clean graphs of every probe targeting a destination into a single graph
+func (state DataRoute) GetTracerouteAggregate(ctx *gin.Context) {
+	request, ok := readJsonRequestBody[aggregateRequest](ctx)
+	if !ok {
+		return
+	}
+
+	var matchingProbes map[int]struct{}
+	if request.hasProbeFilter() {
+		matchingProbes = state.findMatchingProbes(request)
+	}
+
+	state.TracerouteDataLock.Lock()
+	defer state.TracerouteDataLock.Unlock()
+
+	var routes []*traceroute.RouteData
+	for _, routeData := range state.TracerouteData.Routes() {
+		if routeData.GetDestination() != request.DestinationIp {
+			continue
+		}
+
+		if _, ok := matchingProbes[routeData.GetProbeId()]; matchingProbes != nil && !ok {
+			continue
+		}
+
+		// Align statistics so the edge statistics make sense
+		routeData.AlignStatisticsEndTime(time.Now())
+		routes = append(routes, routeData)
+	}
+
+	graph := traceroute.AggregateRoutes(routes, request.Window)
+	if graph.TotalUsage == 0 {
+		ctx.String(http.StatusServiceUnavailable, "no error-free data to provide: %+v\n", request)
+		return
+	}
+
+	minEdgeWeight := config.MinAggregateEdgeWeight.GetFloat()
+	if request.MinEdgeWeight != nil {
+		minEdgeWeight = *request.MinEdgeWeight
+	}
+
+	type NodeData struct {
+		Id            string   `json:"id"`
+		ProbeId       int      `json:"probeId,omitempty"`
+		Addresses     []string `json:"addresses,omitempty"`
+		Asn           uint32   `json:"asn,omitempty"`
+		AverageRtt    float64  `json:"averageRtt"`
+		LastUsed      int64    `json:"lastUsed"`
+		Usage         int64    `json:"usage"`
+		ProbeCount    int      `json:"probeCount"`
+		ProbeCoverage float64  `json:"probeCoverage"`
+	}
+
+	nodes := make([]NodeData, 0, len(graph.Nodes))
+	for id, node := range graph.Nodes {
+		var asn uint32
+		var addresses []string
+		if id.IsProbe() {
+			for _, addr := range node.Addresses {
+				addresses = append(addresses, addr.String())
+			}
+		} else {
+			asn, _ = state.GetIpToAsn(id.Ip)
+		}
+
+		nodes = append(nodes, NodeData{
+			Id:            id.String(),
+			ProbeId:       id.ProbeId,
+			Addresses:     addresses,
+			Asn:           asn,
+			AverageRtt:    node.GetAverageRtt(),
+			LastUsed:      node.LastUsed.Unix(),
+			Usage:         node.Usage,
+			ProbeCount:    len(node.Probes),
+			ProbeCoverage: float64(len(node.Probes)) / float64(len(graph.Probes)),
+		})
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Id < nodes[j].Id
+	})
+
+	type EdgeData struct {
+		Start                string  `json:"start"`
+		End                  string  `json:"end"`
+		OutboundCoverage     float64 `json:"outboundCoverage"`
+		TotalTrafficCoverage float64 `json:"totalTrafficCoverage"`
+		LastUsed             int64   `json:"lastUsed"`
+		Usage                int64   `json:"usage"`
+		ProbeCount           int     `json:"probeCount"`
+	}
+
+	prunedEdges := graph.PrunedEdges(minEdgeWeight)
+	edges := make([]EdgeData, 0, len(prunedEdges))
+	for _, edge := range prunedEdges {
+		edges = append(edges, EdgeData{
+			Start:                edge.Start.String(),
+			End:                  edge.End.String(),
+			OutboundCoverage:     edge.OutboundCoverage,
+			TotalTrafficCoverage: edge.NetUsage / float64(graph.TotalUsage),
+			LastUsed:             edge.LastUsed.Unix(),
+			Usage:                edge.Usage,
+			ProbeCount:           len(edge.Probes),
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"probeCount": len(graph.Probes),
+		"nodes":      nodes,
+		"edges":      edges,
+	})
+}
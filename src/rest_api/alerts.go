@@ -0,0 +1,109 @@
+package rest_api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/alert"
+	"net/http"
+)
+
+// readRuleRequest reads a rule from the request body, responding with an error if it is not valid
+func readRuleRequest(ctx *gin.Context) (alert.Rule, bool) {
+	rule, ok := readJsonRequestBody[alert.Rule](ctx)
+	if !ok {
+		return rule, false
+	}
+
+	if err := rule.Validate(); err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid rule: %s\n", err.Error())
+		return rule, false
+	}
+
+	return rule, true
+}
+
+func (state DataRoute) ListAlertRules(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, state.Alerts.Rules())
+}
+
+func (state DataRoute) GetAlertRule(ctx *gin.Context) {
+	rule, ok := state.Alerts.GetRule(ctx.Param("id"))
+	if !ok {
+		ctx.String(http.StatusNotFound, "No rule with id %q\n", ctx.Param("id"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rule)
+}
+
+func (state DataRoute) CreateAlertRule(ctx *gin.Context) {
+	rule, ok := readRuleRequest(ctx)
+	if !ok {
+		return
+	}
+
+	rule, err := state.Alerts.AddRule(rule)
+	if err != nil {
+		ctx.Status(http.StatusInternalServerError)
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, rule)
+}
+
+func (state DataRoute) UpdateAlertRule(ctx *gin.Context) {
+	rule, ok := readRuleRequest(ctx)
+	if !ok {
+		return
+	}
+
+	rule, err := state.Alerts.UpdateRule(ctx.Param("id"), rule)
+	if errors.Is(err, alert.ErrRuleNotFound) {
+		ctx.String(http.StatusNotFound, "No rule with id %q\n", ctx.Param("id"))
+		return
+	} else if err != nil {
+		ctx.Status(http.StatusInternalServerError)
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rule)
+}
+
+func (state DataRoute) DeleteAlertRule(ctx *gin.Context) {
+	err := state.Alerts.DeleteRule(ctx.Param("id"))
+	if errors.Is(err, alert.ErrRuleNotFound) {
+		ctx.String(http.StatusNotFound, "No rule with id %q\n", ctx.Param("id"))
+		return
+	} else if err != nil {
+		ctx.Status(http.StatusInternalServerError)
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (state DataRoute) ListActiveAlerts(ctx *gin.Context) {
+	type Response struct {
+		RuleId        string  `json:"ruleId"`
+		ProbeId       int     `json:"probeId"`
+		DestinationIp string  `json:"destinationIp"`
+		Since         int64   `json:"since"`
+		Value         float64 `json:"value"`
+	}
+
+	alerts := make([]Response, 0)
+	for _, active := range state.Alerts.Active() {
+		alerts = append(alerts, Response{
+			RuleId:        active.RuleId,
+			ProbeId:       active.ProbeId,
+			DestinationIp: active.Destination.String(),
+			Since:         active.Since.Unix(),
+			Value:         active.Value,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, alerts)
+}
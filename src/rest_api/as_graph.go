@@ -0,0 +1,144 @@
+package rest_api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// GetTracerouteAsGraph gets the graph of ASes crossed by a single probe, or every probe targeting the destination when
+// no probe is given
+func (state DataRoute) GetTracerouteAsGraph(ctx *gin.Context) {
+	destination, err := netip.ParseAddr(ctx.Query("destinationIp"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Could not read destination IP: %s\n", err.Error())
+		return
+	}
+
+	probeId := 0
+	if value, present := ctx.GetQuery("probeId"); present {
+		if probeId, err = strconv.Atoi(value); err != nil {
+			ctx.String(http.StatusBadRequest, "Could not read probe ID: %s\n", err.Error())
+			return
+		}
+	}
+
+	start, ok := readOptionalUnixQuery(ctx, "start")
+	if !ok {
+		return
+	}
+
+	end, ok := readOptionalUnixQuery(ctx, "end")
+	if !ok {
+		return
+	}
+
+	window, err := parseWindow(start, end)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "%s\n", err.Error())
+		return
+	}
+
+	state.TracerouteDataLock.Lock()
+	defer state.TracerouteDataLock.Unlock()
+
+	var routes []*traceroute.RouteData
+	if probeId != 0 {
+		routeData, ok := state.TracerouteData.GetRouteData(probeId, destination)
+		if !ok {
+			ctx.String(http.StatusBadRequest, "unable to find combination of probe and IP: %d %s\n", probeId, destination)
+			return
+		}
+
+		routes = append(routes, routeData)
+	} else {
+		for _, routeData := range state.TracerouteData.Routes() {
+			if routeData.GetDestination() == destination {
+				routes = append(routes, routeData)
+			}
+		}
+	}
+
+	for _, routeData := range routes {
+		routeData.AlignStatisticsEndTime(time.Now())
+	}
+
+	graph := traceroute.BuildAsGraph(routes, window, state.GetIpToAsn)
+	if graph.TotalUsage == 0 {
+		ctx.String(http.StatusServiceUnavailable, "no error-free data to provide for %s\n", destination)
+		return
+	}
+
+	type NodeData struct {
+		Asn                    uint32  `json:"asn"`
+		Usage                  int64   `json:"usage"`
+		Coverage               float64 `json:"coverage"`
+		AverageRttContribution float64 `json:"averageRttContribution"`
+	}
+
+	nodes := make([]NodeData, 0, len(graph.Nodes))
+	for _, node := range graph.Nodes {
+		nodes = append(nodes, NodeData{
+			Asn:                    node.Asn,
+			Usage:                  node.Usage,
+			Coverage:               float64(node.Usage) / float64(graph.TotalUsage),
+			AverageRttContribution: node.GetAverageRttContribution(),
+		})
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Asn < nodes[j].Asn
+	})
+
+	type EdgeData struct {
+		Start    uint32  `json:"start"`
+		End      uint32  `json:"end"`
+		Usage    int64   `json:"usage"`
+		Coverage float64 `json:"coverage"`
+		GapUsage int64   `json:"gapUsage"`
+	}
+
+	edges := make([]EdgeData, 0, len(graph.Edges))
+	for _, edge := range graph.Edges {
+		edges = append(edges, EdgeData{
+			Start:    edge.Start,
+			End:      edge.End,
+			Usage:    edge.Usage,
+			Coverage: float64(edge.Usage) / float64(graph.TotalUsage),
+			GapUsage: edge.GapUsage,
+		})
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Start != edges[j].Start {
+			return edges[i].Start < edges[j].Start
+		}
+
+		return edges[i].End < edges[j].End
+	})
+
+	type PathData struct {
+		Asns      []uint32 `json:"asns"`
+		Usage     int64    `json:"usage"`
+		Frequency float64  `json:"frequency"`
+	}
+
+	paths := make([]PathData, 0, len(graph.Paths))
+	for _, path := range graph.SortedPaths() {
+		paths = append(paths, PathData{
+			Asns:      path.Asns,
+			Usage:     path.Usage,
+			Frequency: float64(path.Usage) / float64(graph.TotalUsage),
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"nodes": nodes,
+		"edges": edges,
+		"paths": paths,
+	})
+}
@@ -0,0 +1,95 @@
+package rest_api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/catchment"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/http"
+	"net/netip"
+)
+
+// GetCatchments finds the anycast site each probe targeting a destination is routed to
+func (state DataRoute) GetCatchments(ctx *gin.Context) {
+	destination, err := netip.ParseAddr(ctx.Query("destination"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Could not read destination: %s\n", err.Error())
+		return
+	}
+
+	start, ok := readOptionalUnixQuery(ctx, "start")
+	if !ok {
+		return
+	}
+
+	end, ok := readOptionalUnixQuery(ctx, "end")
+	if !ok {
+		return
+	}
+
+	window, err := parseWindow(start, end)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "%s\n", err.Error())
+		return
+	}
+
+	penultimateHops := make(map[int][]traceroute.PenultimateHop)
+
+	state.TracerouteDataLock.Lock()
+	for _, routeData := range state.TracerouteData.Routes() {
+		if routeData.GetDestination() != destination {
+			continue
+		}
+
+		if hops := routeData.GetPenultimateHopsWithin(window); len(hops) > 0 {
+			penultimateHops[routeData.GetProbeId()] = hops
+		}
+	}
+	state.TracerouteDataLock.Unlock()
+
+	// Sites are found after releasing the lock since looking up hostnames may need to wait on other locks
+	catchments := catchment.Analyze(penultimateHops, state.GetSite)
+
+	type SiteData struct {
+		Site       string `json:"site"`
+		ProbeCount int    `json:"probeCount"`
+		Probes     []int  `json:"probes"`
+	}
+
+	type ChangeData struct {
+		Timestamp int64  `json:"timestamp"`
+		ProbeId   int    `json:"probeId"`
+		From      string `json:"from"`
+		To        string `json:"to"`
+	}
+
+	sites := make([]SiteData, 0, len(catchments.Sites))
+	for _, site := range catchments.Sites {
+		sites = append(sites, SiteData{
+			Site:       site.Name,
+			ProbeCount: len(site.Probes),
+			Probes:     site.Probes,
+		})
+	}
+
+	changes := make([]ChangeData, 0, len(catchments.Changes))
+	for _, change := range catchments.Changes {
+		changes = append(changes, ChangeData{
+			Timestamp: change.Timestamp.Unix(),
+			ProbeId:   change.ProbeId,
+			From:      change.From,
+			To:        change.To,
+		})
+	}
+
+	unmapped := catchments.Unmapped
+	if unmapped == nil {
+		unmapped = make([]int, 0)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"destination": destination.String(),
+		"sites":       sites,
+		"unmapped":    unmapped,
+		"changes":     changes,
+	})
+}
@@ -0,0 +1,139 @@
+package rest_api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// GetAddressFamilyComparison compares the IPv4 and IPv6 routes of a probe to a dual-stack destination
+func (state DataRoute) GetAddressFamilyComparison(ctx *gin.Context) {
+	probeId, err := strconv.Atoi(ctx.Query("probeId"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Could not read probe ID: %s\n", err.Error())
+		return
+	}
+
+	ipv4, err := netip.ParseAddr(ctx.Query("ipv4"))
+	if err != nil || !ipv4.Is4() {
+		ctx.String(http.StatusBadRequest, "Could not read IPv4 destination %q\n", ctx.Query("ipv4"))
+		return
+	}
+
+	ipv6, err := netip.ParseAddr(ctx.Query("ipv6"))
+	if err != nil || !ipv6.Is6() || ipv6.Is4In6() {
+		ctx.String(http.StatusBadRequest, "Could not read IPv6 destination %q\n", ctx.Query("ipv6"))
+		return
+	}
+
+	start, ok := readOptionalUnixQuery(ctx, "start")
+	if !ok {
+		return
+	}
+
+	end, ok := readOptionalUnixQuery(ctx, "end")
+	if !ok {
+		return
+	}
+
+	window, err := parseWindow(start, end)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "%s\n", err.Error())
+		return
+	}
+
+	state.TracerouteDataLock.Lock()
+	defer state.TracerouteDataLock.Unlock()
+
+	routeV4, okV4 := state.TracerouteData.GetRouteData(probeId, ipv4)
+	routeV6, okV6 := state.TracerouteData.GetRouteData(probeId, ipv6)
+	if !okV4 || !okV6 {
+		ctx.String(http.StatusBadRequest, "probe %d does not have routes to both %s and %s\n", probeId, ipv4, ipv6)
+		return
+	}
+
+	routeV4.AlignStatisticsEndTime(time.Now())
+	routeV6.AlignStatisticsEndTime(time.Now())
+
+	graphV4 := traceroute.BuildAsGraph([]*traceroute.RouteData{routeV4}, window, state.GetIpToAsn)
+	graphV6 := traceroute.BuildAsGraph([]*traceroute.RouteData{routeV6}, window, state.GetIpToAsn)
+
+	type AsData struct {
+		Asn      uint32   `json:"asn"`
+		Ipv4Rtt  *float64 `json:"ipv4Rtt,omitempty"`
+		Ipv6Rtt  *float64 `json:"ipv6Rtt,omitempty"`
+		RttDelta *float64 `json:"rttDelta,omitempty"`
+	}
+
+	shared, ipv4Only, ipv6Only := make([]uint32, 0), make([]uint32, 0), make([]uint32, 0)
+	ases := make([]AsData, 0)
+
+	for _, comparison := range traceroute.CompareAsGraphs(graphV4, graphV6) {
+		firstRtt, secondRtt := comparison.FirstRtt, comparison.SecondRtt
+
+		data := AsData{Asn: comparison.Asn}
+		if comparison.InFirst {
+			data.Ipv4Rtt = &firstRtt
+		}
+
+		if comparison.InSecond {
+			data.Ipv6Rtt = &secondRtt
+		}
+
+		switch {
+		case comparison.InFirst && comparison.InSecond:
+			delta := secondRtt - firstRtt
+			data.RttDelta = &delta
+			shared = append(shared, comparison.Asn)
+		case comparison.InFirst:
+			ipv4Only = append(ipv4Only, comparison.Asn)
+		default:
+			ipv6Only = append(ipv6Only, comparison.Asn)
+		}
+
+		ases = append(ases, data)
+	}
+
+	response := gin.H{
+		"sharedAsns":   shared,
+		"ipv4OnlyAsns": ipv4Only,
+		"ipv6OnlyAsns": ipv6Only,
+		"ases":         ases,
+		"ipv4AsPaths":  asPathStrings(graphV4),
+		"ipv6AsPaths":  asPathStrings(graphV6),
+	}
+
+	rttV4, reachedV4 := routeV4.GetDestinationRttWithin(window)
+	rttV6, reachedV6 := routeV6.GetDestinationRttWithin(window)
+	if reachedV4 {
+		response["ipv4Rtt"] = rttV4
+	}
+
+	if reachedV6 {
+		response["ipv6Rtt"] = rttV6
+	}
+
+	if reachedV4 && reachedV6 {
+		response["rttDelta"] = rttV6 - rttV4
+		if rttV4 <= rttV6 {
+			response["faster"] = "ipv4"
+		} else {
+			response["faster"] = "ipv6"
+		}
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// asPathStrings lists the AS paths of a graph from the most to the least used
+func asPathStrings(graph *traceroute.AsGraph) [][]uint32 {
+	paths := make([][]uint32, 0, len(graph.Paths))
+	for _, path := range graph.SortedPaths() {
+		paths = append(paths, path.Asns)
+	}
+
+	return paths
+}
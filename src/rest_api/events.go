@@ -0,0 +1,307 @@
+package rest_api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const defaultEventLimit = 100
+
+// eventFilter holds the query parameters shared by endpoints which list events
+type eventFilter struct {
+	// ProbeId and DestinationIp are ignored when left as their zero value
+	ProbeId       int
+	DestinationIp netip.Addr
+	Window        traceroute.TimeRange
+	Limit         int
+}
+
+func readEventFilter(ctx *gin.Context) (filter eventFilter, ok bool) {
+	var err error
+	filter.Limit = defaultEventLimit
+
+	if value, present := ctx.GetQuery("probeId"); present {
+		if filter.ProbeId, err = strconv.Atoi(value); err != nil {
+			ctx.String(http.StatusBadRequest, "Could not read probe ID: %s\n", err.Error())
+			return
+		}
+	}
+
+	if value, present := ctx.GetQuery("destinationIp"); present {
+		if filter.DestinationIp, err = netip.ParseAddr(value); err != nil {
+			ctx.String(http.StatusBadRequest, "Could not read destination IP: %s\n", err.Error())
+			return
+		}
+	}
+
+	if value, present := ctx.GetQuery("limit"); present {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			ctx.String(http.StatusBadRequest, "Limit must be a positive integer\n")
+			return
+		}
+	}
+
+	var start, end *int64
+	if start, ok = readOptionalUnixQuery(ctx, "start"); !ok {
+		return
+	}
+
+	if end, ok = readOptionalUnixQuery(ctx, "end"); !ok {
+		return
+	}
+
+	if filter.Window, err = parseWindow(start, end); err != nil {
+		ctx.String(http.StatusBadRequest, "%s\n", err.Error())
+		ok = false
+	}
+
+	return
+}
+
+func readOptionalUnixQuery(ctx *gin.Context, key string) (*int64, bool) {
+	value, present := ctx.GetQuery(key)
+	if !present {
+		return nil, true
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Could not read %s as a unix timestamp: %s\n", key, err.Error())
+		return nil, false
+	}
+
+	return &parsed, true
+}
+
+func (filter eventFilter) matches(probeId int, destination netip.Addr, timestamp time.Time) bool {
+	if filter.ProbeId != 0 && filter.ProbeId != probeId {
+		return false
+	}
+
+	if filter.DestinationIp.IsValid() && filter.DestinationIp != destination {
+		return false
+	}
+
+	return !timestamp.Before(filter.Window.Start) && !timestamp.After(filter.Window.End)
+}
+
+// findChangedAsns finds the ASNs which are only present in one of the two paths
+func (state DataRoute) findChangedAsns(oldPath, newPath traceroute.Path) []uint32 {
+	pathAsns := func(path traceroute.Path) map[uint32]struct{} {
+		asns := make(map[uint32]struct{})
+		for _, hop := range path {
+			if !hop.IsValid() {
+				continue
+			}
+
+			if asn, ok := state.GetIpToAsn(hop); ok {
+				asns[asn] = struct{}{}
+			}
+		}
+
+		return asns
+	}
+
+	oldAsns, newAsns := pathAsns(oldPath), pathAsns(newPath)
+
+	var changed []uint32
+	for asn := range oldAsns {
+		if _, ok := newAsns[asn]; !ok {
+			changed = append(changed, asn)
+		}
+	}
+
+	for asn := range newAsns {
+		if _, ok := oldAsns[asn]; !ok {
+			changed = append(changed, asn)
+		}
+	}
+
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i] < changed[j]
+	})
+
+	return changed
+}
+
+type pathChangeData struct {
+	Timestamp     int64    `json:"timestamp"`
+	ProbeId       int      `json:"probeId"`
+	DestinationIp string   `json:"destinationIp"`
+	OldPath       []string `json:"oldPath"`
+	NewPath       []string `json:"newPath"`
+	ChangedAsns   []uint32 `json:"changedAsns"`
+}
+
+func (state DataRoute) makePathChangeData(change traceroute.PathChange) pathChangeData {
+	return pathChangeData{
+		Timestamp:     change.Timestamp.Unix(),
+		ProbeId:       change.ProbeId,
+		DestinationIp: change.Destination.String(),
+		OldPath:       change.OldPath.Strings(),
+		NewPath:       change.NewPath.Strings(),
+		ChangedAsns:   state.findChangedAsns(change.OldPath, change.NewPath),
+	}
+}
+
+type loopEventData struct {
+	Timestamp     int64    `json:"timestamp"`
+	ProbeId       int      `json:"probeId"`
+	DestinationIp string   `json:"destinationIp"`
+	Addresses     []string `json:"addresses"`
+	Persistent    bool     `json:"persistent"`
+}
+
+func makeLoopEventData(event traceroute.LoopEvent) loopEventData {
+	return loopEventData{
+		Timestamp:     event.Timestamp.Unix(),
+		ProbeId:       event.ProbeId,
+		DestinationIp: event.Destination.String(),
+		Addresses:     event.Addresses.Strings(),
+		Persistent:    event.Persistent,
+	}
+}
+
+type anomalyData struct {
+	Timestamp     int64                      `json:"timestamp"`
+	ProbeId       int                        `json:"probeId"`
+	DestinationIp string                     `json:"destinationIp"`
+	Hop           string                     `json:"hop"`
+	IsDestination bool                       `json:"isDestination"`
+	Asn           uint32                     `json:"asn,omitempty"`
+	Rtt           float64                    `json:"rtt"`
+	BaselineRtt   float64                    `json:"baselineRtt"`
+	Score         float64                    `json:"score"`
+	Severity      traceroute.AnomalySeverity `json:"severity"`
+}
+
+func (state DataRoute) makeAnomalyData(anomaly traceroute.Anomaly) anomalyData {
+	asn, _ := state.GetIpToAsn(anomaly.Hop)
+	return anomalyData{
+		Timestamp:     anomaly.Timestamp.Unix(),
+		ProbeId:       anomaly.ProbeId,
+		DestinationIp: anomaly.Destination.String(),
+		Hop:           anomaly.Hop.String(),
+		IsDestination: anomaly.IsDestination(),
+		Asn:           asn,
+		Rtt:           anomaly.Rtt,
+		BaselineRtt:   anomaly.BaselineRtt,
+		Score:         anomaly.Score,
+		Severity:      anomaly.Severity,
+	}
+}
+
+func (state DataRoute) GetPathChanges(ctx *gin.Context) {
+	filter, ok := readEventFilter(ctx)
+	if !ok {
+		return
+	}
+
+	var filterAsn *uint32
+	if value, present := ctx.GetQuery("asn"); present {
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "Could not read ASN: %s\n", err.Error())
+			return
+		}
+
+		asn := uint32(parsed)
+		filterAsn = &asn
+	}
+
+	state.TracerouteDataLock.Lock()
+	pathChanges := state.TracerouteData.Events.PathChanges.Events()
+	state.TracerouteDataLock.Unlock()
+
+	changes := make([]pathChangeData, 0)
+
+	// Iterate from newest to oldest so the limit keeps the most recent changes
+	for index := len(pathChanges) - 1; index >= 0 && len(changes) < filter.Limit; index-- {
+		change := pathChanges[index]
+		if !filter.matches(change.ProbeId, change.Destination, change.Timestamp) {
+			continue
+		}
+
+		data := state.makePathChangeData(change)
+		if filterAsn != nil && !containsAsn(data.ChangedAsns, *filterAsn) {
+			continue
+		}
+
+		changes = append(changes, data)
+	}
+
+	ctx.JSON(http.StatusOK, changes)
+}
+
+func (state DataRoute) GetLoopEvents(ctx *gin.Context) {
+	filter, ok := readEventFilter(ctx)
+	if !ok {
+		return
+	}
+
+	state.TracerouteDataLock.Lock()
+	loopEvents := state.TracerouteData.Events.Loops.Events()
+	state.TracerouteDataLock.Unlock()
+
+	events := make([]loopEventData, 0)
+
+	// Iterate from newest to oldest so the limit keeps the most recent events
+	for index := len(loopEvents) - 1; index >= 0 && len(events) < filter.Limit; index-- {
+		event := loopEvents[index]
+		if !filter.matches(event.ProbeId, event.Destination, event.Timestamp) {
+			continue
+		}
+
+		events = append(events, makeLoopEventData(event))
+	}
+
+	ctx.JSON(http.StatusOK, events)
+}
+
+func (state DataRoute) GetAnomalies(ctx *gin.Context) {
+	filter, ok := readEventFilter(ctx)
+	if !ok {
+		return
+	}
+
+	minimumSeverity := traceroute.AnomalyWarning
+	if value, present := ctx.GetQuery("severity"); present {
+		if minimumSeverity = traceroute.AnomalySeverity(value); !minimumSeverity.IsValid() {
+			ctx.String(http.StatusBadRequest, "Severity must be %q or %q\n", traceroute.AnomalyWarning, traceroute.AnomalyCritical)
+			return
+		}
+	}
+
+	state.TracerouteDataLock.Lock()
+	anomalies := state.TracerouteData.Events.Anomalies.Events()
+	state.TracerouteDataLock.Unlock()
+
+	events := make([]anomalyData, 0)
+
+	// Iterate from newest to oldest so the limit keeps the most recent events
+	for index := len(anomalies) - 1; index >= 0 && len(events) < filter.Limit; index-- {
+		anomaly := anomalies[index]
+		if !filter.matches(anomaly.ProbeId, anomaly.Destination, anomaly.Timestamp) || !anomaly.Severity.IsAtLeast(minimumSeverity) {
+			continue
+		}
+
+		events = append(events, state.makeAnomalyData(anomaly))
+	}
+
+	ctx.JSON(http.StatusOK, events)
+}
+
+func containsAsn(asns []uint32, target uint32) bool {
+	for _, asn := range asns {
+		if asn == target {
+			return true
+		}
+	}
+
+	return false
+}
@@ -74,6 +74,10 @@ func (state DataRoute) ListTrackedMeasurement(ctx *gin.Context) {
 		MeasurementPeriodStop  int64  `json:"measurementPeriodStop"`
 		IsLoadingHistory       bool   `json:"isLoadingHistory"`
 		UsesLiveCollection     bool   `json:"usesLiveCollection"`
+		LastLiveResult         int64  `json:"lastLiveResult"`
+		LiveReconnects         int    `json:"liveReconnects"`
+		DroppedDuplicates      uint64 `json:"droppedDuplicates"`
+		RejectedResults        uint64 `json:"rejectedResults"`
 	}
 
 	var measurements []Response
@@ -89,6 +93,10 @@ func (state DataRoute) ListTrackedMeasurement(ctx *gin.Context) {
 			MeasurementPeriodStop:  data.LatestData.Unix(),
 			IsLoadingHistory:       data.CollectingHistory,
 			UsesLiveCollection:     data.PerformingLiveCollection,
+			LastLiveResult:         data.LastLiveResult.Unix(),
+			LiveReconnects:         data.LiveReconnects,
+			DroppedDuplicates:      data.DroppedDuplicates,
+			RejectedResults:        data.RejectedResults,
 		}
 
 		data.Lock.Unlock()
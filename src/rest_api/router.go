@@ -0,0 +1,191 @@
+package rest_api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// readTracerouteQuery reads the probe and destination of a route along with an optional time window from the query
+// parameters of a request
+func readTracerouteQuery(ctx *gin.Context) (request tracerouteRequest, ok bool) {
+	var err error
+	if request.ProbeId, err = strconv.Atoi(ctx.Query("probeId")); err != nil {
+		ctx.String(http.StatusBadRequest, "Could not read probe ID: %s\n", err.Error())
+		return
+	}
+
+	if request.DestinationIp, err = netip.ParseAddr(ctx.Query("destinationIp")); err != nil {
+		ctx.String(http.StatusBadRequest, "Could not read destination IP: %s\n", err.Error())
+		return
+	}
+
+	var start, end *int64
+	if start, ok = readOptionalUnixQuery(ctx, "start"); !ok {
+		return
+	}
+
+	if end, ok = readOptionalUnixQuery(ctx, "end"); !ok {
+		return
+	}
+
+	if request.Window, err = parseWindow(start, end); err != nil {
+		ctx.String(http.StatusBadRequest, "%s\n", err.Error())
+		ok = false
+	}
+
+	return
+}
+
+// GetTracerouteRouters gets the clean graph of a route with the interface addresses of each router collapsed into a
+// single node
+func (state DataRoute) GetTracerouteRouters(ctx *gin.Context) {
+	request, ok := readTracerouteQuery(ctx)
+	if !ok {
+		return
+	}
+
+	state.TracerouteDataLock.Lock()
+	defer state.TracerouteDataLock.Unlock()
+	routeData, ok := state.TracerouteData.GetRouteData(request.ProbeId, request.DestinationIp)
+	if !ok {
+		ctx.String(http.StatusBadRequest, "unable to find combination of probe and IP: %+v\n", request)
+		return
+	}
+
+	if routeData.IsEmpty() {
+		ctx.String(http.StatusServiceUnavailable, "no error-free data to provide: %+v\n", request)
+		return
+	}
+
+	// Align statistics so the edge statistics make sense
+	routeData.AlignStatisticsEndTime(time.Now())
+	window := request.Window
+
+	type RouterData struct {
+		Id         string   `json:"id"`
+		Interfaces []string `json:"interfaces"`
+		Asn        uint32   `json:"asn,omitempty"`
+		AverageRtt float64  `json:"averageRtt"`
+		LastUsed   int64    `json:"lastUsed"`
+
+		// Used to weight the average RTT of each interface
+		usages int64
+	}
+
+	routers := make(map[netip.Addr]*RouterData)
+
+	for id, storedNode := range routeData.Nodes {
+		usages := storedNode.GetNumUsagesWithin(window)
+		if id.IsTimeout() || usages == 0 {
+			continue
+		}
+
+		routerId := state.GetRouter(id.Ip)
+		router, ok := routers[routerId]
+		if !ok {
+			router = &RouterData{Id: routerId.String()}
+			routers[routerId] = router
+		}
+
+		if asn, ok := state.GetIpToAsn(id.Ip); ok && router.Asn == 0 {
+			router.Asn = asn
+		}
+
+		router.Interfaces = append(router.Interfaces, id.Ip.String())
+		router.AverageRtt += util.FiniteOrZero(storedNode.GetAverageRttWithin(window)) * float64(usages)
+		router.usages += usages
+
+		if lastUsed := storedNode.GetLastUsed().Unix(); lastUsed > router.LastUsed {
+			router.LastUsed = lastUsed
+		}
+	}
+
+	nodes := make([]*RouterData, 0, len(routers))
+	for _, router := range routers {
+		router.AverageRtt = util.FiniteOrZero(router.AverageRtt / float64(router.usages))
+		sort.Strings(router.Interfaces)
+		nodes = append(nodes, router)
+	}
+
+	type routerPair struct {
+		start, end netip.Addr
+	}
+
+	type EdgeData struct {
+		Start                string  `json:"start"`
+		End                  string  `json:"end"`
+		OutboundCoverage     float64 `json:"outboundCoverage"`
+		TotalTrafficCoverage float64 `json:"totalTrafficCoverage"`
+		LastUsed             int64   `json:"lastUsed"`
+
+		usage int64
+	}
+
+	edgesByRouter := make(map[routerPair]*EdgeData)
+	outboundUsages := make(map[netip.Addr]int64)
+
+	for endpoints, edge := range routeData.CleanEdges {
+		usage := edge.GetUsageWithin(window)
+		if usage == 0 {
+			continue
+		}
+
+		pair := routerPair{start: state.GetRouter(endpoints.Start.Ip), end: state.GetRouter(endpoints.Stop.Ip)}
+		// Links between interfaces of the same router are internal to the router
+		if pair.start == pair.end {
+			continue
+		}
+
+		merged, ok := edgesByRouter[pair]
+		if !ok {
+			merged = &EdgeData{Start: pair.start.String(), End: pair.end.String()}
+			edgesByRouter[pair] = merged
+		}
+
+		merged.usage += usage
+		merged.TotalTrafficCoverage += edge.GetNetUsageWithin(window)
+		outboundUsages[pair.start] += usage
+
+		if lastUsed := edge.GetLastUsed().Unix(); lastUsed > merged.LastUsed {
+			merged.LastUsed = lastUsed
+		}
+	}
+
+	// Count how many outbound edges each router has
+	parentCounts := make(map[netip.Addr]uint)
+	for pair := range edgesByRouter {
+		parentCounts[pair.start] += 1
+	}
+
+	totalUsages := float64(routeData.GetTotalUsagesWithin(window))
+	minEdgeWeight := config.MinCleanEdgeWeight.GetFloat()
+
+	edges := make([]*EdgeData, 0, len(edgesByRouter))
+	for pair, edge := range edgesByRouter {
+		edge.OutboundCoverage = float64(edge.usage) / float64(outboundUsages[pair.start])
+		edge.TotalTrafficCoverage /= totalUsages
+
+		if edge.OutboundCoverage < minEdgeWeight/float64(parentCounts[pair.start]) {
+			continue
+		}
+
+		edges = append(edges, edge)
+	}
+
+	var probeIps []string
+	for _, ip := range routeData.GetProbeIps() {
+		probeIps = append(probeIps, state.GetRouter(ip).String())
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"probeIps": probeIps,
+		"routers":  nodes,
+		"edges":    edges,
+	})
+}
@@ -30,8 +30,26 @@ func setupRoutes(router *gin.Engine, state *service.ApplicationState) {
 	traceroute.POST("/raw", DataRoute{state}.GetTracerouteRaw)
 	traceroute.POST("/clean", DataRoute{state}.GetTracerouteClean)
 	traceroute.POST("/full", DataRoute{state}.GetTracerouteFull)
+	traceroute.POST("/aggregate", DataRoute{state}.GetTracerouteAggregate)
+	traceroute.GET("/changes", DataRoute{state}.GetPathChanges)
+	traceroute.GET("/loops", DataRoute{state}.GetLoopEvents)
+	traceroute.GET("/router", DataRoute{state}.GetTracerouteRouters)
+	traceroute.GET("/as", DataRoute{state}.GetTracerouteAsGraph)
+	traceroute.GET("/compare", DataRoute{state}.GetAddressFamilyComparison)
+	traceroute.GET("/series", DataRoute{state}.GetTracerouteSeries)
+	traceroute.GET("/stream", DataRoute{state}.StreamTraceroute)
 
 	api.POST("/probes", DataRoute{state}.GetProbes)
+	api.GET("/catchments", DataRoute{state}.GetCatchments)
+	api.GET("/anomalies", DataRoute{state}.GetAnomalies)
+
+	alerts := api.Group("/alerts")
+	alerts.GET("/active", DataRoute{state}.ListActiveAlerts)
+	alerts.GET("/rules", DataRoute{state}.ListAlertRules)
+	alerts.POST("/rules", DataRoute{state}.CreateAlertRule)
+	alerts.GET("/rules/:id", DataRoute{state}.GetAlertRule)
+	alerts.PUT("/rules/:id", DataRoute{state}.UpdateAlertRule)
+	alerts.DELETE("/rules/:id", DataRoute{state}.DeleteAlertRule)
 
 	router.NoRoute(func(ctx *gin.Context) {
 		ctx.AbortWithStatus(http.StatusNotFound)
@@ -0,0 +1,127 @@
+package rest_api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"math"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+type seriesPoint struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// Value is null for bins without any data to average
+	Value *float64 `json:"value"`
+}
+
+func makeSeriesData(series []util.SeriesPoint) []seriesPoint {
+	points := make([]seriesPoint, 0, len(series))
+	for _, point := range series {
+		data := seriesPoint{
+			Start: point.Start.Unix(),
+			End:   point.End.Unix(),
+		}
+
+		if !math.IsNaN(point.Value) && !math.IsInf(point.Value, 0) {
+			value := point.Value
+			data.Value = &value
+		}
+
+		points = append(points, data)
+	}
+
+	return points
+}
+
+// readAddressQuery reads an address from the query parameters. The second return value is false if it was not given.
+func readAddressQuery(ctx *gin.Context, key string) (addr netip.Addr, present bool, ok bool) {
+	value, present := ctx.GetQuery(key)
+	if !present {
+		return netip.Addr{}, false, true
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Could not read %s: %s\n", key, err.Error())
+		return netip.Addr{}, true, false
+	}
+
+	return addr, true, true
+}
+
+// GetTracerouteSeries gets the per-bin history of the statistics of a node or a clean edge of a route
+func (state DataRoute) GetTracerouteSeries(ctx *gin.Context) {
+	request, ok := readTracerouteQuery(ctx)
+	if !ok {
+		return
+	}
+
+	node, hasNode, ok := readAddressQuery(ctx, "node")
+	if !ok {
+		return
+	}
+
+	edgeStart, hasEdgeStart, ok := readAddressQuery(ctx, "edgeStart")
+	if !ok {
+		return
+	}
+
+	edgeEnd, hasEdgeEnd, ok := readAddressQuery(ctx, "edgeEnd")
+	if !ok {
+		return
+	}
+
+	if hasNode == (hasEdgeStart || hasEdgeEnd) || hasEdgeStart != hasEdgeEnd {
+		ctx.String(http.StatusBadRequest, "Expected either node or both edgeStart and edgeEnd\n")
+		return
+	}
+
+	state.TracerouteDataLock.Lock()
+	defer state.TracerouteDataLock.Unlock()
+	routeData, ok := state.TracerouteData.GetRouteData(request.ProbeId, request.DestinationIp)
+	if !ok {
+		ctx.String(http.StatusBadRequest, "unable to find combination of probe and IP: %+v\n", request)
+		return
+	}
+
+	// Align statistics so the bins of each statistic line up
+	routeData.AlignStatisticsEndTime(time.Now())
+	window := request.Window
+
+	if hasNode {
+		storedNode, ok := routeData.Nodes[traceroute.WrapAddr(node)]
+		if !ok {
+			ctx.String(http.StatusNotFound, "unable to find node %s on route: %+v\n", node, request)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"usage":      makeSeriesData(storedNode.GetUsageSeriesWithin(window)),
+			"averageRtt": makeSeriesData(storedNode.GetAverageRttSeriesWithin(window)),
+		})
+		return
+	}
+
+	endpoints := traceroute.DirectedGraphEdge{
+		Start: traceroute.WrapAddr(edgeStart),
+		Stop:  traceroute.WrapAddr(edgeEnd),
+	}
+
+	edge, ok := routeData.CleanEdges[endpoints]
+	startNode, hasStartNode := routeData.Nodes[endpoints.Start]
+	if !ok || !hasStartNode {
+		ctx.String(http.StatusNotFound, "unable to find edge from %s to %s on route: %+v\n", edgeStart, edgeEnd, request)
+		return
+	}
+
+	usage := edge.GetUsageSeriesWithin(window)
+	outboundUsage := startNode.GetCleanOutboundUsageSeriesWithin(window)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"usage":            makeSeriesData(usage),
+		"outboundCoverage": makeSeriesData(traceroute.CoverageSeries(usage, outboundUsage)),
+	})
+}
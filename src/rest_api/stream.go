@@ -0,0 +1,71 @@
+package rest_api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/stream"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"io"
+	"net/http"
+	"time"
+)
+
+// StreamTraceroute sends live updates for a route as server-sent events until the client disconnects. Routes do not
+// need to have any data yet, so clients can subscribe before a measurement starts being tracked.
+func (state DataRoute) StreamTraceroute(ctx *gin.Context) {
+	request, ok := readTracerouteQuery(ctx)
+	if !ok {
+		return
+	}
+
+	if state.Streams == nil {
+		ctx.String(http.StatusServiceUnavailable, "live updates are not available\n")
+		return
+	}
+
+	subscription := state.Streams.Subscribe(request.ProbeId, request.DestinationIp)
+	defer state.Streams.Unsubscribe(subscription)
+
+	keepalive := time.NewTicker(config.StreamKeepalivePeriod.GetDuration())
+	defer keepalive.Stop()
+
+	// Send the headers right away so the client knows it is subscribed before the first update arrives
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+
+	clientGone := ctx.Request.Context().Done()
+	ctx.Stream(func(writer io.Writer) bool {
+		select {
+		case message, ok := <-subscription.Messages():
+			// The channel is closed if the client could not keep up, in which case it needs to reconnect
+			if !ok {
+				return false
+			}
+
+			ctx.SSEvent(message.Event, state.makeStreamData(message))
+			return true
+		case <-keepalive.C:
+			_, err := io.WriteString(writer, ": keepalive\n\n")
+			return err == nil
+		case <-clientGone:
+			return false
+		}
+	})
+}
+
+// makeStreamData converts events into the same format used by the endpoints which list them
+func (state DataRoute) makeStreamData(message stream.Message) any {
+	switch data := message.Data.(type) {
+	case traceroute.PathChange:
+		return state.makePathChangeData(data)
+	case traceroute.LoopEvent:
+		return makeLoopEventData(data)
+	case traceroute.Anomaly:
+		return state.makeAnomalyData(data)
+	default:
+		return data
+	}
+}
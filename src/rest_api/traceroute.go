@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/geo"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/rdns"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/ripe_atlas"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
 	"io"
 	"net/http"
 	"net/netip"
+	"sort"
 	"time"
 )
 
@@ -24,6 +29,8 @@ const MimeOctetStream = "application/octet-stream"
 type tracerouteRequest struct {
 	ProbeId       int
 	DestinationIp netip.Addr
+	// Window is the period statistics are computed over. It defaults to the full statistics period.
+	Window traceroute.TimeRange
 }
 
 func (state DataRoute) GetTracerouteRaw(ctx *gin.Context) {
@@ -103,19 +110,34 @@ func (state DataRoute) GetTracerouteClean(ctx *gin.Context) {
 
 	// Align statistics so the edge statistics make sense
 	routeData.AlignStatisticsEndTime(time.Now())
+	window := request.Window
 
 	type NodeData struct {
-		Id                  string  `json:"id"`
-		Asn                 uint32  `json:"asn,omitempty"`
-		AverageRtt          float64 `json:"averageRtt"`
-		LastUsed            int64   `json:"lastUsed"`
-		AveragePathLifespan float64 `json:"averagePathLifespan"`
+		Id                  string         `json:"id"`
+		Asn                 uint32         `json:"asn,omitempty"`
+		AverageRtt          float64        `json:"averageRtt"`
+		LastUsed            int64          `json:"lastUsed"`
+		AveragePathLifespan float64        `json:"averagePathLifespan"`
+		MaxPathLifespan     float64        `json:"maxPathLifespan"`
+		LoopCount           int64          `json:"loopCount"`
+		IsLoadBalancer      bool           `json:"isLoadBalancer"`
+		ForwardHops         float64        `json:"forwardHops,omitempty"`
+		ReturnHops          float64        `json:"returnHops,omitempty"`
+		IsAsymmetric        bool           `json:"isAsymmetric"`
+		ReplyTtls           map[int]int64  `json:"replyTtls,omitempty"`
+		Hostname            string         `json:"hostname,omitempty"`
+		Location            *rdns.Location `json:"location,omitempty"`
+		Geolocation         *geolocation   `json:"geolocation,omitempty"`
+		Rtt                 *rttData       `json:"rtt,omitempty"`
+		ResponseRate        *float64       `json:"responseRate,omitempty"`
 	}
 
 	var nodes []NodeData
+	loadBalancers := routeData.GetLoadBalancersWithin(window)
+	probeLocation, hasProbeLocation := state.GetProbeLocation(request.ProbeId, request.DestinationIp)
 
 	for id, storedNode := range routeData.Nodes {
-		if id.IsTimeout() {
+		if id.IsTimeout() || storedNode.GetNumUsagesWithin(window) == 0 {
 			continue
 		}
 
@@ -124,28 +146,57 @@ func (state DataRoute) GetTracerouteClean(ctx *gin.Context) {
 			asn = foundAsn
 		}
 
+		hostname, location := state.describeAddress(id.Ip)
+		averageRtt := util.FiniteOrZero(storedNode.GetAverageRttWithin(window))
+		rttDistribution := storedNode.GetRttDistributionWithin(window)
+
+		_, isLoadBalancer := loadBalancers[id]
+		lifespan, _ := storedNode.GetLifespanWithin(window)
+		forwardHops, _ := storedNode.GetForwardHopsWithin(window)
+		returnHops, _ := storedNode.GetReturnHopsWithin(window)
+
 		nodes = append(nodes, NodeData{
-			Id:         id.Ip.String(),
-			Asn:        asn,
-			AverageRtt: storedNode.GetAverageRtt(),
-			LastUsed:   storedNode.GetLastUsed().Unix(),
-			// TODO: Replace with occurrences in output?
-			AveragePathLifespan: 0,
+			Id:                  id.Ip.String(),
+			Asn:                 asn,
+			AverageRtt:          averageRtt,
+			LastUsed:            storedNode.GetLastUsed().Unix(),
+			AveragePathLifespan: lifespan.Average.Seconds(),
+			MaxPathLifespan:     lifespan.Max.Seconds(),
+			LoopCount:           storedNode.GetLoopUsagesWithin(window),
+			IsLoadBalancer:      isLoadBalancer,
+			ForwardHops:         forwardHops,
+			ReturnHops:          returnHops,
+			IsAsymmetric:        storedNode.IsAsymmetricWithin(window),
+			ReplyTtls:           storedNode.GetReplyTtlsWithin(window),
+			Hostname:            hostname,
+			Location:            location,
+			Geolocation:         state.geolocate(id.Ip, probeLocation, hasProbeLocation, rttDistribution),
+			Rtt:                 makeRttData(rttDistribution),
+			ResponseRate:        optionalRate(storedNode.GetResponseRateWithin(window)),
 		})
 	}
 
 	type EdgeData struct {
-		Start                string  `json:"start"`
-		End                  string  `json:"end"`
-		OutboundCoverage     float64 `json:"outboundCoverage"`
-		TotalTrafficCoverage float64 `json:"totalTrafficCoverage"`
-		LastUsed             int64   `json:"lastUsed"`
+		Start                string        `json:"start"`
+		End                  string        `json:"end"`
+		OutboundCoverage     float64       `json:"outboundCoverage"`
+		TotalTrafficCoverage float64       `json:"totalTrafficCoverage"`
+		LastUsed             int64         `json:"lastUsed"`
+		LoopCount            int64         `json:"loopCount"`
+		FlowIds              []int         `json:"flowIds"`
+		LossRate             *float64      `json:"lossRate,omitempty"`
+		RttDelta             *rttDeltaData `json:"rttDelta,omitempty"`
+		LatencyIncrease      bool          `json:"latencyIncrease"`
 	}
 	var edges []EdgeData
 
 	// Count how many outbound edges each node has
 	parentCounts := make(map[netip.Addr]uint)
-	for endpoints := range routeData.CleanEdges {
+	for endpoints, edge := range routeData.CleanEdges {
+		if edge.GetUsageWithin(window) == 0 {
+			continue
+		}
+
 		if _, ok := parentCounts[endpoints.Start.Ip]; !ok {
 			parentCounts[endpoints.Start.Ip] = 0
 		}
@@ -154,9 +205,16 @@ func (state DataRoute) GetTracerouteClean(ctx *gin.Context) {
 	}
 
 	minEdgeWeight := config.MinCleanEdgeWeight.GetFloat()
+	totalUsage := routeData.GetTotalUsagesWithin(window)
 
 	for endpoints, edge := range routeData.CleanEdges {
-		outboundCoverage := float64(edge.GetUsage()) / float64(routeData.Nodes[endpoints.Start].GetCleanOutboundUsages())
+		usage := edge.GetUsageWithin(window)
+		if usage == 0 {
+			continue
+		}
+
+		outboundUsage := routeData.Nodes[endpoints.Start].GetCleanOutboundUsagesWithin(window)
+		outboundCoverage := util.FiniteOrZero(float64(usage) / float64(outboundUsage))
 
 		minCoverage := minEdgeWeight / float64(parentCounts[endpoints.Start.Ip])
 		if outboundCoverage < minCoverage {
@@ -167,8 +225,13 @@ func (state DataRoute) GetTracerouteClean(ctx *gin.Context) {
 			Start:                endpoints.Start.Ip.String(),
 			End:                  endpoints.Stop.Ip.String(),
 			OutboundCoverage:     outboundCoverage,
-			TotalTrafficCoverage: edge.GetNetUsage() / float64(routeData.GetTotalUsages()),
+			TotalTrafficCoverage: util.FiniteOrZero(edge.GetNetUsageWithin(window) / float64(totalUsage)),
 			LastUsed:             edge.GetLastUsed().Unix(),
+			LoopCount:            edge.GetLoopUsagesWithin(window),
+			FlowIds:              edge.GetFlowIdsWithin(window),
+			LossRate:             optionalRate(edge.GetLossRateWithin(window)),
+			RttDelta:             makeRttDeltaData(edge.GetRttDeltaWithin(window)),
+			LatencyIncrease:      edge.IsLatencyIncreaseWithin(window),
 		})
 	}
 
@@ -178,9 +241,14 @@ func (state DataRoute) GetTracerouteClean(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"probeIps": probeIps,
-		"nodes":    nodes,
-		"edges":    edges,
+		"probeIps":       probeIps,
+		"nodes":          nodes,
+		"edges":          edges,
+		"loops":          makeLoopData(routeData, window),
+		"loopingResults": routeData.GetLoopingResultsWithin(window),
+		"reachability":   optionalRate(routeData.GetReachabilityWithin(window)),
+		"diamonds":       makeDiamondData(routeData, window),
+		"paths":          makePathData(routeData, window),
 	})
 }
 
@@ -205,6 +273,7 @@ func (state DataRoute) GetTracerouteFull(ctx *gin.Context) {
 
 	// Align statistics so the edge statistics make sense
 	routeData.AlignStatisticsEndTime(time.Now())
+	window := request.Window
 
 	type NodeId struct {
 		Ip             string `json:"ip"`
@@ -212,47 +281,105 @@ func (state DataRoute) GetTracerouteFull(ctx *gin.Context) {
 	}
 
 	type NodeData struct {
-		Id                  NodeId  `json:"id"`
-		Asn                 uint32  `json:"asn,omitempty"`
-		AverageRtt          float64 `json:"averageRtt"`
-		LastUsed            int64   `json:"lastUsed"`
-		AveragePathLifespan float64 `json:"averagePathLifespan"`
+		Id                  NodeId         `json:"id"`
+		Asn                 uint32         `json:"asn,omitempty"`
+		AverageRtt          float64        `json:"averageRtt"`
+		LastUsed            int64          `json:"lastUsed"`
+		AveragePathLifespan float64        `json:"averagePathLifespan"`
+		MaxPathLifespan     float64        `json:"maxPathLifespan"`
+		LoopCount           int64          `json:"loopCount"`
+		IsLoadBalancer      bool           `json:"isLoadBalancer"`
+		MplsLabels          []uint32       `json:"mplsLabels,omitempty"`
+		ForwardHops         float64        `json:"forwardHops,omitempty"`
+		ReturnHops          float64        `json:"returnHops,omitempty"`
+		IsAsymmetric        bool           `json:"isAsymmetric"`
+		ReplyTtls           map[int]int64  `json:"replyTtls,omitempty"`
+		Hostname            string         `json:"hostname,omitempty"`
+		Location            *rdns.Location `json:"location,omitempty"`
+		Geolocation         *geolocation   `json:"geolocation,omitempty"`
+		Rtt                 *rttData       `json:"rtt,omitempty"`
+		ResponseRate        *float64       `json:"responseRate,omitempty"`
 	}
 
 	var nodes []NodeData
+	loadBalancers := routeData.GetLoadBalancersWithin(window)
+	probeLocation, hasProbeLocation := state.GetProbeLocation(request.ProbeId, request.DestinationIp)
 
 	for id, storedNode := range routeData.Nodes {
+		if storedNode.GetNumUsagesWithin(window) == 0 {
+			continue
+		}
+
+		averageRtt := util.FiniteOrZero(storedNode.GetAverageRttWithin(window))
+		rttDistribution := storedNode.GetRttDistributionWithin(window)
+
 		asn := uint32(0)
+		var hostname string
+		var location *rdns.Location
+		var nodeGeolocation *geolocation
 		if !id.IsTimeout() {
 			if foundAsn, ok := state.GetIpToAsn(id.Ip); ok {
 				asn = foundAsn
 			}
+
+			hostname, location = state.describeAddress(id.Ip)
+			nodeGeolocation = state.geolocate(id.Ip, probeLocation, hasProbeLocation, rttDistribution)
 		}
 
+		_, isLoadBalancer := loadBalancers[id]
+		lifespan, _ := storedNode.GetLifespanWithin(window)
+		forwardHops, _ := storedNode.GetForwardHopsWithin(window)
+		returnHops, _ := storedNode.GetReturnHopsWithin(window)
+
 		nodes = append(nodes, NodeData{
 			Id: NodeId{
 				Ip:             id.Ip.String(),
 				TimeSinceKnown: id.TimeoutsSinceKnown,
 			},
-			Asn:        asn,
-			AverageRtt: storedNode.GetAverageRtt(),
-			LastUsed:   storedNode.GetLastUsed().Unix(),
-			// TODO: Replace with occurrences in output?
-			AveragePathLifespan: 0,
+			Asn:                 asn,
+			AverageRtt:          averageRtt,
+			LastUsed:            storedNode.GetLastUsed().Unix(),
+			AveragePathLifespan: lifespan.Average.Seconds(),
+			MaxPathLifespan:     lifespan.Max.Seconds(),
+			LoopCount:           storedNode.GetLoopUsagesWithin(window),
+			IsLoadBalancer:      isLoadBalancer,
+			ForwardHops:         forwardHops,
+			ReturnHops:          returnHops,
+			IsAsymmetric:        storedNode.IsAsymmetricWithin(window),
+			ReplyTtls:           storedNode.GetReplyTtlsWithin(window),
+			Hostname:            hostname,
+			Location:            location,
+			Geolocation:         nodeGeolocation,
+			Rtt:                 makeRttData(rttDistribution),
+			ResponseRate:        optionalRate(storedNode.GetResponseRateWithin(window)),
+			MplsLabels:          storedNode.GetMplsLabelsWithin(window),
 		})
 	}
 
 	type EdgeData struct {
-		Start                NodeId  `json:"start"`
-		End                  NodeId  `json:"end"`
-		OutboundCoverage     float64 `json:"outboundCoverage"`
-		TotalTrafficCoverage float64 `json:"totalTrafficCoverage"`
-		LastUsed             int64   `json:"lastUsed"`
+		Start                NodeId        `json:"start"`
+		End                  NodeId        `json:"end"`
+		OutboundCoverage     float64       `json:"outboundCoverage"`
+		TotalTrafficCoverage float64       `json:"totalTrafficCoverage"`
+		LastUsed             int64         `json:"lastUsed"`
+		LoopCount            int64         `json:"loopCount"`
+		FlowIds              []int         `json:"flowIds"`
+		LossRate             *float64      `json:"lossRate,omitempty"`
+		RttDelta             *rttDeltaData `json:"rttDelta,omitempty"`
+		LatencyIncrease      bool          `json:"latencyIncrease"`
 	}
 
 	var edges []EdgeData
+	totalUsage := routeData.GetTotalUsagesWithin(window)
 
 	for endpoints, edge := range routeData.Edges {
+		usage := edge.GetUsageWithin(window)
+		if usage == 0 {
+			continue
+		}
+
+		outboundUsage := routeData.Nodes[endpoints.Start].GetOutboundUsagesWithin(window)
+
 		edges = append(edges, EdgeData{
 			Start: NodeId{
 				Ip:             endpoints.Start.Ip.String(),
@@ -262,9 +389,14 @@ func (state DataRoute) GetTracerouteFull(ctx *gin.Context) {
 				Ip:             endpoints.Stop.Ip.String(),
 				TimeSinceKnown: endpoints.Stop.TimeoutsSinceKnown,
 			},
-			OutboundCoverage:     float64(edge.GetUsage()) / float64(routeData.Nodes[endpoints.Start].GetOutboundUsages()),
-			TotalTrafficCoverage: edge.GetNetUsage() / float64(routeData.GetTotalUsages()),
+			OutboundCoverage:     util.FiniteOrZero(float64(usage) / float64(outboundUsage)),
+			TotalTrafficCoverage: util.FiniteOrZero(edge.GetNetUsageWithin(window) / float64(totalUsage)),
 			LastUsed:             edge.GetLastUsed().Unix(),
+			LoopCount:            edge.GetLoopUsagesWithin(window),
+			FlowIds:              edge.GetFlowIdsWithin(window),
+			LossRate:             optionalRate(edge.GetLossRateWithin(window)),
+			RttDelta:             makeRttDeltaData(edge.GetRttDeltaWithin(window)),
+			LatencyIncrease:      edge.IsLatencyIncreaseWithin(window),
 		})
 	}
 
@@ -277,16 +409,264 @@ func (state DataRoute) GetTracerouteFull(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"probeIds": probeIds,
-		"nodes":    nodes,
-		"edges":    edges,
+		"probeIds":       probeIds,
+		"nodes":          nodes,
+		"edges":          edges,
+		"loops":          makeLoopData(routeData, window),
+		"loopingResults": routeData.GetLoopingResultsWithin(window),
+		"reachability":   optionalRate(routeData.GetReachabilityWithin(window)),
+		"diamonds":       makeDiamondData(routeData, window),
+		"paths":          makePathData(routeData, window),
+		"tunnels":        makeTunnelData(routeData, window),
 	})
 }
 
+// describeAddress gets the reverse DNS hostname of an address and the location inferred from it, if they are known
+func (state DataRoute) describeAddress(addr netip.Addr) (hostname string, location *rdns.Location) {
+	hostname, _ = state.GetHostname(addr)
+	if found, ok := state.GetLocation(addr); ok {
+		location = &found
+	}
+
+	return
+}
+
+type geolocation struct {
+	geo.Location
+	// Impossible is set when the location is further from the probe than a reply could have travelled within its RTT
+	Impossible bool `json:"impossible"`
+}
+
+// geolocate looks up the location of an address in the geolocation database. If the location of the probe is known, the
+// fastest reply from the hop is used to check if the location is physically possible.
+func (state DataRoute) geolocate(addr netip.Addr, probe geo.Location, hasProbe bool, rtt util.Distribution) *geolocation {
+	location, ok := state.GetGeolocation(addr)
+	if !ok {
+		return nil
+	}
+
+	return &geolocation{
+		Location:   location,
+		Impossible: hasProbe && rtt.Count > 0 && rtt.Min > 0 && !geo.IsPlausible(probe, location, rtt.Min),
+	}
+}
+
+// optionalRate converts a rate which may not be known into a value which is omitted from JSON when unknown
+func optionalRate(rate float64, ok bool) *float64 {
+	if !ok {
+		return nil
+	}
+
+	return &rate
+}
+
+type histogramBucketData struct {
+	UpperBound float64 `json:"upperBound"`
+	Count      int64   `json:"count"`
+}
+
+type rttData struct {
+	Min       float64               `json:"min"`
+	Max       float64               `json:"max"`
+	StdDev    float64               `json:"stdDev"`
+	P50       float64               `json:"p50"`
+	P90       float64               `json:"p90"`
+	P99       float64               `json:"p99"`
+	Histogram []histogramBucketData `json:"histogram"`
+}
+
+// makeRttData summarizes the RTT distribution of a node. Nodes without any replies, such as timeouts, have no summary.
+func makeRttData(distribution util.Distribution) *rttData {
+	if distribution.Count == 0 {
+		return nil
+	}
+
+	var histogram []histogramBucketData
+	for _, bucket := range distribution.Histogram() {
+		histogram = append(histogram, histogramBucketData{
+			UpperBound: bucket.UpperBound,
+			Count:      bucket.Count,
+		})
+	}
+
+	return &rttData{
+		Min:       distribution.Min,
+		Max:       distribution.Max,
+		StdDev:    distribution.StdDev(),
+		P50:       distribution.Quantile(0.5),
+		P90:       distribution.Quantile(0.9),
+		P99:       distribution.Quantile(0.99),
+		Histogram: histogram,
+	}
+}
+
+type rttDeltaData struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stdDev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	P10    float64 `json:"p10"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+}
+
+// makeRttDeltaData summarizes the RTT added across an edge. Edges where the RTT of either end was never known have no
+// summary.
+func makeRttDeltaData(distribution util.Distribution) *rttDeltaData {
+	if distribution.Count == 0 {
+		return nil
+	}
+
+	return &rttDeltaData{
+		Mean:   distribution.Mean(),
+		StdDev: distribution.StdDev(),
+		Min:    distribution.Min,
+		Max:    distribution.Max,
+		P10:    distribution.Quantile(0.1),
+		P50:    distribution.Quantile(0.5),
+		P90:    distribution.Quantile(0.9),
+	}
+}
+
+type loopData struct {
+	Addresses   []string `json:"addresses"`
+	Occurrences int64    `json:"occurrences"`
+	Persistent  bool     `json:"persistent"`
+	LastSeen    int64    `json:"lastSeen"`
+}
+
+// makeLoopData summarizes the routing loops seen on a route within the given window
+func makeLoopData(routeData *traceroute.RouteData, window traceroute.TimeRange) []loopData {
+	loops := make([]loopData, 0)
+
+	for _, loop := range routeData.Loops {
+		occurrences := loop.GetOccurrencesWithin(window)
+		if occurrences == 0 {
+			continue
+		}
+
+		loops = append(loops, loopData{
+			Addresses:   loop.Addresses.Strings(),
+			Occurrences: occurrences,
+			Persistent:  loop.IsPersistent(),
+			LastSeen:    loop.GetLastSeen().Unix(),
+		})
+	}
+
+	return loops
+}
+
+type diamondBranchData struct {
+	Nodes   []string `json:"nodes"`
+	FlowIds []int    `json:"flowIds"`
+}
+
+type diamondData struct {
+	Divergence  string              `json:"divergence"`
+	Convergence string              `json:"convergence,omitempty"`
+	Branches    []diamondBranchData `json:"branches"`
+}
+
+// makeDiamondData summarizes the load balanced sections of a route within the given window
+func makeDiamondData(routeData *traceroute.RouteData, window traceroute.TimeRange) []diamondData {
+	diamonds := make([]diamondData, 0)
+
+	for _, diamond := range routeData.GetDiamondsWithin(window) {
+		data := diamondData{
+			Divergence: diamond.Divergence.Ip.String(),
+		}
+
+		if diamond.Convergence.Ip.IsValid() {
+			data.Convergence = diamond.Convergence.Ip.String()
+		}
+
+		for _, branch := range diamond.Branches {
+			nodes := make([]string, 0, len(branch.Nodes))
+			for _, id := range branch.Nodes {
+				nodes = append(nodes, id.Ip.String())
+			}
+
+			data.Branches = append(data.Branches, diamondBranchData{
+				Nodes:   nodes,
+				FlowIds: branch.FlowIds,
+			})
+		}
+
+		diamonds = append(diamonds, data)
+	}
+
+	return diamonds
+}
+
+type tunnelData struct {
+	Kind        traceroute.TunnelKind `json:"kind"`
+	Hops        []string              `json:"hops"`
+	Occurrences int64                 `json:"occurrences"`
+	LastSeen    int64                 `json:"lastSeen"`
+}
+
+// makeTunnelData summarizes the MPLS tunnels inferred on a route within the given window
+func makeTunnelData(routeData *traceroute.RouteData, window traceroute.TimeRange) []tunnelData {
+	tunnels := make([]tunnelData, 0)
+
+	for _, tunnel := range routeData.Tunnels {
+		occurrences := tunnel.GetOccurrencesWithin(window)
+		if occurrences == 0 {
+			continue
+		}
+
+		lastSeen, _ := tunnel.GetLastSeenWithin(window)
+		tunnels = append(tunnels, tunnelData{
+			Kind:        tunnel.Kind,
+			Hops:        tunnel.Hops.Strings(),
+			Occurrences: occurrences,
+			LastSeen:    lastSeen.Unix(),
+		})
+	}
+
+	return tunnels
+}
+
+type pathData struct {
+	Hops                []string `json:"hops"`
+	Usage               int64    `json:"usage"`
+	LastUsed            int64    `json:"lastUsed"`
+	AveragePathLifespan float64  `json:"averagePathLifespan"`
+	MaxPathLifespan     float64  `json:"maxPathLifespan"`
+}
+
+// makePathData summarizes how long each full hop sequence of a route stayed in use within the given window
+func makePathData(routeData *traceroute.RouteData, window traceroute.TimeRange) []pathData {
+	paths := make([]pathData, 0)
+
+	for _, record := range routeData.Paths {
+		lifespan, ok := record.GetLifespanWithin(window)
+		if !ok {
+			continue
+		}
+
+		paths = append(paths, pathData{
+			Hops:                record.Hops.Strings(),
+			Usage:               record.GetUsageWithin(window),
+			LastUsed:            record.GetLastUsed().Unix(),
+			AveragePathLifespan: lifespan.Average.Seconds(),
+			MaxPathLifespan:     lifespan.Max.Seconds(),
+		})
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i].Usage > paths[j].Usage
+	})
+
+	return paths
+}
+
 func (request *tracerouteRequest) UnmarshalJSON(bytes []byte) (err error) {
 	var buffer struct {
 		ProbeId       int    `json:"probeId"`
 		DestinationIp string `json:"destinationIp"`
+		Start         *int64 `json:"start"`
+		End           *int64 `json:"end"`
 	}
 
 	if err = json.Unmarshal(bytes, &buffer); err != nil {
@@ -294,6 +674,10 @@ func (request *tracerouteRequest) UnmarshalJSON(bytes []byte) (err error) {
 	}
 
 	request.ProbeId = buffer.ProbeId
-	request.DestinationIp, err = netip.ParseAddr(buffer.DestinationIp)
+	if request.DestinationIp, err = netip.ParseAddr(buffer.DestinationIp); err != nil {
+		return
+	}
+
+	request.Window, err = parseWindow(buffer.Start, buffer.End)
 	return
 }
@@ -2,10 +2,13 @@ package rest_api
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
 	"net/http"
+	"time"
 )
 
 func readJsonRequestBody[T any](ctx *gin.Context) (value T, ok bool) {
@@ -29,3 +32,25 @@ func readJsonRequestBody[T any](ctx *gin.Context) (value T, ok bool) {
 	ok = true
 	return
 }
+
+var errInvalidWindow = errors.New("start of time window must be before the end")
+
+// parseWindow creates the time window for a request from optional unix timestamps. If the end is omitted, the window
+// ends at the current time. If the start is omitted, the window covers the full statistics period.
+func parseWindow(start, end *int64) (window traceroute.TimeRange, err error) {
+	window = traceroute.StatisticsWindow(time.Now())
+
+	if end != nil {
+		window = traceroute.StatisticsWindow(time.Unix(*end, 0))
+	}
+
+	if start != nil {
+		window.Start = time.Unix(*start, 0)
+	}
+
+	if !window.Start.Before(window.End) {
+		err = errInvalidWindow
+	}
+
+	return
+}
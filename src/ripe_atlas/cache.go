@@ -0,0 +1,333 @@
+package ripe_atlas
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const segmentFileSuffix = ".ndjson.gz"
+const partialFileSuffix = ".partial"
+
+var errStreamCancelled = errors.New("stream was cancelled")
+
+// segmentCache stores the results of a single measurement on disk as gzip compressed NDJSON segments which each cover
+// a fixed period of time. Segments that have already been downloaded in full are read back from disk, so only the
+// missing parts of a requested period need to be fetched from RIPE Atlas. Since a segment is written to a temporary
+// file before being moved into place, an interrupted download only loses the segments that were in progress. Downloads
+// resume at the granularity of whole segments, so an interrupted segment is downloaded again from its start.
+type segmentCache struct {
+	measurementID int
+	directory     string
+	resultsUrl    string
+	segmentLength time.Duration
+	settlePeriod  time.Duration
+	// Maximum number of consecutive missing segments fetched in a single request
+	segmentsPerRequest int
+}
+
+func makeSegmentCache(measurementID int) (cache segmentCache, err error) {
+	var cachePath string
+	if cachePath, err = util.GetCacheDir(); err != nil {
+		return
+	}
+
+	cache = segmentCache{
+		measurementID:      measurementID,
+		directory:          filepath.Join(cachePath, strconv.Itoa(measurementID)),
+		resultsUrl:         fmt.Sprintf("%s/%d/results", MeasurementsUrl, measurementID),
+		segmentLength:      config.CacheSegmentDuration.GetDuration(),
+		settlePeriod:       config.CacheSettleDuration.GetDuration(),
+		segmentsPerRequest: config.CacheSegmentsPerRequest.GetInt(),
+	}
+
+	err = os.MkdirAll(cache.directory, os.ModePerm)
+	return
+}
+
+func (cache segmentCache) segmentStart(timestamp time.Time) time.Time {
+	return timestamp.Truncate(cache.segmentLength)
+}
+
+func (cache segmentCache) segmentPath(start time.Time) string {
+	return filepath.Join(cache.directory, strconv.FormatInt(start.Unix(), 10)+segmentFileSuffix)
+}
+
+// isComplete checks if a segment has been stored to disk after late results for that segment should have stopped
+// arriving. Segments which were stored before this point are downloaded again on the next request.
+func (cache segmentCache) isComplete(start time.Time) bool {
+	stat, err := os.Stat(cache.segmentPath(start))
+	if err != nil {
+		return false
+	}
+
+	return stat.ModTime().After(start.Add(cache.segmentLength + cache.settlePeriod))
+}
+
+// segmentWriter compresses the results of a single segment into a temporary file while it is being downloaded
+type segmentWriter struct {
+	file   *os.File
+	writer *gzip.Writer
+}
+
+func (segment segmentWriter) Close() error {
+	err := segment.writer.Close()
+	if closeErr := segment.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// download fetches count consecutive segments beginning at start in a single request and splits the results into a
+// file per segment by their timestamps. Segments are only moved into place once the whole response has been read.
+func (cache segmentCache) download(ctx context.Context, start time.Time, count int) (err error) {
+	// The stop parameter is inclusive, so stop one second early to avoid overlapping with the next segment
+	stop := start.Add(time.Duration(count) * cache.segmentLength).Add(-time.Second)
+	url := fmt.Sprintf("%s?format=txt&start=%d&stop=%d", cache.resultsUrl, start.Unix(), stop.Unix())
+
+	var request *http.Request
+	if request, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil); err != nil {
+		return
+	}
+
+	var response *http.Response
+	if response, err = http.DefaultClient.Do(request); err != nil {
+		return
+	}
+
+	defer util.CloseAndLogErrors("Failed to close request for measurement results", response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("got unexpected status %q from %s", response.Status, url)
+	}
+
+	// Write to temporary files first so an interrupted download never leaves behind a segment that looks complete
+	segments := make([]segmentWriter, 0, count)
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		for _, segment := range segments {
+			util.RemoveAndLogErrors("Failed to remove partial cache segment", segment.file.Name())
+		}
+	}()
+
+	for index := 0; index < count; index++ {
+		segmentPath := cache.segmentPath(start.Add(time.Duration(index) * cache.segmentLength))
+
+		var file *os.File
+		if file, err = os.CreateTemp(cache.directory, filepath.Base(segmentPath)+".*"+partialFileSuffix); err != nil {
+			for _, segment := range segments {
+				util.CloseAndLogErrors("Failed to close partial cache segment", segment)
+			}
+			return
+		}
+
+		segments = append(segments, segmentWriter{file: file, writer: gzip.NewWriter(file)})
+	}
+
+	err = cache.splitResults(response.Body, start, segments)
+	for _, segment := range segments {
+		if closeErr := segment.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		return
+	}
+
+	for index, segment := range segments {
+		segmentPath := cache.segmentPath(start.Add(time.Duration(index) * cache.segmentLength))
+		if err = os.Rename(segment.file.Name(), segmentPath); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// splitResults writes each line of the input to the segment covering its timestamp. Lines which can not be placed are
+// kept in the first segment so they are reported when the results are parsed.
+func (cache segmentCache) splitResults(input io.Reader, start time.Time, segments []segmentWriter) error {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var result struct {
+			Timestamp int64 `json:"timestamp"`
+		}
+
+		index := 0
+		if json.Unmarshal(line, &result) == nil {
+			index = int(time.Unix(result.Timestamp, 0).Sub(start) / cache.segmentLength)
+			if index < 0 || index >= len(segments) {
+				index = 0
+			}
+		}
+
+		writer := segments[index].writer
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+		if _, err := writer.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (cache segmentCache) readSegment(start time.Time, output chan<- []byte, done <-chan struct{}) error {
+	file, err := os.Open(cache.segmentPath(start))
+	if err != nil {
+		return err
+	}
+
+	defer util.CloseAndLogErrors("Failed to close cache segment", file)
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	return sendLines(reader, output, done)
+}
+
+// evictSegmentsBefore removes segments which ended before the given time along with their left over partial downloads.
+// Partial downloads of later segments are kept since another request may still be downloading them.
+func (cache segmentCache) evictSegmentsBefore(timestamp time.Time) {
+	entries, err := os.ReadDir(cache.directory)
+	if err != nil {
+		log.Println("Unable to read cache directory for measurement", cache.measurementID, ":", err)
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// Partial downloads are named after their segment followed by a random suffix
+		prefix, _, found := strings.Cut(name, segmentFileSuffix)
+		unix, err := strconv.ParseInt(prefix, 10, 64)
+		if !found || err != nil || !time.Unix(unix, 0).Add(cache.segmentLength).Before(timestamp) {
+			continue
+		}
+
+		path := filepath.Join(cache.directory, name)
+		if strings.HasSuffix(name, partialFileSuffix) {
+			util.RemoveAndLogErrors("Failed to remove partial cache segment", path)
+		} else if name == prefix+segmentFileSuffix {
+			util.RemoveAndLogErrors("Failed to remove outdated cache segment", path)
+		}
+	}
+}
+
+// stream sends the lines of every segment overlapping the period from start to stop to the output channel, downloading
+// any segments which are missing or incomplete along the way. Consecutive missing segments are downloaded together. The
+// start of each segment which could not be downloaded is returned once all segments have been read or done is closed.
+func (cache segmentCache) stream(start, stop time.Time, output chan<- []byte, done <-chan struct{}) (skipped []time.Time) {
+	// Abort any download in progress once the stream is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	segment := cache.segmentStart(start)
+	for segment.Before(stop) {
+		count := 1
+		if !cache.isComplete(segment) {
+			for count < cache.segmentsPerRequest {
+				next := segment.Add(time.Duration(count) * cache.segmentLength)
+				if !next.Before(stop) || cache.isComplete(next) {
+					break
+				}
+				count += 1
+			}
+
+			if err := cache.download(ctx, segment, count); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				log.Println("Failed to download", count, "segments from", segment, "for measurement", cache.measurementID, ":", err)
+				for index := 0; index < count; index++ {
+					skipped = append(skipped, segment)
+					segment = segment.Add(cache.segmentLength)
+				}
+				continue
+			}
+		}
+
+		for index := 0; index < count; index++ {
+			if err := cache.readSegment(segment, output, done); err == errStreamCancelled {
+				return
+			} else if err != nil {
+				log.Println("Failed to read segment", segment, "for measurement", cache.measurementID, ":", err)
+			}
+
+			segment = segment.Add(cache.segmentLength)
+		}
+	}
+
+	return
+}
+
+// cancelCloser is an io.Closer which notifies a background task that it should stop. Segments which the task could not
+// download are reported as an error when it is closed, so callers know their results are incomplete.
+type cancelCloser struct {
+	done    chan struct{}
+	once    *sync.Once
+	lock    *sync.Mutex
+	skipped *[]time.Time
+}
+
+func makeCancelCloser() cancelCloser {
+	return cancelCloser{
+		done:    make(chan struct{}),
+		once:    new(sync.Once),
+		lock:    new(sync.Mutex),
+		skipped: new([]time.Time),
+	}
+}
+
+func (closer cancelCloser) setSkipped(skipped []time.Time) {
+	closer.lock.Lock()
+	defer closer.lock.Unlock()
+	*closer.skipped = skipped
+}
+
+func (closer cancelCloser) Close() error {
+	closer.once.Do(func() {
+		close(closer.done)
+	})
+
+	closer.lock.Lock()
+	defer closer.lock.Unlock()
+
+	if skipped := *closer.skipped; len(skipped) > 0 {
+		return fmt.Errorf("results are missing %d segments which could not be downloaded, starting at %v", len(skipped), skipped[0])
+	}
+
+	return nil
+}
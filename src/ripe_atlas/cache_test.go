@@ -0,0 +1,203 @@
+package ripe_atlas
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func makeTestSegmentCache(t *testing.T, requestCount *int64) segmentCache {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt64(requestCount, 1)
+
+		start, startErr := strconv.ParseInt(request.URL.Query().Get("start"), 10, 64)
+		stop, stopErr := strconv.ParseInt(request.URL.Query().Get("stop"), 10, 64)
+		if startErr != nil || stopErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Emit a single result at the start of every hour in the requested period, latest first
+		for timestamp := stop - stop%3600; timestamp >= start; timestamp -= 3600 {
+			_, _ = fmt.Fprintf(writer, "{\"msm_id\":1,\"prb_id\":2,\"timestamp\":%d,\"type\":\"traceroute\"}\n", timestamp)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return segmentCache{
+		measurementID:      1,
+		directory:          t.TempDir(),
+		resultsUrl:         server.URL,
+		segmentLength:      time.Hour,
+		settlePeriod:       time.Hour,
+		segmentsPerRequest: 24,
+	}
+}
+
+func collectLines(cache segmentCache, start, stop time.Time) [][]byte {
+	output := make(chan []byte, 64)
+	go func() {
+		defer close(output)
+		cache.stream(start, stop, output, nil)
+	}()
+
+	var lines [][]byte
+	for line := range output {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func TestSegmentCacheOnlyFetchesMissingSegments(t *testing.T) {
+	var requestCount int64
+	cache := makeTestSegmentCache(t, &requestCount)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(3 * time.Hour)
+
+	if lines := collectLines(cache, start, stop); len(lines) != 3 {
+		t.Fatalf("Expected 3 results from initial download, but got %d", len(lines))
+	}
+
+	// Consecutive missing segments are fetched together and split by the timestamps of their results
+	if requestCount != 1 {
+		t.Fatalf("Expected a single request for initial download, but got %d", requestCount)
+	}
+
+	for hour := 0; hour < 3; hour++ {
+		segment := start.Add(time.Duration(hour) * time.Hour)
+		if lines := collectLines(cache, segment, segment.Add(time.Hour)); len(lines) != 1 {
+			t.Fatalf("Expected segment %d to hold 1 result, but got %d", hour, len(lines))
+		}
+	}
+
+	// Extending the period should only download the segment which has not been seen yet
+	if lines := collectLines(cache, start, stop.Add(time.Hour)); len(lines) != 4 {
+		t.Fatalf("Expected 4 results from extended period, but got %d", len(lines))
+	}
+
+	if requestCount != 2 {
+		t.Fatalf("Expected only 1 additional request, but got %d", requestCount-1)
+	}
+}
+
+func TestSegmentCacheRefreshesIncompleteSegments(t *testing.T) {
+	var requestCount int64
+	cache := makeTestSegmentCache(t, &requestCount)
+
+	// A segment which ends in the future can still receive results, so it should be downloaded every time
+	start := time.Now().Truncate(time.Hour)
+	collectLines(cache, start, start.Add(time.Minute))
+	collectLines(cache, start, start.Add(time.Minute))
+
+	if requestCount != 2 {
+		t.Fatalf("Expected incomplete segment to be requested twice, but got %d requests", requestCount)
+	}
+}
+
+func TestSegmentCacheIgnoresPartialDownloads(t *testing.T) {
+	var requestCount int64
+	cache := makeTestSegmentCache(t, &requestCount)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	partialPath := cache.segmentPath(start) + ".interrupted" + partialFileSuffix
+	if err := os.WriteFile(partialPath, []byte("interrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := collectLines(cache, start, start.Add(time.Hour)); len(lines) != 1 {
+		t.Fatalf("Expected 1 result after resuming download, but got %d", len(lines))
+	}
+
+	if !cache.isComplete(start) {
+		t.Fatal("Expected completed segment to be kept")
+	}
+
+	// Another request may still be downloading a later segment, so only partial downloads of evicted segments are removed
+	inProgressPath := cache.segmentPath(start.Add(time.Hour)) + ".downloading" + partialFileSuffix
+	if err := os.WriteFile(inProgressPath, []byte("downloading"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.evictSegmentsBefore(start.Add(time.Hour + time.Second))
+	if _, err := os.Stat(partialPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected partial download to be removed, but got %v", err)
+	}
+
+	if _, err := os.Stat(inProgressPath); err != nil {
+		t.Fatalf("Expected download in progress to be kept, but got %v", err)
+	}
+}
+
+func TestSegmentCacheKeepsLaterSegments(t *testing.T) {
+	var requestCount int64
+	cache := makeTestSegmentCache(t, &requestCount)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	collectLines(cache, start.Add(2*time.Hour), start.Add(3*time.Hour))
+
+	// Requesting an earlier period, such as when backfilling, should not remove anything already downloaded
+	collectLines(cache, start, start.Add(time.Hour))
+	if !cache.isComplete(start.Add(2 * time.Hour)) {
+		t.Fatal("Expected later segment to be kept after requesting an earlier period")
+	}
+
+	if lines := collectLines(cache, start.Add(2*time.Hour), start.Add(3*time.Hour)); len(lines) != 1 || requestCount != 2 {
+		t.Fatalf("Expected later segment to be read from disk, but got %d results after %d requests", len(lines), requestCount)
+	}
+}
+
+func TestSegmentCacheReportsSkippedSegments(t *testing.T) {
+	var requestCount int64
+	cache := makeTestSegmentCache(t, &requestCount)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	cache.resultsUrl = server.URL
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	channel, closer := cache.results(start, start.Add(3*time.Hour))
+	for range channel {
+		t.Error("Expected no results from failed download")
+	}
+
+	if err := closer.Close(); err == nil {
+		t.Fatal("Expected skipped segments to be reported when closing the results")
+	}
+
+	if cache.isComplete(start) {
+		t.Fatal("Expected failed segment to be downloaded again on the next request")
+	}
+}
+
+func TestSegmentCacheCancelsDownloads(t *testing.T) {
+	var requestCount int64
+	cache := makeTestSegmentCache(t, &requestCount)
+
+	// The server never responds, so the stream can only finish if closing it aborts the request
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		<-request.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	cache.resultsUrl = server.URL
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	channel, closer := cache.results(start, start.Add(3*time.Hour))
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-channel:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected download to be aborted once the results were closed")
+	}
+}
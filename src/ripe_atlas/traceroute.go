@@ -3,16 +3,13 @@ package ripe_atlas
 import (
 	"bufio"
 	"encoding/json"
-	"fmt"
 	"github.com/DNS-OARC/ripeatlas"
 	"github.com/DNS-OARC/ripeatlas/measurement"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
 	"io"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -68,32 +65,60 @@ func GetTraceRouteDataFromFile(path string) (<-chan *measurement.Result, error)
 		return nil, err
 	}
 
-	byteChannel, outputChannel := util.MakeWorkGroup(64, func(bytes []byte, output chan *measurement.Result) {
-		var out *measurement.Result
-		if err := json.Unmarshal(bytes, &out); err != nil {
-			log.Println("Received error while reading input JSON:", err)
-		} else {
-			output <- out
-		}
-	})
-
+	byteChannel, outputChannel := makeResultParser()
 	go breakReaderIntoLines(file, byteChannel)
 
 	return outputChannel, nil
 }
 
 // GetLatestTraceRouteData is similar to the ripeatlas equivalent, but this version uses a thread pool to speed up
-// parsing messages
+// parsing messages. Results are read through the on-disk segment cache, so only segments which have not already been
+// downloaded are requested from RIPE Atlas. Closing the returned io.Closer stops the download early and returns an error
+// if any segments could not be downloaded.
 func GetLatestTraceRouteData(measurementID int) (<-chan *measurement.Result, io.Closer, error) {
-	startTime := time.Now().Add(-config.StatisticsPeriod.GetDuration())
+	stopTime := time.Now()
+	startTime := stopTime.Add(-config.StatisticsPeriod.GetDuration())
 
-	url := fmt.Sprintf("%s/%d/results?format=txt&start=%d", MeasurementsUrl, measurementID, startTime.Unix())
-	res, err := http.Get(url)
+	cache, err := makeSegmentCache(measurementID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	byteChannel, outputChannel := util.MakeWorkGroup(64, func(bytes []byte, output chan *measurement.Result) {
+	// Segments from before the statistics period will never be requested again
+	cache.evictSegmentsBefore(startTime)
+
+	channel, closer := cache.results(startTime, stopTime)
+	return channel, closer, nil
+}
+
+// GetCachedTraceRouteData retrieves the results of a measurement between startTime and stopTime using the on-disk
+// segment cache. Results are not guaranteed to be in order and may include results from shortly before startTime.
+func GetCachedTraceRouteData(measurementID int, startTime, stopTime time.Time) (<-chan *measurement.Result, io.Closer, error) {
+	cache, err := makeSegmentCache(measurementID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	channel, closer := cache.results(startTime, stopTime)
+	return channel, closer, nil
+}
+
+// results parses the results stored in the segments covering the period from startTime to stopTime
+func (cache segmentCache) results(startTime, stopTime time.Time) (<-chan *measurement.Result, io.Closer) {
+	closer := makeCancelCloser()
+	byteChannel, outputChannel := makeResultParser()
+	go func() {
+		// Record skipped segments before closing the channel so they are known once all results have been received
+		defer close(byteChannel)
+		closer.setSkipped(cache.stream(startTime, stopTime, byteChannel, closer.done))
+	}()
+
+	return outputChannel, closer
+}
+
+// makeResultParser creates a work group which parses lines of JSON into measurement results
+func makeResultParser() (chan []byte, <-chan *measurement.Result) {
+	return util.MakeWorkGroup(64, func(bytes []byte, output chan *measurement.Result) {
 		var out *measurement.Result
 		if err := json.Unmarshal(bytes, &out); err != nil {
 			log.Println("Received error while reading input JSON:", err)
@@ -101,15 +126,20 @@ func GetLatestTraceRouteData(measurementID int) (<-chan *measurement.Result, io.
 			output <- out
 		}
 	})
-
-	go breakReaderIntoLines(res.Body, byteChannel)
-
-	return outputChannel, res.Body, nil
 }
 
 func breakReaderIntoLines(input io.ReadCloser, lineBytesOutput chan []byte) {
 	defer util.CloseAndLogErrors("Failed to close input after reading traceroute data", input)
 	defer close(lineBytesOutput)
+
+	if err := sendLines(input, lineBytesOutput, nil); err != nil {
+		log.Println("Got error while reading traceroute data from input:", err)
+	}
+}
+
+// sendLines breaks the input into lines and distributes them to the output channel. If done is closed before the
+// input has been fully read, errStreamCancelled is returned.
+func sendLines(input io.Reader, lineBytesOutput chan<- []byte, done <-chan struct{}) error {
 	bufferedRead := bufio.NewReader(input)
 	scanner := bufio.NewScanner(bufferedRead)
 
@@ -118,62 +148,13 @@ func breakReaderIntoLines(input io.ReadCloser, lineBytesOutput chan []byte) {
 		line := scanner.Bytes()
 		buffer := make([]byte, len(line), len(line))
 		copy(buffer, line)
-		lineBytesOutput <- buffer
-	}
-
-	if err := scanner.Err(); err != nil {
-		log.Println("Got error while reading traceroute data from input:", err)
-	}
-}
-
-func updateCacheFile(measurementID int, cacheFile string) error {
-	file, err := os.Create(cacheFile)
-	if err != nil {
-		return err
-	}
-
-	writer := bufio.NewWriter(file)
-	defer util.CloseAndLogErrors("Failed to close cache file writer", file)
-
-	startTime := time.Now().Add(-config.StatisticsPeriod.GetDuration())
-
-	url := fmt.Sprintf("%s/%d/results?format=txt&start=%d", MeasurementsUrl, measurementID, startTime.Unix())
-	res, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-
-	defer util.CloseAndLogErrors("Failed to close request for measurement results", res.Body)
-	if _, err := io.Copy(writer, res.Body); err != nil {
-		return err
-	}
-
-	return writer.Flush()
-}
-
-func CachedGetTraceRouteData(measurementID int) (channel <-chan *measurement.Result, err error) {
-	var cachePath string
-	if cachePath, err = util.GetCacheDir(); err != nil {
-		return
-	}
-
-	cacheFile := filepath.Join(cachePath, fmt.Sprintf("%d.ndjson", measurementID))
-	var stat os.FileInfo
-	stat, err = os.Stat(cacheFile)
-
-	if err != nil && !os.IsNotExist(err) {
-		return
-	}
-
-	cacheDuration := config.CacheStoreDuration.GetDuration()
-
-	if err != nil || stat.ModTime().Add(cacheDuration).Before(time.Now()) {
-		log.Println("Refreshing cache entry for measurement", measurementID)
 
-		if err = updateCacheFile(measurementID, cacheFile); err != nil {
-			return
+		select {
+		case lineBytesOutput <- buffer:
+		case <-done:
+			return errStreamCancelled
 		}
 	}
 
-	return GetTraceRouteDataFromFile(cacheFile)
+	return scanner.Err()
 }
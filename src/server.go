@@ -23,6 +23,11 @@ func main() {
 	services := []service.Service{
 		service.IpToAsnService{},
 		service.TracerouteDataService{},
+		service.ReverseDnsService{},
+		service.NewGeoIpService(),
+		service.NewCatchmentService(),
+		service.NewAliasResolutionService(),
+		service.NewAlertService(),
 		rest_api.NewRestApiService(),
 		service.NewProbeCollectionService(),
 		// etc...
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/alert"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"net/netip"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// alertRulesFileName is the file under the cache directory alerting rules are saved to
+	alertRulesFileName = "alert_rules.json"
+	// alertQueueSize is the number of notifications which can wait to be delivered at once
+	alertQueueSize = 1024
+)
+
+// AlertService delivers alert notifications and periodically evaluates every rule against every route. Rules are also
+// evaluated for a route each time a result is added to it.
+type AlertService struct {
+	notifier *alert.WebhookNotifier
+}
+
+func NewAlertService() *AlertService {
+	return new(AlertService)
+}
+
+func (service *AlertService) Name() string {
+	return "AlertService"
+}
+
+func (service *AlertService) Init(state *ApplicationState) error {
+	cacheDir, err := util.GetCacheDir()
+	if err != nil {
+		return err
+	}
+
+	service.notifier = alert.MakeWebhookNotifier(config.AlertWebhookTimeout.GetDuration(), alertQueueSize)
+
+	// No locking needed since init is done in a single threaded context
+	state.Alerts, err = alert.LoadEngine(filepath.Join(cacheDir, alertRulesFileName), service.notifier)
+	return err
+}
+
+func (service *AlertService) Run(state *ApplicationState) error {
+	go service.notifier.Run()
+
+	for {
+		time.Sleep(config.AlertEvaluationPeriod.GetDuration())
+
+		state.TracerouteDataLock.Lock()
+		state.Alerts.EvaluateAll(&state.TracerouteData, time.Now())
+		state.TracerouteDataLock.Unlock()
+	}
+}
+
+// evaluateAlerts checks the rules which apply to the route of a newly added result. The traceroute data lock must be
+// held.
+func (state *ApplicationState) evaluateAlerts(msg *measurement.Result) {
+	if state.Alerts == nil {
+		return
+	}
+
+	destination, err := netip.ParseAddr(msg.DstName())
+	if err != nil {
+		return
+	}
+
+	if routeData, ok := state.TracerouteData.GetRouteData(msg.PrbId(), destination); ok {
+		state.Alerts.EvaluateRoute(state.TracerouteData.Events, routeData, time.Now())
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/alias"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"log"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// AliasResolutionService periodically groups the interface addresses seen in the traceroute data into routers
+type AliasResolutionService struct {
+	// knownAliases holds the aliases loaded from ALIAS_FILE which are included in every refresh
+	knownAliases alias.Resolver
+}
+
+func NewAliasResolutionService() *AliasResolutionService {
+	return new(AliasResolutionService)
+}
+
+func (service *AliasResolutionService) Name() string {
+	return "AliasResolutionService"
+}
+
+func (service *AliasResolutionService) Init(state *ApplicationState) (err error) {
+	service.knownAliases = alias.MakeResolver()
+
+	if path := config.AliasFile.GetString(); path != "" {
+		if service.knownAliases, err = readAliasFile(path); err != nil {
+			return
+		}
+
+		log.Println("Loaded aliases for", service.knownAliases.Len(), "addresses from", path)
+	}
+
+	// No locking needed since init is done in a single threaded context
+	state.Aliases = alias.MakeResolver()
+	state.Aliases.Merge(&service.knownAliases)
+	return
+}
+
+func readAliasFile(path string) (alias.Resolver, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return alias.Resolver{}, err
+	}
+	defer util.CloseAndLogErrors("Failed to close alias file:", file)
+
+	resolver, err := alias.ReadItdkNodes(file)
+	if err != nil {
+		return resolver, fmt.Errorf("unable to read alias file %s: %w", path, err)
+	}
+
+	return resolver, nil
+}
+
+func (service *AliasResolutionService) Run(state *ApplicationState) error {
+	for {
+		resolver := alias.Infer(collectAliasEvidence(state))
+		resolver.Merge(&service.knownAliases)
+
+		state.aliasLock.Lock()
+		state.Aliases = resolver
+		state.aliasLock.Unlock()
+
+		time.Sleep(config.AliasRefreshPeriod.GetDuration())
+	}
+}
+
+// collectAliasEvidence gathers the links between public addresses seen at consecutive hops of every route
+func collectAliasEvidence(state *ApplicationState) alias.Evidence {
+	evidence := alias.MakeEvidence()
+	evidence.Hostnames = state.GetHostname
+
+	state.TracerouteDataLock.Lock()
+	defer state.TracerouteDataLock.Unlock()
+
+	for _, route := range state.TracerouteData.Routes() {
+		for _, link := range route.GetLinks() {
+			evidence.AddLink(link.From, link.To)
+		}
+	}
+
+	return evidence
+}
+
+// GetRouter gets the address used to identify the router an interface address belongs to
+func (state *ApplicationState) GetRouter(addr netip.Addr) netip.Addr {
+	state.aliasLock.RLock()
+	defer state.aliasLock.RUnlock()
+	return state.Aliases.Find(addr)
+}
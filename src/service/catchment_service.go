@@ -0,0 +1,121 @@
+package service
+
+import (
+	"fmt"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/catchment"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"log"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// siteMapReloadCheckPeriod is how often the catchment site file is checked for changes
+const siteMapReloadCheckPeriod = time.Minute
+
+// CatchmentService loads the mapping of routers to anycast sites and reloads it whenever the file is changed
+type CatchmentService struct {
+	lastModified time.Time
+}
+
+func NewCatchmentService() *CatchmentService {
+	return new(CatchmentService)
+}
+
+func (service *CatchmentService) Name() string {
+	return "CatchmentService"
+}
+
+func (service *CatchmentService) Init(state *ApplicationState) error {
+	path := config.CatchmentSiteFile.GetString()
+	if path == "" {
+		log.Println("No catchment site file configured, so sites will only be found from reverse DNS")
+		return nil
+	}
+
+	return service.load(state, path)
+}
+
+func (service *CatchmentService) load(state *ApplicationState, path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	sites, err := readSiteFile(path)
+	if err != nil {
+		return err
+	}
+
+	state.siteLock.Lock()
+	state.Sites = &sites
+	state.siteLock.Unlock()
+
+	service.lastModified = stat.ModTime()
+	return nil
+}
+
+func readSiteFile(path string) (catchment.SiteMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return catchment.SiteMap{}, err
+	}
+	defer util.CloseAndLogErrors("Failed to close catchment site file:", file)
+
+	sites, err := catchment.ReadSiteMap(file)
+	if err != nil {
+		return sites, fmt.Errorf("unable to read catchment site file %s: %w", path, err)
+	}
+
+	return sites, nil
+}
+
+func (service *CatchmentService) Run(state *ApplicationState) error {
+	path := config.CatchmentSiteFile.GetString()
+
+	for {
+		time.Sleep(siteMapReloadCheckPeriod)
+		if path == "" {
+			continue
+		}
+
+		if stat, err := os.Stat(path); err != nil || !stat.ModTime().After(service.lastModified) {
+			continue
+		}
+
+		// Keep using the previous mapping if the new one can not be read
+		if err := service.load(state, path); err != nil {
+			log.Println("Failed to reload catchment site file:", err)
+		} else {
+			log.Println("Reloaded catchment site file from", path)
+		}
+	}
+}
+
+// GetSite finds the anycast site a router belongs to. The site file is checked first, and routers which are not listed
+// fall back to the IATA code or city found from their reverse DNS hostname.
+func (state *ApplicationState) GetSite(addr netip.Addr) (string, bool) {
+	hostname, _ := state.GetHostname(addr)
+
+	state.siteLock.RLock()
+	sites := state.Sites
+	state.siteLock.RUnlock()
+
+	if sites != nil {
+		if site, ok := sites.Lookup(addr, hostname); ok {
+			return site, true
+		}
+	}
+
+	location, ok := state.GetLocation(addr)
+	if !ok {
+		return "", false
+	}
+
+	if location.Iata != "" {
+		return location.Iata, true
+	}
+
+	return location.City, location.City != ""
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/geo"
+	"log"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// geoIpReloadCheckPeriod is how often the geolocation database is checked for changes
+const geoIpReloadCheckPeriod = time.Hour
+
+// GeoIpService loads the geolocation database and reloads it whenever the file is replaced
+type GeoIpService struct {
+	lastModified time.Time
+}
+
+func NewGeoIpService() *GeoIpService {
+	return new(GeoIpService)
+}
+
+func (service *GeoIpService) Name() string {
+	return "GeoIpService"
+}
+
+func (service *GeoIpService) Init(state *ApplicationState) error {
+	path := config.GeoIpDatabase.GetString()
+	if path == "" {
+		log.Println("No geolocation database configured, so hops will not be geolocated")
+		return nil
+	}
+
+	// No locking needed since init is done in a single threaded context
+	return service.load(state, path)
+}
+
+func (service *GeoIpService) load(state *ApplicationState, path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	database, err := geo.Open(path)
+	if err != nil {
+		return err
+	}
+
+	state.geoIpLock.Lock()
+	previous := state.GeoIp
+	state.GeoIp = database
+	state.geoIpLock.Unlock()
+
+	if previous != nil {
+		if err = previous.Close(); err != nil {
+			log.Println("Failed to close previous geolocation database:", err)
+		}
+	}
+
+	service.lastModified = stat.ModTime()
+	return nil
+}
+
+func (service *GeoIpService) Run(state *ApplicationState) error {
+	path := config.GeoIpDatabase.GetString()
+
+	for {
+		time.Sleep(geoIpReloadCheckPeriod)
+		if path == "" {
+			continue
+		}
+
+		if stat, err := os.Stat(path); err != nil || !stat.ModTime().After(service.lastModified) {
+			continue
+		}
+
+		// Keep using the previous database if the new one can not be read
+		if err := service.load(state, path); err != nil {
+			log.Println("Failed to reload geolocation database:", err)
+		} else {
+			log.Println("Reloaded geolocation database from", path)
+		}
+	}
+}
+
+// GetGeolocation gets the location of an address from the geolocation database
+func (state *ApplicationState) GetGeolocation(addr netip.Addr) (geo.Location, bool) {
+	state.geoIpLock.RLock()
+	defer state.geoIpLock.RUnlock()
+
+	if state.GeoIp == nil {
+		return geo.Location{}, false
+	}
+
+	return state.GeoIp.Lookup(addr)
+}
+
+// GetProbeLocation gets the coordinates reported by a probe which has been used to reach the given destination
+func (state *ApplicationState) GetProbeLocation(probeId int, destination netip.Addr) (geo.Location, bool) {
+	state.ProbeDataLock.RLock()
+	defer state.ProbeDataLock.RUnlock()
+
+	for _, usage := range state.DestinationToProbeMap[destination] {
+		// Coordinates are stored in GeoJson order as [Longitude, Latitude]
+		if usage.Probe.Id == probeId && len(usage.Probe.Coordinates) == 2 {
+			return geo.Location{
+				Latitude:  usage.Probe.Coordinates[1],
+				Longitude: usage.Probe.Coordinates[0],
+				Country:   usage.Probe.CountryCode,
+			}, true
+		}
+	}
+
+	return geo.Location{}, false
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/rdns"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"log"
+	"net/netip"
+	"os"
+	"time"
+)
+
+const (
+	// reverseDnsQueueSize is the number of addresses which can wait to be resolved at once
+	reverseDnsQueueSize = 4096
+	// reverseDnsSavePeriod is how often newly resolved hostnames are written to the cache
+	reverseDnsSavePeriod = time.Minute
+)
+
+// ReverseDnsService resolves the hostnames of addresses seen in traceroutes in the background
+type ReverseDnsService struct{}
+
+func (ReverseDnsService) Name() string {
+	return "ReverseDnsService"
+}
+
+func (ReverseDnsService) Init(state *ApplicationState) error {
+	cacheDir, err := util.GetCacheDir()
+	if err != nil {
+		return err
+	}
+
+	cache, err := rdns.LoadCache(cacheDir, config.RdnsCacheDuration.GetDuration())
+	if err != nil {
+		return err
+	}
+
+	rules := rdns.DefaultRules()
+	if path := config.RdnsRulesFile.GetString(); path != "" {
+		if rules, err = readRulesFile(path); err != nil {
+			return err
+		}
+	}
+
+	resolver := rdns.MakeNetResolver(config.RdnsServer.GetString())
+
+	// No locking needed since init is done in a single threaded context
+	state.ReverseDns = rdns.MakeEnricher(cache, resolver, rules, reverseDnsQueueSize)
+	return nil
+}
+
+func readRulesFile(path string) ([]rdns.Rule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer util.CloseAndLogErrors("Failed to close reverse DNS rules file:", file)
+
+	return rdns.ReadRules(file)
+}
+
+func (ReverseDnsService) Run(state *ApplicationState) error {
+	go state.ReverseDns.Run(context.Background())
+
+	for {
+		time.Sleep(reverseDnsSavePeriod)
+
+		if err := state.ReverseDns.Save(); err != nil {
+			log.Println("Failed to save reverse DNS cache:", err)
+		}
+	}
+}
+
+// GetHostname gets the reverse DNS hostname of an address if it has been resolved. Addresses which have not been
+// resolved yet are queued to be resolved in the background.
+func (state *ApplicationState) GetHostname(addr netip.Addr) (string, bool) {
+	if state.ReverseDns == nil {
+		return "", false
+	}
+
+	return state.ReverseDns.GetHostname(addr)
+}
+
+// GetLocation gets the location of an address based on its reverse DNS hostname
+func (state *ApplicationState) GetLocation(addr netip.Addr) (rdns.Location, bool) {
+	if state.ReverseDns == nil {
+		return rdns.Location{}, false
+	}
+
+	return state.ReverseDns.GetLocation(addr)
+}
@@ -1,8 +1,14 @@
 package service
 
 import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/alert"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/alias"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/asn"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/catchment"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/geo"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/probe"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/rdns"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/stream"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
 	"net/netip"
 	"sync"
@@ -26,6 +32,28 @@ type ApplicationState struct {
 	TracerouteDataLock sync.Mutex
 
 	StoredMeasurements MeasurementTracker
+
+	// Aliases groups interface addresses into routers. It is replaced by AliasResolutionService on each refresh.
+	Aliases   alias.Resolver
+	aliasLock sync.RWMutex
+
+	// ReverseDns is safe to use from multiple goroutines. It is nil if reverse DNS is not running.
+	ReverseDns *rdns.Enricher
+
+	// GeoIp is nil when no geolocation database has been configured
+	GeoIp     geo.Database
+	geoIpLock sync.RWMutex
+
+	// Sites is nil when no catchment site file has been configured
+	Sites    *catchment.SiteMap
+	siteLock sync.RWMutex
+
+	// Alerts holds the alerting rules and is safe to use from multiple goroutines. Evaluating rules requires the
+	// traceroute data lock.
+	Alerts *alert.Engine
+
+	// Streams sends live updates to clients watching a route and is safe to use from multiple goroutines
+	Streams *stream.Hub
 }
 
 // InitApplicationState created the initial state to use upon the start of the application. This function is
@@ -5,10 +5,13 @@ import (
 	"github.com/DNS-OARC/ripeatlas/measurement"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/ripe_atlas"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/stream"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"io"
 	"log"
 	"net/netip"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -22,6 +25,7 @@ func (TracerouteDataService) Name() string {
 func (TracerouteDataService) Init(state *ApplicationState) (err error) {
 	state.TracerouteData = traceroute.MakeTracerouteData()
 	state.StoredMeasurements = MakeMeasurementTracker()
+	state.Streams = stream.MakeHub(config.StreamBufferSize.GetInt())
 	return
 }
 
@@ -61,20 +65,11 @@ loop:
 				info = state.StoredMeasurements.getOrCreateMeasurement(msg.MsmId())
 			}
 
-			info.Lock.Lock()
-			info.UpdateLatestMeasurement(msg)
-			info.Lock.Unlock()
-
 			// Increment the progress counter so it knows how many messages have been received when calling the periodic
 			// function.
 			progressCounter.Increment()
 
-			// Since we mutate the shared traceroute state we need to ensure exclusive access to the traceroute state.
-			// Unlike other systems where data is swapped out, traceroute data is regularly mutated in place leading to
-			// a higher risk of undefined behavior from concurrent reading/writing.
-			state.TracerouteDataLock.Lock()
-			state.TracerouteData.AppendMeasurement(msg)
-			state.TracerouteDataLock.Unlock()
+			ingestMeasurement(state, info, msg, false)
 		case <-time.After(3 * time.Second):
 			// We could potentially be waiting for longer than the progress counter interval to receive a message. This
 			// timeout simply breaks us out of waiting so the progress counter can call the periodic function.
@@ -86,6 +81,46 @@ loop:
 	log.Println("[Traceroute Progress] Exited after parsing a total of", progressCounter.Count(), "traceroute messages")
 }
 
+// ingestMeasurement adds a result to the shared traceroute data and updates the collection info for its measurement.
+// Alerting rules are only checked for live results. Rule windows end at the current time, so checking them for every
+// result of a history load would repeat the same work, and AlertService still checks every route periodically.
+func ingestMeasurement(state *ApplicationState, info *MeasurementCollectionInfo, msg *measurement.Result, live bool) {
+	// Since we mutate the shared traceroute state we need to ensure exclusive access to the traceroute state. Unlike
+	// other systems where data is swapped out, traceroute data is regularly mutated in place leading to a higher risk
+	// of undefined behavior from concurrent reading/writing.
+	state.TracerouteDataLock.Lock()
+	outcome := state.TracerouteData.AppendMeasurement(msg)
+	if outcome == traceroute.ResultAppended {
+		if live {
+			state.evaluateAlerts(msg)
+		}
+
+		state.Streams.PublishResult(&state.TracerouteData, msg, time.Now())
+	}
+	state.TracerouteDataLock.Unlock()
+
+	info.Lock.Lock()
+	switch outcome {
+	case traceroute.ResultAppended:
+		info.UpdateLatestMeasurement(msg)
+	case traceroute.ResultDuplicate:
+		info.DroppedDuplicates += 1
+	case traceroute.ResultRejected:
+		info.RejectedResults += 1
+	}
+	info.Lock.Unlock()
+}
+
+// ingestLiveMeasurement is the live collection equivalent of ingestMeasurement which also moves the checkpoint used to
+// backfill results after a reconnect
+func ingestLiveMeasurement(state *ApplicationState, info *MeasurementCollectionInfo, msg *measurement.Result) {
+	ingestMeasurement(state, info, msg, true)
+
+	info.Lock.Lock()
+	info.UpdateLiveCheckpoint(msg)
+	info.Lock.Unlock()
+}
+
 func handleRetrieveHistory(state *ApplicationState, info *MeasurementCollectionInfo) {
 	channel, closer, err := ripe_atlas.GetLatestTraceRouteData(info.Id)
 	defer info.SetCollectingHistory(false)
@@ -96,7 +131,8 @@ func handleRetrieveHistory(state *ApplicationState, info *MeasurementCollectionI
 		return
 	}
 
-	defer util.CloseAndLogErrors("Failed to close request for measurement results", closer)
+	// Closing reports any segments of history which could not be downloaded
+	defer util.CloseAndLogErrors("Incomplete history for measurement "+strconv.Itoa(info.Id)+":", closer)
 
 	for {
 		msg, ok := <-channel
@@ -112,55 +148,127 @@ func handleRetrieveHistory(state *ApplicationState, info *MeasurementCollectionI
 			continue
 		}
 
-		info.Lock.Lock()
-		info.UpdateLatestMeasurement(msg)
-		info.Lock.Unlock()
-
-		state.TracerouteDataLock.Lock()
-		state.TracerouteData.AppendMeasurement(msg)
-		state.TracerouteDataLock.Unlock()
+		ingestMeasurement(state, info, msg, false)
 	}
 }
 
 func handleLiveCollection(state *ApplicationState, info *MeasurementCollectionInfo) {
-	channel, err := ripe_atlas.GetStreamingTraceRouteData(info.Id)
 	defer info.SetPerformingLiveCollection(false)
 	defer log.Println("Exiting live collection goroutine for measurement", info.Id)
 
-	if err != nil {
-		log.Println("Encountered error when trying to fetch measurement history:", err)
-		return
-	}
+	backoff := util.MakeBackoff(config.LiveReconnectMinBackoff.GetDuration(), config.LiveReconnectMaxBackoff.GetDuration())
 
-loop:
 	for {
-		select {
-		case msg, ok := <-channel:
-			// If channel is closed and there are no more messages to receive, break loop
-			if !ok {
-				break loop
+		channel, err := ripe_atlas.GetStreamingTraceRouteData(info.Id)
+		if err != nil {
+			log.Println("Encountered error when trying to connect to measurement stream:", err)
+		} else {
+			// If we have received results before, then the stream must have been dropped. Anything published while we
+			// were disconnected needs to be fetched from the results API instead.
+			if checkpoint := info.GetLiveCheckpoint(); checkpoint.After(time.Unix(0, 0)) && info.requestBackfill(checkpoint) {
+				go backfillLiveCollection(state, info)
 			}
 
-			// Check if the measurement actually exists
-			if msg == nil {
-				log.Println("Encountered nil message while retrieving history")
-				continue loop
+			receivedResults, stopRequested := receiveLiveResults(state, info, channel)
+			if stopRequested {
+				return
 			}
 
-			state.TracerouteDataLock.Lock()
-			state.TracerouteData.AppendMeasurement(msg)
-			state.TracerouteDataLock.Unlock()
+			if receivedResults {
+				backoff.Reset()
+			}
+		}
 
-			info.Lock.Lock()
-			info.UpdateLatestMeasurement(msg)
+		delay := backoff.Next()
+		log.Println("Live collection stream for measurement", info.Id, "was dropped. Reconnecting in", delay)
+		if waitToReconnect(info, delay) {
+			return
+		}
 
-			if info.RequestStopLiveCollection {
-				info.RequestStopLiveCollection = false
-				info.Lock.Unlock()
-				return
-			}
+		info.Lock.Lock()
+		info.LiveReconnects += 1
+		info.Lock.Unlock()
+	}
+}
+
+// liveStopPollInterval is how often live collection checks if it should stop while waiting to reconnect
+const liveStopPollInterval = time.Second
+
+// waitToReconnect waits for the reconnect delay of a dropped live collection stream. It returns early if a stop is
+// requested in the meantime and checks once more when the delay is over, so a stop is never followed by a reconnect.
+func waitToReconnect(info *MeasurementCollectionInfo, delay time.Duration) (stopRequested bool) {
+	deadline := time.Now().Add(delay)
+	for {
+		if info.takeStopRequest() {
+			return true
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+
+		if remaining > liveStopPollInterval {
+			remaining = liveStopPollInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// receiveLiveResults ingests results from a live collection stream until the stream is closed or a stop is requested
+func receiveLiveResults(state *ApplicationState, info *MeasurementCollectionInfo, channel <-chan *measurement.Result) (receivedResults, stopRequested bool) {
+	for {
+		msg, ok := <-channel
 
-			info.Lock.Unlock()
+		// If channel is closed and there are no more messages to receive, the stream has been dropped
+		if !ok {
+			return
+		}
+
+		// Check if the measurement actually exists
+		if msg == nil {
+			log.Println("Encountered nil message during live collection")
+			continue
+		}
+
+		if msg.ParseError != nil {
+			log.Println("Encountered error during live collection:", msg.ParseError)
+			continue
+		}
+
+		receivedResults = true
+		ingestLiveMeasurement(state, info, msg)
+
+		if info.takeStopRequest() {
+			stopRequested = true
+			return
+		}
+	}
+}
+
+// backfillLiveCollection fetches the results which were published while a live collection stream was disconnected. It
+// keeps going until no more backfills have been requested, so only one backfill runs at a time for each measurement.
+func backfillLiveCollection(state *ApplicationState, info *MeasurementCollectionInfo) {
+	for checkpoint, ok := info.nextBackfill(); ok; checkpoint, ok = info.nextBackfill() {
+		backfillLiveCollectionFrom(state, info, checkpoint)
+	}
+}
+
+func backfillLiveCollectionFrom(state *ApplicationState, info *MeasurementCollectionInfo, checkpoint time.Time) {
+	startTime := checkpoint.Add(-config.LiveBackfillOverlap.GetDuration())
+	log.Println("Backfilling measurement", info.Id, "from", startTime)
+
+	channel, closer, err := ripe_atlas.GetCachedTraceRouteData(info.Id, startTime, time.Now())
+	if err != nil {
+		log.Println("Encountered error when trying to backfill live collection:", err)
+		return
+	}
+
+	defer util.CloseAndLogErrors("Incomplete backfill for measurement "+strconv.Itoa(info.Id)+":", closer)
+
+	for msg := range channel {
+		if msg != nil && !time.Unix(int64(msg.Timestamp()), 0).Before(startTime) {
+			ingestLiveMeasurement(state, info, msg)
 		}
 	}
 }
@@ -170,11 +278,13 @@ func (service TracerouteDataService) Run(state *ApplicationState) (err error) {
 
 	for _, id := range config.DebugMeasurementList.GetIntList() {
 		log.Println("Loading debug measurement ID", id)
-		if resultChannel, err = ripe_atlas.CachedGetTraceRouteData(id); err != nil {
+		var closer io.Closer
+		if resultChannel, closer, err = ripe_atlas.GetLatestTraceRouteData(id); err != nil {
 			return
 		}
 
 		service.handleIncomingMessages(state, resultChannel)
+		util.CloseAndLogErrors("Failed to close debug measurement results", closer)
 	}
 	log.Println("Finished adding debug measurements")
 
@@ -258,6 +368,7 @@ func (tracker *MeasurementTracker) getOrCreateMeasurement(measurement int) *Meas
 		RequestStopLiveCollection: false,
 		LatestData:                time.Unix(0, 0),
 		OldestData:                time.Unix(0, 0),
+		LastLiveResult:            time.Unix(0, 0),
 	}
 
 	value, _ := tracker.TrackedMeasurements.LoadOrStore(measurement, newData)
@@ -284,6 +395,21 @@ type MeasurementCollectionInfo struct {
 	LatestData                time.Time
 	OldestData                time.Time
 
+	// LastLiveResult is the timestamp of the latest result received by live collection. It acts as a checkpoint for
+	// backfilling results that were missed while the stream was disconnected.
+	LastLiveResult time.Time
+	LiveReconnects int
+
+	// BackfillingLiveCollection is set while results missed during a disconnect are being fetched. Reconnects which
+	// happen in the meantime leave their checkpoint in pendingBackfill for the running backfill to pick up.
+	BackfillingLiveCollection bool
+	pendingBackfill           time.Time
+
+	// DroppedDuplicates counts the results which were ignored since they had already been received
+	DroppedDuplicates uint64
+	// RejectedResults counts the results which were ignored since they contained errors
+	RejectedResults uint64
+
 	Lock sync.Mutex
 }
 
@@ -314,12 +440,64 @@ func (info *MeasurementCollectionInfo) UpdateLatestMeasurement(msg *measurement.
 	}
 }
 
+func (info *MeasurementCollectionInfo) UpdateLiveCheckpoint(msg *measurement.Result) {
+	if timestamp := time.Unix(int64(msg.Timestamp()), 0); info.LastLiveResult.Before(timestamp) {
+		info.LastLiveResult = timestamp
+	}
+}
+
+func (info *MeasurementCollectionInfo) GetLiveCheckpoint() time.Time {
+	info.Lock.Lock()
+	defer info.Lock.Unlock()
+	return info.LastLiveResult
+}
+
+// requestBackfill queues a backfill from the checkpoint and reports if a new backfill needs to be started for it
+func (info *MeasurementCollectionInfo) requestBackfill(checkpoint time.Time) bool {
+	info.Lock.Lock()
+	defer info.Lock.Unlock()
+
+	// The earliest checkpoint covers every later gap since backfills fetch everything up to the current time
+	if info.pendingBackfill.IsZero() || checkpoint.Before(info.pendingBackfill) {
+		info.pendingBackfill = checkpoint
+	}
+
+	if info.BackfillingLiveCollection {
+		return false
+	}
+
+	info.BackfillingLiveCollection = true
+	return true
+}
+
+// nextBackfill takes the checkpoint of the queued backfill. The second return value is false once there is nothing left
+// to backfill, at which point the running backfill is marked as finished.
+func (info *MeasurementCollectionInfo) nextBackfill() (time.Time, bool) {
+	info.Lock.Lock()
+	defer info.Lock.Unlock()
+
+	checkpoint := info.pendingBackfill
+	info.pendingBackfill = time.Time{}
+	info.BackfillingLiveCollection = !checkpoint.IsZero()
+	return checkpoint, info.BackfillingLiveCollection
+}
+
 func (info *MeasurementCollectionInfo) SetCollectingHistory(value bool) {
 	info.Lock.Lock()
 	info.CollectingHistory = value
 	info.Lock.Unlock()
 }
 
+// takeStopRequest checks if live collection has been asked to stop and clears the request
+func (info *MeasurementCollectionInfo) takeStopRequest() bool {
+	info.Lock.Lock()
+	defer info.Lock.Unlock()
+
+	stopRequested := info.RequestStopLiveCollection
+	info.RequestStopLiveCollection = false
+	return stopRequested
+}
+
 func (info *MeasurementCollectionInfo) SetPerformingLiveCollection(value bool) {
 	info.Lock.Lock()
 	info.PerformingLiveCollection = value
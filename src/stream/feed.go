@@ -0,0 +1,145 @@
+package stream
+
+import (
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"math"
+	"net/netip"
+	"time"
+)
+
+// GraphUpdate holds the current statistics of the nodes and clean edges used by a newly added result. Statistics cover
+// the statistics period up until the time the update was published.
+type GraphUpdate struct {
+	Timestamp int64        `json:"timestamp"`
+	Nodes     []NodeUpdate `json:"nodes"`
+	Edges     []EdgeUpdate `json:"edges"`
+}
+
+type NodeUpdate struct {
+	Id         string  `json:"id"`
+	AverageRtt float64 `json:"averageRtt"`
+	Usage      int64   `json:"usage"`
+	LastUsed   int64   `json:"lastUsed"`
+	LoopCount  int64   `json:"loopCount"`
+}
+
+type EdgeUpdate struct {
+	Start                string  `json:"start"`
+	End                  string  `json:"end"`
+	Usage                int64   `json:"usage"`
+	OutboundCoverage     float64 `json:"outboundCoverage"`
+	TotalTrafficCoverage float64 `json:"totalTrafficCoverage"`
+	LastUsed             int64   `json:"lastUsed"`
+	LoopCount            int64   `json:"loopCount"`
+}
+
+// PublishResult sends the changes caused by a result which was just added to the traceroute data to the subscribers
+// of its route. The traceroute data lock must be held, and the work of building the update is skipped when nobody is
+// subscribed to the route.
+func (hub *Hub) PublishResult(data *traceroute.TracerouteData, msg *measurement.Result, now time.Time) {
+	destination, err := netip.ParseAddr(msg.DstName())
+	if err != nil {
+		return
+	}
+
+	if !hub.HasSubscribers(msg.PrbId(), destination) {
+		// Nobody needs the events recorded so far, so skip over them without copying the logs
+		hub.pathChangeSequence = data.Events.PathChanges.NextSequence()
+		hub.loopSequence = data.Events.Loops.NextSequence()
+		hub.anomalySequence = data.Events.Anomalies.NextSequence()
+		return
+	}
+
+	routeData, ok := data.GetRouteData(msg.PrbId(), destination)
+	if !ok {
+		return
+	}
+
+	var messages []Message
+	if update, ok := buildGraphUpdate(routeData, msg, now); ok {
+		messages = append(messages, Message{GraphUpdateEvent, update})
+	}
+
+	// Results only record events for their own route, so every new event belongs to this route
+	var pathChanges []traceroute.PathChange
+	pathChanges, hub.pathChangeSequence = data.Events.PathChanges.Since(hub.pathChangeSequence)
+	for _, change := range pathChanges {
+		messages = append(messages, Message{PathChangeEvent, change})
+	}
+
+	var loops []traceroute.LoopEvent
+	loops, hub.loopSequence = data.Events.Loops.Since(hub.loopSequence)
+	for _, loop := range loops {
+		messages = append(messages, Message{LoopEvent, loop})
+	}
+
+	var anomalies []traceroute.Anomaly
+	anomalies, hub.anomalySequence = data.Events.Anomalies.Since(hub.anomalySequence)
+	for _, anomaly := range anomalies {
+		messages = append(messages, Message{AnomalyEvent, anomaly})
+	}
+
+	hub.Publish(msg.PrbId(), destination, messages...)
+}
+
+func buildGraphUpdate(routeData *traceroute.RouteData, msg *measurement.Result, now time.Time) (update GraphUpdate, ok bool) {
+	nodeIds, edgeIds := traceroute.CleanGraphOf(msg)
+	if len(nodeIds) == 0 {
+		return
+	}
+
+	// Align statistics so the usage of each edge can be compared against the usage of its start node
+	routeData.AlignStatisticsEndTime(now)
+	window := traceroute.StatisticsWindow(now)
+	update.Timestamp = int64(msg.Timestamp())
+
+	for _, id := range nodeIds {
+		node, ok := routeData.Nodes[id]
+		if !ok {
+			continue
+		}
+
+		// Results older than the statistics period do not leave anything to report
+		averageRtt := node.GetAverageRttWithin(window)
+		if math.IsNaN(averageRtt) {
+			continue
+		}
+
+		update.Nodes = append(update.Nodes, NodeUpdate{
+			Id:         id.Ip.String(),
+			AverageRtt: averageRtt,
+			Usage:      node.GetNumUsagesWithin(window),
+			LastUsed:   node.GetLastUsed().Unix(),
+			LoopCount:  node.GetLoopUsagesWithin(window),
+		})
+	}
+
+	totalUsage := routeData.GetTotalUsagesWithin(window)
+	for _, id := range edgeIds {
+		edge, ok := routeData.CleanEdges[id]
+		if !ok {
+			continue
+		}
+
+		usage := edge.GetUsageWithin(window)
+		if usage == 0 {
+			continue
+		}
+
+		outboundUsage := routeData.Nodes[id.Start].GetCleanOutboundUsagesWithin(window)
+
+		update.Edges = append(update.Edges, EdgeUpdate{
+			Start:                id.Start.Ip.String(),
+			End:                  id.Stop.Ip.String(),
+			Usage:                usage,
+			OutboundCoverage:     util.FiniteOrZero(float64(usage) / float64(outboundUsage)),
+			TotalTrafficCoverage: util.FiniteOrZero(edge.GetNetUsageWithin(window) / float64(totalUsage)),
+			LastUsed:             edge.GetLastUsed().Unix(),
+			LoopCount:            edge.GetLoopUsagesWithin(window),
+		})
+	}
+
+	return update, len(update.Nodes) > 0
+}
@@ -0,0 +1,130 @@
+package stream
+
+import (
+	"net/netip"
+	"sync"
+)
+
+// Message is a single update sent to the subscribers of a route. Event names the type of the update and Data holds
+// one of GraphUpdate, traceroute.PathChange, traceroute.LoopEvent or traceroute.Anomaly.
+type Message struct {
+	Event string
+	Data  any
+}
+
+const (
+	GraphUpdateEvent = "update"
+	PathChangeEvent  = "pathChange"
+	LoopEvent        = "loop"
+	AnomalyEvent     = "anomaly"
+)
+
+type routeKey struct {
+	probeId     int
+	destination netip.Addr
+}
+
+// Subscription receives the messages published for a single route
+type Subscription struct {
+	key      routeKey
+	messages chan Message
+}
+
+// Messages is closed when the subscription is removed from its hub. This happens either when unsubscribing or when the
+// subscriber fell too far behind to keep up with the messages for its route.
+func (subscription *Subscription) Messages() <-chan Message {
+	return subscription.messages
+}
+
+// Hub distributes messages to the subscribers of each route. It is safe to use from multiple goroutines.
+type Hub struct {
+	subscribers map[routeKey]map[*Subscription]struct{}
+	bufferSize  int
+	lock        sync.Mutex
+
+	// Sequence numbers of the next events to read from the event logs. These are only used by PublishResult, which
+	// requires the traceroute data lock.
+	pathChangeSequence uint64
+	loopSequence       uint64
+	anomalySequence    uint64
+}
+
+// MakeHub creates a hub where each subscriber can have up to bufferSize messages waiting to be received
+func MakeHub(bufferSize int) *Hub {
+	return &Hub{
+		subscribers: make(map[routeKey]map[*Subscription]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+func (hub *Hub) Subscribe(probeId int, destination netip.Addr) *Subscription {
+	subscription := &Subscription{
+		key:      routeKey{probeId, destination},
+		messages: make(chan Message, hub.bufferSize),
+	}
+
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	subscribers, ok := hub.subscribers[subscription.key]
+	if !ok {
+		subscribers = make(map[*Subscription]struct{})
+		hub.subscribers[subscription.key] = subscribers
+	}
+
+	subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// Unsubscribe stops sending messages to a subscription. It does nothing if the subscription was already removed.
+func (hub *Hub) Unsubscribe(subscription *Subscription) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	hub.removeLocked(subscription)
+}
+
+func (hub *Hub) removeLocked(subscription *Subscription) {
+	subscribers := hub.subscribers[subscription.key]
+	if _, ok := subscribers[subscription]; !ok {
+		return
+	}
+
+	delete(subscribers, subscription)
+	if len(subscribers) == 0 {
+		delete(hub.subscribers, subscription.key)
+	}
+
+	close(subscription.messages)
+}
+
+// HasSubscribers checks if any subscription is open for a route
+func (hub *Hub) HasSubscribers(probeId int, destination netip.Addr) bool {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	return len(hub.subscribers[routeKey{probeId, destination}]) > 0
+}
+
+// Publish sends messages to every subscriber of a route without blocking. Subscribers which do not have room for all
+// the messages are removed, since skipping messages would leave them with an inconsistent view of the route.
+func (hub *Hub) Publish(probeId int, destination netip.Addr, messages ...Message) {
+	if len(messages) == 0 {
+		return
+	}
+
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	for subscription := range hub.subscribers[routeKey{probeId, destination}] {
+		if cap(subscription.messages)-len(subscription.messages) < len(messages) {
+			hub.removeLocked(subscription)
+			continue
+		}
+
+		// Only Publish sends to the channel and the hub lock is held, so there is guaranteed to be room
+		for _, message := range messages {
+			subscription.messages <- message
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package stream
+
+import (
+	"encoding/json"
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/traceroute"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+const testDestination = "192.0.2.1"
+
+// buildResult creates a result from the given probe which crosses the given hops before reaching the destination. A
+// hop of "*" is a timeout.
+func buildResult(t *testing.T, probeId int, timestamp time.Time, hops ...string) *measurement.Result {
+	var results []map[string]any
+	for index, hop := range append(hops, testDestination) {
+		reply := map[string]any{"x": "*"}
+		if hop != "*" {
+			reply = map[string]any{"from": hop, "rtt": float64(index + 1), "ttl": 250, "size": 28}
+		}
+
+		results = append(results, map[string]any{"hop": index + 1, "result": []map[string]any{reply}})
+	}
+
+	encoded, err := json.Marshal(map[string]any{
+		"type":      "traceroute",
+		"af":        4,
+		"msm_id":    1,
+		"prb_id":    probeId,
+		"timestamp": timestamp.Unix(),
+		"paris_id":  1,
+		"src_addr":  "10.0.0.1",
+		"dst_addr":  testDestination,
+		"dst_name":  testDestination,
+		"result":    results,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed measurement.Result
+	if err = json.Unmarshal(encoded, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	return &parsed
+}
+
+// receive collects the messages waiting on a subscription
+func receive(subscription *Subscription) (messages []Message) {
+	for {
+		select {
+		case message, ok := <-subscription.Messages():
+			if !ok {
+				return
+			}
+
+			messages = append(messages, message)
+		default:
+			return
+		}
+	}
+}
+
+func TestHubRoutesMessages(t *testing.T) {
+	hub := MakeHub(4)
+	destination := netip.MustParseAddr(testDestination)
+
+	first := hub.Subscribe(100, destination)
+	second := hub.Subscribe(100, destination)
+	other := hub.Subscribe(200, destination)
+
+	hub.Publish(100, destination, Message{Event: GraphUpdateEvent})
+	if len(receive(first)) != 1 || len(receive(second)) != 1 {
+		t.Error("Expected both subscribers of the route to receive the message")
+	}
+
+	if len(receive(other)) != 0 {
+		t.Error("Expected messages to only be sent to subscribers of the route")
+	}
+
+	hub.Unsubscribe(first)
+	hub.Unsubscribe(first)
+	if _, ok := <-first.Messages(); ok {
+		t.Error("Expected unsubscribing to close the subscription")
+	}
+
+	if !hub.HasSubscribers(100, destination) {
+		t.Error("Expected the route to keep its remaining subscriber")
+	}
+
+	hub.Unsubscribe(second)
+	if hub.HasSubscribers(100, destination) {
+		t.Error("Expected the route to have no subscribers")
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	hub := MakeHub(2)
+	destination := netip.MustParseAddr(testDestination)
+
+	slow := hub.Subscribe(100, destination)
+	fast := hub.Subscribe(100, destination)
+
+	hub.Publish(100, destination, Message{Event: GraphUpdateEvent})
+	receive(fast)
+
+	// The slow subscriber only has room for one more message, so it is removed rather than skipping the second one
+	hub.Publish(100, destination, Message{Event: GraphUpdateEvent}, Message{Event: PathChangeEvent})
+
+	if messages := receive(slow); len(messages) != 1 {
+		t.Errorf("Expected the slow subscriber to only receive the messages before it fell behind, got %d", len(messages))
+	}
+
+	if _, ok := <-slow.Messages(); ok {
+		t.Error("Expected the slow subscriber to be closed")
+	}
+
+	if messages := receive(fast); len(messages) != 2 {
+		t.Errorf("Expected the other subscriber to receive every message, got %d", len(messages))
+	}
+}
+
+func TestPublishResult(t *testing.T) {
+	data := traceroute.MakeTracerouteData()
+	hub := MakeHub(16)
+	destination := netip.MustParseAddr(testDestination)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// Results added without subscribers should not be sent to later subscribers
+	for index := 0; index < 3; index++ {
+		result := buildResult(t, 100, start.Add(time.Duration(index)*time.Minute), "10.1.0.1")
+		data.AppendMeasurement(result)
+		hub.PublishResult(&data, result, start.Add(time.Hour))
+	}
+
+	subscription := hub.Subscribe(100, destination)
+	defer hub.Unsubscribe(subscription)
+
+	// Switch to a new path with a timeout in the middle until it becomes the dominant path
+	var messages []Message
+	for index := 3; index < 6; index++ {
+		result := buildResult(t, 100, start.Add(time.Duration(index)*time.Minute), "10.2.0.1", "*")
+		data.AppendMeasurement(result)
+		hub.PublishResult(&data, result, start.Add(time.Hour))
+		messages = append(messages, receive(subscription)...)
+	}
+
+	var updates []GraphUpdate
+	var pathChanges []traceroute.PathChange
+	for _, message := range messages {
+		switch message.Event {
+		case GraphUpdateEvent:
+			updates = append(updates, message.Data.(GraphUpdate))
+		case PathChangeEvent:
+			pathChanges = append(pathChanges, message.Data.(traceroute.PathChange))
+		}
+	}
+
+	if len(updates) != 3 {
+		t.Fatalf("Expected an update for each result, got %d", len(updates))
+	}
+
+	if len(pathChanges) != 1 {
+		t.Fatalf("Expected a single path change, got %d", len(pathChanges))
+	}
+
+	last := updates[len(updates)-1]
+	if last.Timestamp != start.Add(5*time.Minute).Unix() {
+		t.Errorf("Expected the update to have the timestamp of its result, got %d", last.Timestamp)
+	}
+
+	// The update only covers the parts of the graph used by the result
+	nodes := make(map[string]NodeUpdate)
+	for _, node := range last.Nodes {
+		nodes[node.Id] = node
+	}
+
+	if len(nodes) != 3 {
+		t.Errorf("Expected the probe, hop and destination nodes, got %+v", last.Nodes)
+	}
+
+	if node, ok := nodes["10.2.0.1"]; !ok || node.Usage != 3 {
+		t.Errorf("Expected the new hop to have been used by 3 results, got %+v", node)
+	}
+
+	// The timeout is skipped over by the clean edge, and the edge from the probe is shared with the old path
+	edges := make(map[[2]string]EdgeUpdate)
+	for _, edge := range last.Edges {
+		edges[[2]string{edge.Start, edge.End}] = edge
+	}
+
+	if len(edges) != 2 {
+		t.Errorf("Expected 2 edges, got %+v", last.Edges)
+	}
+
+	if edge, ok := edges[[2]string{"10.0.0.1", "10.2.0.1"}]; !ok || edge.OutboundCoverage != 0.5 {
+		t.Errorf("Expected the probe to use the new hop for half of its results, got %+v", edge)
+	}
+
+	if _, ok := edges[[2]string{"10.2.0.1", testDestination}]; !ok {
+		t.Error("Expected an edge from the new hop to the destination")
+	}
+
+	// Results for other routes are not sent to the subscription
+	result := buildResult(t, 200, start.Add(6*time.Minute), "10.3.0.1")
+	data.AppendMeasurement(result)
+	hub.PublishResult(&data, result, start.Add(time.Hour))
+	if messages := receive(subscription); len(messages) != 0 {
+		t.Errorf("Expected no messages for another route, got %+v", messages)
+	}
+}
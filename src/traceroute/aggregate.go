@@ -0,0 +1,213 @@
+package traceroute
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// AggregateNodeId identifies a node of an aggregate graph. Probes behind NAT often share the same private source
+// address, so the node each probe starts from is identified by the probe id instead of its address.
+type AggregateNodeId struct {
+	Ip netip.Addr
+	// ProbeId is only set for the node a probe starts from
+	ProbeId int
+}
+
+// IsProbe checks if this is the node a probe starts from
+func (id AggregateNodeId) IsProbe() bool {
+	return id.ProbeId != 0
+}
+
+func (id AggregateNodeId) String() string {
+	if id.IsProbe() {
+		return fmt.Sprintf("probe:%d", id.ProbeId)
+	}
+
+	return id.Ip.String()
+}
+
+// Less orders the nodes of probes before all other nodes
+func (id AggregateNodeId) Less(other AggregateNodeId) bool {
+	if id.IsProbe() != other.IsProbe() {
+		return id.IsProbe()
+	}
+
+	if id.ProbeId != other.ProbeId {
+		return id.ProbeId < other.ProbeId
+	}
+
+	return id.Ip.Less(other.Ip)
+}
+
+// AggregateNode is an address seen in the clean graphs of one or more routes, or the node a probe starts from
+type AggregateNode struct {
+	// Addresses are the source addresses of the probe for the node a probe starts from
+	Addresses []netip.Addr
+	Probes    map[int]struct{}
+	Usage     int64
+	LastUsed  time.Time
+	// rttSum holds the average RTT of each route weighted by its usage of the node
+	rttSum   float64
+	rttUsage int64
+}
+
+// GetAverageRtt gets the average RTT of the node weighted by the usage of each route
+func (node *AggregateNode) GetAverageRtt() float64 {
+	average, _ := finiteAverage(node.rttSum / float64(node.rttUsage))
+	return average
+}
+
+// AggregateEdge is a link between two nodes seen in the clean graphs of one or more routes
+type AggregateEdge struct {
+	Start, End AggregateNodeId
+	Probes     map[int]struct{}
+	Usage      int64
+	NetUsage   float64
+	LastUsed   time.Time
+	// OutboundCoverage is the fraction of the traffic leaving the start node which used this edge
+	OutboundCoverage float64
+	// siblings is the number of edges leaving the start node
+	siblings int
+}
+
+// AggregateGraph merges the clean graphs of multiple routes, normally every probe targeting a single destination
+type AggregateGraph struct {
+	Nodes map[AggregateNodeId]*AggregateNode
+	Edges map[[2]AggregateNodeId]*AggregateEdge
+	// Probes holds the id of every probe with a route contributing to the graph
+	Probes map[int]struct{}
+	// TotalUsage is the number of results from all routes
+	TotalUsage int64
+}
+
+// AggregateRoutes merges the clean graphs of the given routes within the window. Timeouts are skipped since the same
+// number of timeouts from different routes does not refer to the same router.
+func AggregateRoutes(routes []*RouteData, window TimeRange) *AggregateGraph {
+	graph := &AggregateGraph{
+		Nodes:  make(map[AggregateNodeId]*AggregateNode),
+		Edges:  make(map[[2]AggregateNodeId]*AggregateEdge),
+		Probes: make(map[int]struct{}),
+	}
+
+	for _, routeData := range routes {
+		routeUsage := routeData.GetTotalUsagesWithin(window)
+		if routeUsage == 0 {
+			continue
+		}
+
+		graph.TotalUsage += routeUsage
+		probeId := routeData.GetProbeId()
+		graph.Probes[probeId] = struct{}{}
+
+		probeIps := make(map[netip.Addr]struct{})
+		for _, ip := range routeData.GetProbeIps() {
+			probeIps[ip] = struct{}{}
+		}
+
+		// Every source address of the probe is merged into a single node which is not shared with other probes
+		aggregateId := func(id NodeId) AggregateNodeId {
+			if _, ok := probeIps[id.Ip]; ok {
+				return AggregateNodeId{ProbeId: probeId}
+			}
+
+			return AggregateNodeId{Ip: id.Ip}
+		}
+
+		for id, node := range routeData.Nodes {
+			usage := node.GetNumUsagesWithin(window)
+			if id.IsTimeout() || usage == 0 {
+				continue
+			}
+
+			key := aggregateId(id)
+			aggregate, ok := graph.Nodes[key]
+			if !ok {
+				aggregate = &AggregateNode{Probes: make(map[int]struct{})}
+				graph.Nodes[key] = aggregate
+			}
+
+			if key.IsProbe() {
+				aggregate.Addresses = append(aggregate.Addresses, id.Ip)
+			}
+
+			aggregate.Probes[probeId] = struct{}{}
+			aggregate.Usage += usage
+			if rtt, ok := finiteAverage(node.GetAverageRttWithin(window)); ok {
+				aggregate.rttSum += rtt * float64(usage)
+				aggregate.rttUsage += usage
+			}
+
+			if node.lastUsed.After(aggregate.LastUsed) {
+				aggregate.LastUsed = node.lastUsed
+			}
+		}
+
+		for endpoints, edge := range routeData.CleanEdges {
+			usage := edge.GetUsageWithin(window)
+			if usage == 0 {
+				continue
+			}
+
+			key := [2]AggregateNodeId{aggregateId(endpoints.Start), aggregateId(endpoints.Stop)}
+			aggregate, ok := graph.Edges[key]
+			if !ok {
+				aggregate = &AggregateEdge{Start: key[0], End: key[1], Probes: make(map[int]struct{})}
+				graph.Edges[key] = aggregate
+			}
+
+			aggregate.Probes[probeId] = struct{}{}
+			aggregate.Usage += usage
+			aggregate.NetUsage += edge.GetNetUsageWithin(window)
+			if edge.lastUsed.After(aggregate.LastUsed) {
+				aggregate.LastUsed = edge.lastUsed
+			}
+		}
+	}
+
+	for _, node := range graph.Nodes {
+		sort.Slice(node.Addresses, func(i, j int) bool {
+			return node.Addresses[i].Less(node.Addresses[j])
+		})
+	}
+
+	graph.computeOutboundCoverage()
+	return graph
+}
+
+func (graph *AggregateGraph) computeOutboundCoverage() {
+	outboundUsage := make(map[AggregateNodeId]int64)
+	outboundCount := make(map[AggregateNodeId]int)
+	for key, edge := range graph.Edges {
+		outboundUsage[key[0]] += edge.Usage
+		outboundCount[key[0]] += 1
+	}
+
+	for key, edge := range graph.Edges {
+		edge.OutboundCoverage = float64(edge.Usage) / float64(outboundUsage[key[0]])
+		edge.siblings = outboundCount[key[0]]
+	}
+}
+
+// PrunedEdges gets the edges which carry at least minWeight of the traffic leaving their start node, divided evenly
+// between the outbound edges of the node the same way as MIN_CLEAN_EDGE_WEIGHT. Edges are sorted by their endpoints.
+func (graph *AggregateGraph) PrunedEdges(minWeight float64) (edges []*AggregateEdge) {
+	for _, edge := range graph.Edges {
+		if edge.OutboundCoverage < minWeight/float64(edge.siblings) {
+			continue
+		}
+
+		edges = append(edges, edge)
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Start != edges[j].Start {
+			return edges[i].Start.Less(edges[j].Start)
+		}
+
+		return edges[i].End.Less(edges[j].End)
+	})
+
+	return
+}
@@ -0,0 +1,78 @@
+package traceroute
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAggregateRoutes(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	appendPath := func(probeId int, middle string, rtt float64) {
+		result := testResult{msmId: 1, probeId: probeId, timestamp: timestamp, parisId: 1}
+		result.hops = [][]testReply{
+			replies(1.0, "10.0.1.1"),
+			replies(rtt, middle),
+			replies(20.0, testDestination),
+		}
+
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	for i := 0; i < 3; i++ {
+		appendPath(100, "10.0.2.1", 5.0)
+	}
+	appendPath(101, "10.0.2.1", 9.0)
+	appendPath(102, "10.0.3.1", 7.0)
+
+	graph := AggregateRoutes(tracerouteData.Routes(), StatisticsWindow(time.Unix(int64(timestamp), 0)))
+	if graph.TotalUsage != 5 {
+		t.Errorf("Expected 5 results to be aggregated, but got %d", graph.TotalUsage)
+	}
+
+	if len(graph.Probes) != 3 {
+		t.Errorf("Expected 3 probes to be aggregated, but got %d", len(graph.Probes))
+	}
+
+	// Every probe reports the same source address, as if behind NAT, but each one still starts from its own node
+	for _, probeId := range []int{100, 101, 102} {
+		origin := graph.Nodes[AggregateNodeId{ProbeId: probeId}]
+		if origin == nil || len(origin.Probes) != 1 || len(origin.Addresses) != 1 {
+			t.Errorf("Expected a node for the source of probe %d, but got %+v", probeId, origin)
+		}
+	}
+
+	if node := graph.Nodes[AggregateNodeId{Ip: netip.MustParseAddr("10.0.0.1")}]; node != nil {
+		t.Errorf("Expected probe source addresses to not be merged into a shared node, but got %+v", node)
+	}
+
+	shared := graph.Nodes[AggregateNodeId{Ip: netip.MustParseAddr("10.0.2.1")}]
+	if shared == nil || len(shared.Probes) != 2 || shared.Usage != 4 {
+		t.Fatalf("Expected 10.0.2.1 to be used 4 times by 2 probes, but got %+v", shared)
+	}
+
+	// The RTT of each route is weighted by how often it used the node
+	if rtt := shared.GetAverageRtt(); math.Abs(rtt-6.0) > 1e-9 {
+		t.Errorf("Expected average RTT of 6, but got %f", rtt)
+	}
+
+	key := [2]AggregateNodeId{{Ip: netip.MustParseAddr("10.0.1.1")}, {Ip: netip.MustParseAddr("10.0.2.1")}}
+	if edge := graph.Edges[key]; edge == nil || math.Abs(edge.OutboundCoverage-0.8) > 1e-9 {
+		t.Errorf("Expected edge to carry 80%% of outbound traffic, but got %+v", edge)
+	}
+
+	// The minor branch carries 20% of the traffic, which is below the 25% needed with a weight of 0.5 and 2 branches
+	for _, edge := range graph.PrunedEdges(0.5) {
+		if edge.End.Ip == netip.MustParseAddr("10.0.3.1") {
+			t.Errorf("Expected edge to 10.0.3.1 to be pruned")
+		}
+	}
+
+	if edges := graph.PrunedEdges(0.0); len(edges) != len(graph.Edges) {
+		t.Errorf("Expected no edges to be pruned without a minimum weight, but got %d of %d", len(edges), len(graph.Edges))
+	}
+}
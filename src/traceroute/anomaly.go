@@ -0,0 +1,247 @@
+package traceroute
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"math"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// iqrToStdDev converts an interquartile range to the standard deviation of a normal distribution with the same spread
+const iqrToStdDev = 1 / 1.349
+
+type AnomalySeverity string
+
+const (
+	AnomalyWarning  AnomalySeverity = "warning"
+	AnomalyCritical AnomalySeverity = "critical"
+)
+
+// rank orders severities so escalations can be detected. Results which are not anomalous have a rank of 0.
+func (severity AnomalySeverity) rank() int {
+	switch severity {
+	case AnomalyWarning:
+		return 1
+	case AnomalyCritical:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// IsAtLeast checks if this severity is the same as or worse than the minimum
+func (severity AnomalySeverity) IsAtLeast(minimum AnomalySeverity) bool {
+	return severity.rank() >= minimum.rank()
+}
+
+// IsValid checks if the severity is one of the known severities
+func (severity AnomalySeverity) IsValid() bool {
+	return severity.rank() > 0
+}
+
+// severityFor finds the severity of a robust z-score, or an empty severity if it is not anomalous
+func severityFor(score float64) AnomalySeverity {
+	switch {
+	case score >= config.AnomalyCriticalThreshold.GetFloat():
+		return AnomalyCritical
+	case score >= config.AnomalyThreshold.GetFloat():
+		return AnomalyWarning
+	default:
+		return ""
+	}
+}
+
+// Anomaly records when the RTT of a hop rose far above its baseline. Anomalies of the destination itself cover the route
+// as a whole.
+type Anomaly struct {
+	Timestamp   time.Time
+	ProbeId     int
+	Destination netip.Addr
+	Hop         netip.Addr
+	// Rtt is the fastest reply from the hop in the anomalous result
+	Rtt float64
+	// BaselineRtt is the median of the fastest replies over the statistics period
+	BaselineRtt float64
+	Score       float64
+	Severity    AnomalySeverity
+}
+
+func (anomaly Anomaly) IsDestination() bool {
+	return anomaly.Hop == anomaly.Destination
+}
+
+// rttSamples holds the fastest reply from a node in each of its most recent results. Baselines are taken from the exact
+// samples since the buckets of a moving distribution are far wider than the differences which matter on stable hops.
+// Samples are stored as 32 bit values with timestamps in Unix seconds, so each one takes 8 bytes.
+type rttSamples struct {
+	rtts       []float32
+	timestamps []uint32
+	// Index of the oldest sample once the samples are full
+	next     int
+	capacity int
+}
+
+func makeRttSamples(capacity int) rttSamples {
+	return rttSamples{capacity: capacity}
+}
+
+func (samples *rttSamples) append(rtt float64, timestamp time.Time) {
+	if len(samples.rtts) < samples.capacity {
+		samples.rtts = append(samples.rtts, float32(rtt))
+		samples.timestamps = append(samples.timestamps, uint32(timestamp.Unix()))
+	} else if len(samples.rtts) > 0 {
+		samples.rtts[samples.next] = float32(rtt)
+		samples.timestamps[samples.next] = uint32(timestamp.Unix())
+		samples.next = (samples.next + 1) % len(samples.rtts)
+	}
+}
+
+// sortedWithin gets the samples within the window in increasing order
+func (samples *rttSamples) sortedWithin(window TimeRange) (rtts []float64) {
+	start, end := window.Start.Unix(), window.End.Unix()
+	for index, timestamp := range samples.timestamps {
+		if int64(timestamp) >= start && int64(timestamp) <= end {
+			rtts = append(rtts, float64(samples.rtts[index]))
+		}
+	}
+
+	sort.Float64s(rtts)
+	return
+}
+
+// evictBefore removes samples older than the oldest allowed timestamp. The remaining samples are kept in the order they
+// were received so the oldest of them is still replaced first once the samples are full again.
+func (samples *rttSamples) evictBefore(oldestAllowed time.Time) {
+	cutoff := oldestAllowed.Unix()
+
+	kept := 0
+	for _, timestamp := range samples.timestamps {
+		if int64(timestamp) >= cutoff {
+			kept += 1
+		}
+	}
+
+	if kept == len(samples.timestamps) {
+		return
+	}
+
+	rtts := make([]float32, 0, kept)
+	timestamps := make([]uint32, 0, kept)
+	for offset := range samples.timestamps {
+		index := (samples.next + offset) % len(samples.timestamps)
+		if int64(samples.timestamps[index]) >= cutoff {
+			rtts = append(rtts, samples.rtts[index])
+			timestamps = append(timestamps, samples.timestamps[index])
+		}
+	}
+
+	samples.rtts, samples.timestamps, samples.next = rtts, timestamps, 0
+}
+
+// sortedQuantile interpolates between the closest samples to find a quantile of sorted values
+func sortedQuantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
+
+// rttBaseline summarizes the usual RTT of a node. It is only recomputed every ANOMALY_BASELINE_REFRESH since sorting the
+// samples for every result would be expensive.
+type rttBaseline struct {
+	median float64
+	// scale is a robust estimate of the standard deviation taken from the interquartile range
+	scale    float64
+	samples  int64
+	computed time.Time
+}
+
+func (baseline rttBaseline) isValid() bool {
+	return baseline.samples >= int64(config.AnomalyMinSamples.GetInt())
+}
+
+func (node *Node) refreshBaseline(timestamp time.Time) rttBaseline {
+	if !node.baseline.computed.IsZero() && timestamp.Sub(node.baseline.computed) < config.AnomalyBaselineRefresh.GetDuration() {
+		return node.baseline
+	}
+
+	rtts := node.fastestRtt.sortedWithin(StatisticsWindow(timestamp))
+
+	node.baseline = rttBaseline{
+		median:   sortedQuantile(rtts, 0.5),
+		scale:    (sortedQuantile(rtts, 0.75) - sortedQuantile(rtts, 0.25)) * iqrToStdDev,
+		samples:  int64(len(rtts)),
+		computed: timestamp,
+	}
+
+	// Very stable hops would otherwise flag differences far below what is noticeable
+	node.baseline.scale = math.Max(node.baseline.scale, config.AnomalyMinDeviation.GetFloat())
+	return node.baseline
+}
+
+// detectAnomalies compares the fastest reply from each hop against its baseline before adding it to the baseline. Only
+// increases are treated as anomalies. Anomalies are recorded when a hop first becomes anomalous or its severity
+// escalates, so an ongoing anomaly does not record an event for every result.
+func (routeData *RouteData) detectAnomalies(responses []hopResponses, timestamp time.Time) {
+	// Results received out of order are added to the baseline, but are not evaluated since the anomaly state of each
+	// node follows the latest result
+	inOrder := !timestamp.Before(routeData.latestAnomalyCheck)
+	if inOrder {
+		routeData.latestAnomalyCheck = timestamp
+	}
+
+	// An address may appear at multiple hops when there is a loop, so only its fastest reply is used
+	fastest := make(map[netip.Addr]float64)
+	for _, hop := range responses {
+		for addr, rtt := range hop.fastest {
+			if previous, ok := fastest[addr]; !ok || rtt < previous {
+				fastest[addr] = rtt
+			}
+		}
+	}
+
+	for addr, rtt := range fastest {
+		node := routeData.getOrCreateNode(WrapAddr(addr))
+		if inOrder {
+			routeData.evaluateRtt(addr, node, rtt, timestamp)
+		}
+
+		node.fastestRtt.append(rtt, timestamp)
+	}
+}
+
+func (routeData *RouteData) evaluateRtt(addr netip.Addr, node *Node, rtt float64, timestamp time.Time) {
+	baseline := node.refreshBaseline(timestamp)
+	if !baseline.isValid() {
+		return
+	}
+
+	score := (rtt - baseline.median) / baseline.scale
+	severity := severityFor(score)
+
+	if severity.rank() > node.anomalySeverity.rank() && routeData.events != nil {
+		routeData.events.Anomalies.Append(Anomaly{
+			Timestamp:   timestamp,
+			ProbeId:     routeData.probeId,
+			Destination: routeData.destination,
+			Hop:         addr,
+			Rtt:         rtt,
+			BaselineRtt: baseline.median,
+			Score:       score,
+			Severity:    severity,
+		})
+	}
+
+	node.anomalySeverity = severity
+}
+
+// GetAnomalySeverity gets the severity of the ongoing anomaly of this node, or an empty severity if the latest result
+// was not anomalous
+func (node *Node) GetAnomalySeverity() AnomalySeverity {
+	return node.anomalySeverity
+}
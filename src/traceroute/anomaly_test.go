@@ -0,0 +1,119 @@
+package traceroute
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAnomalyDetection(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	appendResult := func(destinationRtt float64) {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1, hops: [][]testReply{
+			replies(1.0, "10.0.1.1"),
+			replies(destinationRtt, testDestination),
+		}}
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	// Build up a baseline of about 10.75ms with a small amount of jitter
+	for i := 0; i < 48; i++ {
+		appendResult(10 + 0.5*float64(i%4))
+	}
+
+	if anomalies := tracerouteData.Events.Anomalies.Events(); len(anomalies) != 0 {
+		t.Fatalf("Expected no anomalies while building the baseline, but got %+v", anomalies)
+	}
+
+	appendResult(16)
+	appendResult(50)
+	// An ongoing anomaly should not be recorded again
+	appendResult(50)
+	appendResult(10)
+	appendResult(50)
+
+	anomalies := tracerouteData.Events.Anomalies.Events()
+	expected := []AnomalySeverity{AnomalyWarning, AnomalyCritical, AnomalyCritical}
+	if len(anomalies) != len(expected) {
+		t.Fatalf("Expected %d anomalies, but got %+v", len(expected), anomalies)
+	}
+
+	for index, anomaly := range anomalies {
+		if anomaly.Severity != expected[index] || !anomaly.IsDestination() || anomaly.ProbeId != 100 {
+			t.Errorf("Expected %s anomaly at the destination, but got %+v", expected[index], anomaly)
+		}
+
+		if anomaly.BaselineRtt < 10 || anomaly.BaselineRtt > 11.5 {
+			t.Errorf("Expected baseline RTT of about 10.75, but got %v", anomaly.BaselineRtt)
+		}
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	if severity := routeData.Nodes[WrapAddr(netip.MustParseAddr(testDestination))].GetAnomalySeverity(); severity != AnomalyCritical {
+		t.Errorf("Expected ongoing critical anomaly, but got %q", severity)
+	}
+}
+
+func TestAnomalyBaselineWithJitter(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	appendResult := func(destinationRtt float64) {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1, hops: [][]testReply{
+			replies(1.0, "10.0.1.1"),
+			replies(destinationRtt, testDestination),
+		}}
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	// A stable long distance route where replies jitter between 208 and 214ms. These all fall in a single bucket of a
+	// moving distribution, so the baseline needs the exact samples to see the spread.
+	jitter := []float64{0, 3.5, 1, 6, 2.5, 4, 0.5, 5, 1.5, 3}
+	for i := 0; i < 96; i++ {
+		appendResult(208 + jitter[i%len(jitter)])
+	}
+
+	if anomalies := tracerouteData.Events.Anomalies.Events(); len(anomalies) != 0 {
+		t.Fatalf("Expected no anomalies on a stable route, but got %+v", anomalies)
+	}
+
+	appendResult(240)
+
+	anomalies := tracerouteData.Events.Anomalies.Events()
+	if len(anomalies) != 1 || anomalies[0].Severity != AnomalyCritical {
+		t.Fatalf("Expected a critical anomaly, but got %+v", anomalies)
+	}
+
+	if anomalies[0].BaselineRtt < 210 || anomalies[0].BaselineRtt > 212 {
+		t.Errorf("Expected baseline RTT of about 210.75, but got %v", anomalies[0].BaselineRtt)
+	}
+}
+
+func TestRttSamplesEviction(t *testing.T) {
+	samples := makeRttSamples(4)
+	start := time.Unix(1672531200, 0)
+	for index := 0; index < 6; index++ {
+		samples.append(float64(index), start.Add(time.Duration(index)*time.Hour))
+	}
+
+	// Samples 2 to 5 are held with the oldest about to be replaced, so samples 2 and 3 are dropped
+	samples.evictBefore(start.Add(4 * time.Hour))
+	window := TimeRange{Start: start, End: start.Add(24 * time.Hour)}
+	if rtts := samples.sortedWithin(window); !reflect.DeepEqual(rtts, []float64{4, 5}) {
+		t.Fatalf("Expected samples 4 and 5 to be kept, but got %v", rtts)
+	}
+
+	// The samples fill up again before the oldest remaining sample is replaced
+	for index := 6; index < 9; index++ {
+		samples.append(float64(index), start.Add(time.Duration(index)*time.Hour))
+	}
+
+	if rtts := samples.sortedWithin(window); !reflect.DeepEqual(rtts, []float64{5, 6, 7, 8}) {
+		t.Fatalf("Expected samples 5 to 8 to be kept, but got %v", rtts)
+	}
+}
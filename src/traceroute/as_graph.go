@@ -0,0 +1,249 @@
+package traceroute
+
+import (
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AsnLookup finds the ASN an address is announced by
+type AsnLookup func(addr netip.Addr) (uint32, bool)
+
+// asSegment is a run of consecutive hops within a single AS
+type asSegment struct {
+	asn uint32
+	// exitRtt is the RTT of the last hop in the AS
+	exitRtt float64
+	// afterGap is set when unknown hops separate this AS from the previous one
+	afterGap bool
+}
+
+// collapseToAses groups consecutive hops of a path by ASN. Hops without a known ASN, such as timeouts, private addresses
+// or IXP peering LANs, are absorbed into the surrounding AS when both sides are in the same AS and are otherwise recorded
+// as a gap between the two. Unknown hops before the first or after the last known AS are dropped.
+func collapseToAses(path Path, rttOf func(netip.Addr) (float64, bool), lookup AsnLookup) (segments []asSegment) {
+	gap := false
+
+	for _, hop := range path {
+		var asn uint32
+		var ok bool
+		if hop.IsValid() {
+			asn, ok = lookup(hop)
+		}
+
+		if !ok {
+			gap = len(segments) > 0
+			continue
+		}
+
+		rtt, hasRtt := rttOf(hop)
+		if last := len(segments) - 1; last >= 0 && segments[last].asn == asn {
+			if hasRtt {
+				segments[last].exitRtt = rtt
+			}
+		} else {
+			segment := asSegment{asn: asn, afterGap: gap}
+			if hasRtt {
+				segment.exitRtt = rtt
+			} else if last >= 0 {
+				segment.exitRtt = segments[last].exitRtt
+			}
+
+			segments = append(segments, segment)
+		}
+
+		gap = false
+	}
+
+	return
+}
+
+// AsNode is a single AS within an AsGraph
+type AsNode struct {
+	Asn   uint32
+	Usage int64
+	// rttSum holds the RTT added while crossing the AS weighted by the usage of each path
+	rttSum float64
+}
+
+// GetAverageRttContribution gets the average RTT added between leaving the previous AS and leaving this one
+func (node *AsNode) GetAverageRttContribution() float64 {
+	average, _ := finiteAverage(node.rttSum / float64(node.Usage))
+	return average
+}
+
+// AsEdge is a link between two neighbouring ASes within an AsGraph
+type AsEdge struct {
+	Start, End uint32
+	Usage      int64
+	// GapUsage counts the results where unknown hops were seen between the two ASes
+	GapUsage int64
+}
+
+// AsPath is a distinct sequence of ASes along with the number of results which used it
+type AsPath struct {
+	Asns  []uint32
+	Usage int64
+}
+
+// AsGraph is the graph of a route, or the combined routes of a destination, with hops collapsed by ASN
+type AsGraph struct {
+	Nodes      map[uint32]*AsNode
+	Edges      map[[2]uint32]*AsEdge
+	Paths      map[string]*AsPath
+	TotalUsage int64
+}
+
+func asPathKey(asns []uint32) string {
+	var builder strings.Builder
+	for _, asn := range asns {
+		builder.WriteString(strconv.FormatUint(uint64(asn), 10))
+		builder.WriteByte(' ')
+	}
+
+	return builder.String()
+}
+
+// BuildAsGraph collapses the paths of the given routes within the window into an AS level graph
+func BuildAsGraph(routes []*RouteData, window TimeRange, lookup AsnLookup) *AsGraph {
+	graph := &AsGraph{
+		Nodes: make(map[uint32]*AsNode),
+		Edges: make(map[[2]uint32]*AsEdge),
+		Paths: make(map[string]*AsPath),
+	}
+
+	for _, routeData := range routes {
+		rttOf := func(addr netip.Addr) (float64, bool) {
+			node, ok := routeData.Nodes[WrapAddr(addr)]
+			if !ok {
+				return 0, false
+			}
+
+			return finiteAverage(node.GetAverageRttWithin(window))
+		}
+
+		for _, record := range routeData.Paths {
+			usage := record.GetUsageWithin(window)
+			if usage == 0 {
+				continue
+			}
+
+			segments := collapseToAses(record.Hops, rttOf, lookup)
+			if len(segments) == 0 {
+				continue
+			}
+
+			graph.addPath(segments, usage)
+		}
+	}
+
+	return graph
+}
+
+func (graph *AsGraph) addPath(segments []asSegment, usage int64) {
+	graph.TotalUsage += usage
+	asns := make([]uint32, 0, len(segments))
+	previousRtt := 0.0
+
+	for index, segment := range segments {
+		asns = append(asns, segment.asn)
+
+		node, ok := graph.Nodes[segment.asn]
+		if !ok {
+			node = &AsNode{Asn: segment.asn}
+			graph.Nodes[segment.asn] = node
+		}
+
+		node.Usage += usage
+		node.rttSum += (segment.exitRtt - previousRtt) * float64(usage)
+		previousRtt = segment.exitRtt
+
+		if index == 0 {
+			continue
+		}
+
+		key := [2]uint32{segments[index-1].asn, segment.asn}
+		edge, ok := graph.Edges[key]
+		if !ok {
+			edge = &AsEdge{Start: key[0], End: key[1]}
+			graph.Edges[key] = edge
+		}
+
+		edge.Usage += usage
+		if segment.afterGap {
+			edge.GapUsage += usage
+		}
+	}
+
+	key := asPathKey(asns)
+	path, ok := graph.Paths[key]
+	if !ok {
+		path = &AsPath{Asns: asns}
+		graph.Paths[key] = path
+	}
+
+	path.Usage += usage
+}
+
+// SortedPaths gets the AS paths from the most to the least used
+func (graph *AsGraph) SortedPaths() (paths []*AsPath) {
+	for _, path := range graph.Paths {
+		paths = append(paths, path)
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Usage != paths[j].Usage {
+			return paths[i].Usage > paths[j].Usage
+		}
+
+		return asPathKey(paths[i].Asns) < asPathKey(paths[j].Asns)
+	})
+
+	return
+}
+
+// AsComparison is the RTT each of two AS graphs spends within a single AS
+type AsComparison struct {
+	Asn uint32
+	// InFirst and InSecond are set when the AS was crossed by the first or second graph
+	InFirst, InSecond   bool
+	FirstRtt, SecondRtt float64
+}
+
+// CompareAsGraphs aligns two AS graphs by ASN, such as the IPv4 and IPv6 paths to a dual-stack destination. ASes are
+// sorted by ASN.
+func CompareAsGraphs(first, second *AsGraph) (comparisons []AsComparison) {
+	byAsn := make(map[uint32]*AsComparison)
+	get := func(asn uint32) *AsComparison {
+		comparison, ok := byAsn[asn]
+		if !ok {
+			comparison = &AsComparison{Asn: asn}
+			byAsn[asn] = comparison
+		}
+
+		return comparison
+	}
+
+	for asn, node := range first.Nodes {
+		comparison := get(asn)
+		comparison.InFirst = true
+		comparison.FirstRtt = node.GetAverageRttContribution()
+	}
+
+	for asn, node := range second.Nodes {
+		comparison := get(asn)
+		comparison.InSecond = true
+		comparison.SecondRtt = node.GetAverageRttContribution()
+	}
+
+	for _, comparison := range byAsn {
+		comparisons = append(comparisons, *comparison)
+	}
+
+	sort.Slice(comparisons, func(i, j int) bool {
+		return comparisons[i].Asn < comparisons[j].Asn
+	})
+
+	return
+}
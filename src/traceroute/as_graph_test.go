@@ -0,0 +1,117 @@
+package traceroute
+
+import (
+	"math"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildAsGraph(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	appendPath := func(hops ...[]testReply) {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1, hops: hops}
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	asns := map[string]uint32{
+		"10.1.0.1":      64500,
+		"10.1.0.2":      64500,
+		"10.2.0.1":      64501,
+		"10.3.0.1":      64502,
+		testDestination: 64502,
+	}
+
+	lookup := func(addr netip.Addr) (uint32, bool) {
+		asn, ok := asns[addr.String()]
+		return asn, ok
+	}
+
+	for i := 0; i < 3; i++ {
+		// The timeout within AS64500 is absorbed, while the unknown hop between AS64500 and AS64502 is a gap
+		appendPath(
+			replies(1.0, "192.168.0.1"),
+			replies(2.0, "10.1.0.1"),
+			replies(3.0, "*"),
+			replies(4.0, "10.1.0.2"),
+			replies(6.0, "172.16.0.1"),
+			replies(10.0, "10.3.0.1"),
+			replies(12.0, testDestination),
+		)
+	}
+
+	appendPath(
+		replies(1.0, "192.168.0.1"),
+		replies(2.0, "10.1.0.1"),
+		replies(5.0, "10.2.0.1"),
+		replies(12.0, testDestination),
+	)
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	graph := BuildAsGraph([]*RouteData{routeData}, StatisticsWindow(time.Unix(int64(timestamp), 0)), lookup)
+
+	paths := graph.SortedPaths()
+	expected := []AsPath{
+		{Asns: []uint32{64500, 64502}, Usage: 3},
+		{Asns: []uint32{64500, 64501, 64502}, Usage: 1},
+	}
+
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %d AS paths, but got %d", len(expected), len(paths))
+	}
+
+	for index, path := range paths {
+		if !reflect.DeepEqual(*path, expected[index]) {
+			t.Errorf("Expected AS path %+v, but got %+v", expected[index], *path)
+		}
+	}
+
+	if edge := graph.Edges[[2]uint32{64500, 64502}]; edge == nil || edge.Usage != 3 || edge.GapUsage != 3 {
+		t.Errorf("Expected gap edge between AS64500 and AS64502 to be used 3 times, but got %+v", edge)
+	}
+
+	if edge := graph.Edges[[2]uint32{64500, 64501}]; edge == nil || edge.GapUsage != 0 {
+		t.Errorf("Expected direct edge between AS64500 and AS64501, but got %+v", edge)
+	}
+
+	// AS64500 is left at 4ms on the main path and 2ms on the other
+	if contribution := graph.Nodes[64500].GetAverageRttContribution(); math.Abs(contribution-3.5) > 1e-9 {
+		t.Errorf("Expected AS64500 to contribute 3.5ms, but got %f", contribution)
+	}
+
+	// AS64502 is reached from 4ms on the main path and 5ms on the other
+	if contribution := graph.Nodes[64502].GetAverageRttContribution(); math.Abs(contribution-7.75) > 1e-9 {
+		t.Errorf("Expected AS64502 to contribute 7.75ms, but got %f", contribution)
+	}
+}
+
+func TestCompareAsGraphs(t *testing.T) {
+	makeGraph := func(segments ...asSegment) *AsGraph {
+		graph := &AsGraph{
+			Nodes: make(map[uint32]*AsNode),
+			Edges: make(map[[2]uint32]*AsEdge),
+			Paths: make(map[string]*AsPath),
+		}
+
+		graph.addPath(segments, 1)
+		return graph
+	}
+
+	ipv4 := makeGraph(asSegment{asn: 64500, exitRtt: 2}, asSegment{asn: 64501, exitRtt: 10}, asSegment{asn: 64502, exitRtt: 15})
+	ipv6 := makeGraph(asSegment{asn: 64500, exitRtt: 3}, asSegment{asn: 64503, exitRtt: 8}, asSegment{asn: 64502, exitRtt: 20})
+
+	expected := []AsComparison{
+		{Asn: 64500, InFirst: true, InSecond: true, FirstRtt: 2, SecondRtt: 3},
+		{Asn: 64501, InFirst: true, FirstRtt: 8},
+		{Asn: 64502, InFirst: true, InSecond: true, FirstRtt: 5, SecondRtt: 12},
+		{Asn: 64503, InSecond: true, SecondRtt: 5},
+	}
+
+	if comparisons := CompareAsGraphs(ipv4, ipv6); !reflect.DeepEqual(comparisons, expected) {
+		t.Errorf("Expected comparison %+v, but got %+v", expected, comparisons)
+	}
+}
@@ -0,0 +1,73 @@
+package traceroute
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// PenultimateHop is the last address which replied before the destination in a single result. For an anycast
+// destination, this is normally a router at the site the probe was routed to.
+type PenultimateHop struct {
+	Timestamp time.Time
+	Addr      netip.Addr
+}
+
+// findPenultimateHop finds the last hop to reply before the destination. Results which never reached the destination
+// are skipped since there is no way to tell which site they would have been routed to.
+func findPenultimateHop(path Path, destination netip.Addr) (netip.Addr, bool) {
+	for index, hop := range path {
+		if hop != destination {
+			continue
+		}
+
+		for previous := index - 1; previous >= 0; previous-- {
+			if path[previous].IsValid() {
+				return path[previous], true
+			}
+		}
+
+		break
+	}
+
+	return netip.Addr{}, false
+}
+
+// recordPenultimateHop adds the penultimate hop of a result to the history of the route. The history is kept sorted by
+// timestamp since history and live collection may deliver results out of order.
+func (routeData *RouteData) recordPenultimateHop(path Path, timestamp time.Time) {
+	addr, ok := findPenultimateHop(path, routeData.destination)
+	if !ok {
+		return
+	}
+
+	hops := routeData.penultimateHops
+	index := sort.Search(len(hops), func(i int) bool {
+		return hops[i].Timestamp.After(timestamp)
+	})
+
+	hops = append(hops, PenultimateHop{})
+	copy(hops[index+1:], hops[index:])
+	hops[index] = PenultimateHop{Timestamp: timestamp, Addr: addr}
+	routeData.penultimateHops = hops
+}
+
+func (routeData *RouteData) evictPenultimateHopsBefore(oldestAllowed time.Time) {
+	index := sort.Search(len(routeData.penultimateHops), func(i int) bool {
+		return !routeData.penultimateHops[i].Timestamp.Before(oldestAllowed)
+	})
+
+	// Copy the remaining entries so the evicted ones can be garbage collected
+	routeData.penultimateHops = append([]PenultimateHop(nil), routeData.penultimateHops[index:]...)
+}
+
+// GetPenultimateHopsWithin gets the penultimate hop of each result within the window in the order they were measured
+func (routeData *RouteData) GetPenultimateHopsWithin(window TimeRange) (hops []PenultimateHop) {
+	for _, hop := range routeData.penultimateHops {
+		if !hop.Timestamp.Before(window.Start) && !hop.Timestamp.After(window.End) {
+			hops = append(hops, hop)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,48 @@
+package traceroute
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPenultimateHopHistory(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+
+	appendPath := func(timestamp int, addresses ...string) {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1}
+		for index, address := range addresses {
+			result.hops = append(result.hops, replies(float64(index+1), address))
+		}
+
+		tracerouteData.AppendMeasurement(result.build(t))
+	}
+
+	start := 1672531200
+	appendPath(start, "10.0.1.1", "10.0.2.1", testDestination)
+	appendPath(start+1800, "10.0.1.1", "10.0.3.1", "*", testDestination)
+	// Results may arrive out of order
+	appendPath(start+900, "10.0.1.1", "10.0.2.1", testDestination)
+	// Results which never reach the destination do not show which site was used
+	appendPath(start+2700, "10.0.1.1", "10.0.4.1", "*")
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := TimeRange{Start: time.Unix(int64(start), 0), End: time.Unix(int64(start+3600), 0)}
+	hops := routeData.GetPenultimateHopsWithin(window)
+
+	expected := []string{"10.0.2.1", "10.0.2.1", "10.0.3.1"}
+	if len(hops) != len(expected) {
+		t.Fatalf("Expected %d penultimate hops, but got %+v", len(expected), hops)
+	}
+
+	for index, hop := range hops {
+		if hop.Addr.String() != expected[index] || hop.Timestamp.Unix() != int64(start+900*index) {
+			t.Errorf("Expected penultimate hop %d to be %s at %d, but got %+v", index, expected[index], start+900*index, hop)
+		}
+	}
+
+	routeData.evictPenultimateHopsBefore(time.Unix(int64(start+900), 0))
+	if hops = routeData.GetPenultimateHopsWithin(window); len(hops) != 2 {
+		t.Errorf("Expected 2 penultimate hops to remain after eviction, but got %+v", hops)
+	}
+}
@@ -0,0 +1,240 @@
+package traceroute
+
+import (
+	"sort"
+)
+
+// Diamond is a section of a route where a per-flow load balancer splits traffic across multiple branches which later
+// converge on a single node
+type Diamond struct {
+	Divergence NodeId
+	// Convergence is the first node reachable from every branch. It is left as the zero NodeId if the branches never
+	// converge before reaching the end of the route.
+	Convergence NodeId
+	Branches    []DiamondBranch
+}
+
+// DiamondBranch is a single branch of a Diamond along with the flows which were routed along it
+type DiamondBranch struct {
+	// Nodes between the divergence and convergence nodes of the diamond
+	Nodes   []NodeId
+	FlowIds []int
+}
+
+// flowSuccessors groups the successors of each node in the clean graph by the Paris flow id which used them
+func (routeData *RouteData) flowSuccessors(window TimeRange) map[NodeId]map[int]map[NodeId]struct{} {
+	successors := make(map[NodeId]map[int]map[NodeId]struct{})
+
+	for endpoints, edge := range routeData.CleanEdges {
+		if edge.GetUsageWithin(window) == 0 {
+			continue
+		}
+
+		for _, flowId := range edge.GetFlowIdsWithin(window) {
+			byFlow, ok := successors[endpoints.Start]
+			if !ok {
+				byFlow = make(map[int]map[NodeId]struct{})
+				successors[endpoints.Start] = byFlow
+			}
+
+			if _, ok = byFlow[flowId]; !ok {
+				byFlow[flowId] = make(map[NodeId]struct{})
+			}
+
+			byFlow[flowId][endpoints.Stop] = struct{}{}
+		}
+	}
+
+	return successors
+}
+
+// isPerFlowBalanced checks if the successors of a node depend on the flow id. A node which sends every flow to the same
+// set of successors is either not load balancing, or is balancing per-packet in a way Paris traceroute can not observe.
+func isPerFlowBalanced(byFlow map[int]map[NodeId]struct{}) bool {
+	var reference map[NodeId]struct{}
+	for _, nodes := range byFlow {
+		if reference == nil {
+			reference = nodes
+			continue
+		}
+
+		if len(nodes) != len(reference) {
+			return true
+		}
+
+		for id := range nodes {
+			if _, ok := reference[id]; !ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// GetLoadBalancersWithin finds the nodes in the clean graph whose successors vary by Paris flow id within the window
+func (routeData *RouteData) GetLoadBalancersWithin(window TimeRange) map[NodeId]struct{} {
+	loadBalancers := make(map[NodeId]struct{})
+	for id, byFlow := range routeData.flowSuccessors(window) {
+		if isPerFlowBalanced(byFlow) {
+			loadBalancers[id] = struct{}{}
+		}
+	}
+
+	return loadBalancers
+}
+
+// GetDiamondsWithin finds the diamond started by each load balancer in the clean graph within the window
+func (routeData *RouteData) GetDiamondsWithin(window TimeRange) (diamonds []Diamond) {
+	successorsByFlow := routeData.flowSuccessors(window)
+
+	// Build the adjacency of the clean graph so branches can be followed to their convergence point
+	adjacency := make(map[NodeId][]NodeId)
+	for start, byFlow := range successorsByFlow {
+		seen := make(map[NodeId]struct{})
+		for _, nodes := range byFlow {
+			for id := range nodes {
+				if _, ok := seen[id]; !ok {
+					seen[id] = struct{}{}
+					adjacency[start] = append(adjacency[start], id)
+				}
+			}
+		}
+	}
+
+	for divergence, byFlow := range successorsByFlow {
+		if !isPerFlowBalanced(byFlow) {
+			continue
+		}
+
+		flowsBySuccessor := make(map[NodeId][]int)
+		for flowId, nodes := range byFlow {
+			for id := range nodes {
+				flowsBySuccessor[id] = append(flowsBySuccessor[id], flowId)
+			}
+		}
+
+		diamonds = append(diamonds, buildDiamond(divergence, flowsBySuccessor, adjacency))
+	}
+
+	sort.Slice(diamonds, func(i, j int) bool {
+		return diamonds[i].Divergence.Ip.Less(diamonds[j].Divergence.Ip)
+	})
+
+	return
+}
+
+func buildDiamond(divergence NodeId, flowsBySuccessor map[NodeId][]int, adjacency map[NodeId][]NodeId) Diamond {
+	var successors []NodeId
+	for id := range flowsBySuccessor {
+		successors = append(successors, id)
+	}
+
+	sort.Slice(successors, func(i, j int) bool {
+		return successors[i].Ip.Less(successors[j].Ip)
+	})
+
+	// Search forward from every branch to find the nearest node they all reach
+	searches := make([]branchSearch, len(successors))
+	for index, successor := range successors {
+		searches[index] = searchFrom(successor, divergence, adjacency)
+	}
+
+	var convergence NodeId
+	bestDistance, found := 0, false
+	for id, distance := range searches[0].distance {
+		furthest := distance
+		reachable := true
+		for _, search := range searches[1:] {
+			other, ok := search.distance[id]
+			if !ok {
+				reachable = false
+				break
+			}
+
+			if other > furthest {
+				furthest = other
+			}
+		}
+
+		if !reachable {
+			continue
+		}
+
+		if !found || furthest < bestDistance || (furthest == bestDistance && id.Ip.Less(convergence.Ip)) {
+			convergence, bestDistance, found = id, furthest, true
+		}
+	}
+
+	diamond := Diamond{Divergence: divergence}
+	if found {
+		diamond.Convergence = convergence
+	}
+
+	for index, successor := range successors {
+		flowIds := flowsBySuccessor[successor]
+		sort.Ints(flowIds)
+
+		diamond.Branches = append(diamond.Branches, DiamondBranch{
+			Nodes:   searches[index].pathTo(convergence, found),
+			FlowIds: flowIds,
+		})
+	}
+
+	return diamond
+}
+
+// branchSearch is a breadth first search over the clean graph starting from the first node of a branch
+type branchSearch struct {
+	start    NodeId
+	distance map[NodeId]int
+	parent   map[NodeId]NodeId
+}
+
+func searchFrom(start, divergence NodeId, adjacency map[NodeId][]NodeId) branchSearch {
+	search := branchSearch{
+		start:    start,
+		distance: map[NodeId]int{start: 0},
+		parent:   make(map[NodeId]NodeId),
+	}
+
+	queue := []NodeId{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, next := range adjacency[current] {
+			// Routing loops may lead back through the divergence node, but that does not make it part of a branch
+			if _, ok := search.distance[next]; ok || next == divergence {
+				continue
+			}
+
+			search.distance[next] = search.distance[current] + 1
+			search.parent[next] = current
+			queue = append(queue, next)
+		}
+	}
+
+	return search
+}
+
+// pathTo gets the nodes on the shortest path from the start of the branch up to, but not including, the convergence
+// node. When the branches do not converge, only the first node of the branch is known.
+func (search branchSearch) pathTo(convergence NodeId, converges bool) []NodeId {
+	if !converges {
+		return []NodeId{search.start}
+	}
+
+	var path []NodeId
+	for current := convergence; current != search.start; {
+		current = search.parent[current]
+		path = append(path, current)
+	}
+
+	// The path was built backwards from the convergence node
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return path
+}
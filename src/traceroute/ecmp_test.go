@@ -0,0 +1,146 @@
+package traceroute
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiamondDetection(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	// Flows 1 and 2 are routed through 10.0.2.1 while flows 3 and 4 are routed through 10.0.3.1 and 10.0.4.1
+	for parisId := 1; parisId <= 4; parisId++ {
+		middle := [][]testReply{replies(2.0, "10.0.2.1"), replies(3.0, "10.0.5.1")}
+		if parisId > 2 {
+			middle = [][]testReply{replies(2.0, "10.0.3.1"), replies(3.0, "10.0.4.1")}
+		}
+
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: parisId}
+		result.hops = append(result.hops, replies(1.0, "10.0.1.1"))
+		result.hops = append(result.hops, middle...)
+		result.hops = append(result.hops, replies(4.0, "10.0.6.1"), replies(5.0, testDestination))
+
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := StatisticsWindow(time.Unix(int64(timestamp), 0))
+
+	loadBalancers := routeData.GetLoadBalancersWithin(window)
+	if _, ok := loadBalancers[WrapAddr(netip.MustParseAddr("10.0.1.1"))]; !ok || len(loadBalancers) != 1 {
+		t.Fatalf("Expected only 10.0.1.1 to be a load balancer, but found %v", loadBalancers)
+	}
+
+	diamonds := routeData.GetDiamondsWithin(window)
+	if len(diamonds) != 1 {
+		t.Fatalf("Expected a single diamond, but found %d", len(diamonds))
+	}
+
+	diamond := diamonds[0]
+	if diamond.Convergence != WrapAddr(netip.MustParseAddr("10.0.6.1")) {
+		t.Errorf("Expected diamond to converge at 10.0.6.1, but got %v", diamond.Convergence)
+	}
+
+	expected := []DiamondBranch{
+		{
+			Nodes:   []NodeId{WrapAddr(netip.MustParseAddr("10.0.2.1")), WrapAddr(netip.MustParseAddr("10.0.5.1"))},
+			FlowIds: []int{1, 2},
+		},
+		{
+			Nodes:   []NodeId{WrapAddr(netip.MustParseAddr("10.0.3.1")), WrapAddr(netip.MustParseAddr("10.0.4.1"))},
+			FlowIds: []int{3, 4},
+		},
+	}
+
+	if !reflect.DeepEqual(diamond.Branches, expected) {
+		t.Errorf("Expected branches %+v, but got %+v", expected, diamond.Branches)
+	}
+}
+
+func TestPerPacketBalancingIsNotADiamond(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	// Every flow sees both next hops, so the choice does not depend on the flow id
+	for parisId := 1; parisId <= 4; parisId++ {
+		result := testResult{
+			msmId:     1,
+			probeId:   100,
+			timestamp: timestamp,
+			parisId:   parisId,
+			hops: [][]testReply{
+				replies(1.0, "10.0.1.1"),
+				replies(2.0, "10.0.2.1", "10.0.3.1"),
+				replies(3.0, testDestination),
+			},
+		}
+
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := StatisticsWindow(time.Unix(int64(timestamp), 0))
+
+	if loadBalancers := routeData.GetLoadBalancersWithin(window); len(loadBalancers) != 0 {
+		t.Errorf("Expected no load balancers, but found %v", loadBalancers)
+	}
+}
+
+func TestDiamondWithinEarlierWindow(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	start := 1672531200
+	timestamp := start
+
+	appendResults := func(splitFlows bool) {
+		for parisId := 1; parisId <= 4; parisId++ {
+			middle := "10.0.2.1"
+			if splitFlows && parisId > 2 {
+				middle = "10.0.3.1"
+			}
+
+			result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: parisId, hops: [][]testReply{
+				replies(1.0, "10.0.1.1"),
+				replies(2.0, middle),
+				replies(3.0, testDestination),
+			}}
+
+			tracerouteData.AppendMeasurement(result.build(t))
+			timestamp += 900
+		}
+	}
+
+	// Flows are split between two branches at first, then every flow moves to the same branch two days later
+	appendResults(true)
+	earlier := TimeRange{Start: time.Unix(int64(start), 0), End: time.Unix(int64(timestamp), 0)}
+	timestamp += 2 * 24 * 3600
+	appendResults(false)
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+
+	edge := routeData.CleanEdges[DirectedGraphEdge{
+		Start: WrapAddr(netip.MustParseAddr("10.0.1.1")),
+		Stop:  WrapAddr(netip.MustParseAddr("10.0.2.1")),
+	}]
+	if flowIds := edge.GetFlowIdsWithin(earlier); !reflect.DeepEqual(flowIds, []int{1, 2}) {
+		t.Errorf("Expected flows 1 and 2 within the earlier window, but got %v", flowIds)
+	}
+
+	if diamonds := routeData.GetDiamondsWithin(earlier); len(diamonds) != 1 {
+		t.Errorf("Expected a diamond within the earlier window, but found %d", len(diamonds))
+	}
+
+	later := StatisticsWindow(time.Unix(int64(timestamp), 0))
+	later.Start = earlier.End.Add(24 * time.Hour)
+	if flowIds := edge.GetFlowIdsWithin(later); !reflect.DeepEqual(flowIds, []int{1, 2, 3, 4}) {
+		t.Errorf("Expected every flow within the later window, but got %v", flowIds)
+	}
+
+	if diamonds := routeData.GetDiamondsWithin(later); len(diamonds) != 0 {
+		t.Errorf("Expected no diamonds within the later window, but found %d", len(diamonds))
+	}
+}
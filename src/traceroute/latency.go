@@ -0,0 +1,33 @@
+package traceroute
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+)
+
+// rttDelta finds the RTT added between two nodes within a single result using the fastest reply from each. The probe is
+// given as index -1 and always has an RTT of 0.
+func rttDelta(responses []hopResponses, srcIndex int, src NodeId, dstIndex int, dst NodeId) (float64, bool) {
+	srcRtt := 0.0
+	if srcIndex >= 0 {
+		var ok bool
+		if srcRtt, ok = responses[srcIndex].fastest[src.Ip]; !ok {
+			return 0, false
+		}
+	}
+
+	dstRtt, ok := responses[dstIndex].fastest[dst.Ip]
+	return dstRtt - srcRtt, ok
+}
+
+// GetRttDeltaWithin gets the distribution of the RTT added across this edge in each result within the window
+func (edge *Edge) GetRttDeltaWithin(window TimeRange) util.Distribution {
+	return edge.rttDelta.DistributionWithin(window.Start, window.End)
+}
+
+// IsLatencyIncreaseWithin checks if at least 90% of the results using this edge within the window saw the RTT increase
+// by more than LATENCY_INCREASE_THRESHOLD
+func (edge *Edge) IsLatencyIncreaseWithin(window TimeRange) bool {
+	delta := edge.GetRttDeltaWithin(window)
+	return delta.Count > 0 && delta.Quantile(0.1) >= config.LatencyIncreaseThreshold.GetFloat()
+}
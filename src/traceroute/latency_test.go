@@ -0,0 +1,55 @@
+package traceroute
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestEdgeRttDelta(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	// The second hop always adds about 20ms while the first hop varies from 1ms to 10ms
+	for _, firstRtt := range []float64{1, 4, 7, 10} {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1}
+		result.hops = [][]testReply{
+			{{from: "10.0.1.1", rtt: firstRtt + 2}, {from: "10.0.1.1", rtt: firstRtt}},
+			replies(firstRtt+20, "10.0.2.1"),
+			replies(firstRtt+21, testDestination),
+		}
+
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := StatisticsWindow(time.Unix(int64(timestamp), 0))
+
+	edgeBetween := func(start, end string) *Edge {
+		return routeData.CleanEdges[DirectedGraphEdge{
+			Start: WrapAddr(netip.MustParseAddr(start)),
+			Stop:  WrapAddr(netip.MustParseAddr(end)),
+		}]
+	}
+
+	// The fastest reply is used, so the first edge varies with the first hop
+	first := edgeBetween(testSource, "10.0.1.1").GetRttDeltaWithin(window)
+	if first.Count != 4 || first.Min != 1 || first.Max != 10 {
+		t.Errorf("Expected 4 deltas from 1 to 10, but got %d from %v to %v", first.Count, first.Min, first.Max)
+	}
+
+	second := edgeBetween("10.0.1.1", "10.0.2.1")
+	if delta := second.GetRttDeltaWithin(window); math.Abs(delta.Mean()-20) > 1e-9 || delta.StdDev() > 1e-9 {
+		t.Errorf("Expected a constant delta of 20, but got mean %v with deviation %v", delta.Mean(), delta.StdDev())
+	}
+
+	if !second.IsLatencyIncreaseWithin(window) {
+		t.Error("Expected the second edge to be flagged as a latency increase")
+	}
+
+	if edgeBetween("10.0.2.1", testDestination).IsLatencyIncreaseWithin(window) {
+		t.Error("Expected the last edge to not be flagged as a latency increase")
+	}
+}
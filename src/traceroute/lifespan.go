@@ -0,0 +1,245 @@
+package traceroute
+
+import (
+	"sort"
+	"time"
+)
+
+// lifespanSegment is a run of consecutive results of a route which either all used or all did not use a node or path
+type lifespanSegment struct {
+	used        bool
+	first, last time.Time
+}
+
+// lifespanTracker records the contiguous periods during which a node or path was used by every result of a route. A
+// period starts with the first result to use it and ends with the first result which does not. Results are kept as
+// sorted segments so results received out of order, such as history collected after live results, are still counted.
+type lifespanTracker struct {
+	// segments are sorted by time and neighboring segments always differ in whether they were used
+	segments []lifespanSegment
+}
+
+// resultPosition locates a result among the sorted results of its route. Any of the other results may be the zero time
+// if there is no such result.
+type resultPosition struct {
+	timestamp time.Time
+	// Results immediately before and after the result, which are needed to split a segment when a result arrives out
+	// of order
+	previous, next time.Time
+	// Earliest and latest results of the route
+	first, last time.Time
+}
+
+// observe records whether a result used the node or path
+func (tracker *lifespanTracker) observe(used bool, position resultPosition) {
+	timestamp := position.timestamp
+	if len(tracker.segments) == 0 {
+		tracker.start(used, position)
+		return
+	}
+
+	segments := tracker.segments
+
+	// Find the first segment which ends at or after the timestamp
+	index := sort.Search(len(segments), func(i int) bool {
+		return !segments[i].last.Before(timestamp)
+	})
+
+	if index < len(segments) && !segments[index].first.After(timestamp) {
+		segment := segments[index]
+		// Results with the same timestamp can not be ordered, so only the first one is kept
+		if segment.used == used || segment.first.Equal(timestamp) || segment.last.Equal(timestamp) {
+			return
+		}
+
+		// The result falls within a segment with the opposite usage, so split the segment around it
+		split := []lifespanSegment{
+			{used: segment.used, first: segment.first, last: position.previous},
+			{used: used, first: timestamp, last: timestamp},
+			{used: segment.used, first: position.next, last: segment.last},
+		}
+
+		tracker.segments = append(segments[:index], append(split, segments[index+1:]...)...)
+		return
+	}
+
+	switch {
+	case index > 0 && segments[index-1].used == used:
+		segments[index-1].last = timestamp
+	case index < len(segments) && segments[index].used == used:
+		segments[index].first = timestamp
+	default:
+		segments = append(segments, lifespanSegment{})
+		copy(segments[index+1:], segments[index:])
+		segments[index] = lifespanSegment{used: used, first: timestamp, last: timestamp}
+		tracker.segments = segments
+	}
+}
+
+// start creates the segments of a node or path seen for the first time. It was not used by any of the other results of
+// the route, since it would have been observed by them otherwise.
+func (tracker *lifespanTracker) start(used bool, position resultPosition) {
+	if !used {
+		tracker.segments = []lifespanSegment{{used: false, first: position.first, last: position.last}}
+		return
+	}
+
+	if !position.previous.IsZero() {
+		tracker.segments = append(tracker.segments, lifespanSegment{used: false, first: position.first, last: position.previous})
+	}
+
+	tracker.segments = append(tracker.segments, lifespanSegment{used: true, first: position.timestamp, last: position.timestamp})
+
+	if !position.next.IsZero() {
+		tracker.segments = append(tracker.segments, lifespanSegment{used: false, first: position.next, last: position.last})
+	}
+}
+
+// period gets the period of use of a used segment and whether it is still ongoing
+func (tracker *lifespanTracker) period(index int) (period TimeRange, ongoing bool) {
+	segment := tracker.segments[index]
+	if index+1 < len(tracker.segments) {
+		return TimeRange{Start: segment.first, End: tracker.segments[index+1].first}, false
+	}
+
+	return TimeRange{Start: segment.first, End: segment.last}, true
+}
+
+func (tracker *lifespanTracker) evictBefore(oldestAllowed time.Time) {
+	index := 0
+	for ; index < len(tracker.segments); index++ {
+		end := tracker.segments[index].last
+		if tracker.segments[index].used {
+			period, _ := tracker.period(index)
+			end = period.End
+		}
+
+		if !end.Before(oldestAllowed) {
+			break
+		}
+	}
+
+	tracker.segments = tracker.segments[index:]
+}
+
+// Lifespan summarizes how long a node or path stayed in use before being replaced. A period which is still ongoing
+// counts up until the latest result.
+type Lifespan struct {
+	Average, Max time.Duration
+}
+
+// lifespanWithin finds the lifespan of the periods overlapping the window after clipping them to the window. An ongoing
+// period which has only been used by a single result is left out of the average since its length is not known yet. The
+// second return value is false if it was not used within the window.
+func (tracker *lifespanTracker) lifespanWithin(window TimeRange) (lifespan Lifespan, ok bool) {
+	var total time.Duration
+	var count int64
+
+	for index, segment := range tracker.segments {
+		if !segment.used {
+			continue
+		}
+
+		period, ongoing := tracker.period(index)
+		if period.End.Before(window.Start) || period.Start.After(window.End) {
+			continue
+		}
+
+		ok = true
+		if ongoing && period.Start.Equal(period.End) {
+			continue
+		}
+
+		start, end := period.Start, period.End
+		if start.Before(window.Start) {
+			start = window.Start
+		}
+
+		if end.After(window.End) {
+			end = window.End
+		}
+
+		duration := end.Sub(start)
+		total += duration
+		count += 1
+
+		if duration > lifespan.Max {
+			lifespan.Max = duration
+		}
+	}
+
+	if count > 0 {
+		lifespan.Average = total / time.Duration(count)
+	}
+
+	return
+}
+
+// updateLifespans records which nodes and which path were used by a result
+func (routeData *RouteData) updateLifespans(layers [][]NodeId, path Path, timestamp time.Time) {
+	position := routeData.insertLifespanResult(timestamp)
+
+	used := make(map[NodeId]struct{})
+	for _, layer := range layers {
+		for _, id := range layer {
+			used[id] = struct{}{}
+		}
+	}
+
+	for id, node := range routeData.Nodes {
+		_, ok := used[id]
+		node.lifespan.observe(ok, position)
+	}
+
+	key := path.key()
+	for pathKey, record := range routeData.Paths {
+		record.lifespan.observe(pathKey == key, position)
+	}
+}
+
+// insertLifespanResult adds the timestamp of a result to the sorted timestamps of the route and finds its position
+func (routeData *RouteData) insertLifespanResult(timestamp time.Time) (position resultPosition) {
+	results := routeData.lifespanResults
+	index := sort.Search(len(results), func(i int) bool {
+		return !results[i].Before(timestamp)
+	})
+
+	if index == len(results) || !results[index].Equal(timestamp) {
+		results = append(results, time.Time{})
+		copy(results[index+1:], results[index:])
+		results[index] = timestamp
+	}
+
+	position.timestamp = timestamp
+	position.first = results[0]
+	position.last = results[len(results)-1]
+
+	if index > 0 {
+		position.previous = results[index-1]
+	}
+
+	if index+1 < len(results) {
+		position.next = results[index+1]
+	}
+
+	routeData.lifespanResults = results
+	return
+}
+
+func (routeData *RouteData) evictLifespanResultsBefore(oldestAllowed time.Time) {
+	index := sort.Search(len(routeData.lifespanResults), func(i int) bool {
+		return !routeData.lifespanResults[i].Before(oldestAllowed)
+	})
+
+	routeData.lifespanResults = routeData.lifespanResults[index:]
+}
+
+// GetLifespanWithin gets how long this node stayed in use before being replaced within the window
+func (node *Node) GetLifespanWithin(window TimeRange) (Lifespan, bool) {
+	return node.lifespan.lifespanWithin(window)
+}
+
+// GetLifespanWithin gets how long this path stayed in use before being replaced within the window
+func (record *PathRecord) GetLifespanWithin(window TimeRange) (Lifespan, bool) {
+	return record.lifespan.lifespanWithin(window)
+}
@@ -0,0 +1,108 @@
+package traceroute
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestLifespans(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	appendResult := func(secondHop string) {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1, hops: [][]testReply{
+			replies(1.0, "10.0.1.1", "10.0.1.1", "10.0.1.1"),
+			replies(5.0, secondHop, secondHop, secondHop),
+			replies(10.0, testDestination, testDestination, testDestination),
+		}}
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	appendResult("10.0.2.1")
+	appendResult("10.0.2.1")
+	appendResult("10.0.3.1")
+	appendResult("10.0.2.1")
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := StatisticsWindow(time.Unix(int64(timestamp), 0))
+
+	expectLifespan := func(name string, actual Lifespan, ok bool, average, max time.Duration) {
+		if !ok || actual.Average != average || actual.Max != max {
+			t.Errorf("Expected %s to have average %v and max %v, but got %+v (present: %v)", name, average, max, actual, ok)
+		}
+	}
+
+	// The first hop is used by every result, so its only period is still ongoing
+	lifespan, ok := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.1.1"))].GetLifespanWithin(window)
+	expectLifespan("first hop", lifespan, ok, 2700*time.Second, 2700*time.Second)
+
+	// Replaced after two results, then used again by the latest result. The new period only has a single result so far,
+	// so it does not count towards the average.
+	lifespan, ok = routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.2.1"))].GetLifespanWithin(window)
+	expectLifespan("primary second hop", lifespan, ok, 1800*time.Second, 1800*time.Second)
+
+	lifespan, ok = routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.3.1"))].GetLifespanWithin(window)
+	expectLifespan("backup second hop", lifespan, ok, 900*time.Second, 900*time.Second)
+
+	if len(routeData.Paths) != 2 {
+		t.Fatalf("Expected 2 paths, but got %d", len(routeData.Paths))
+	}
+
+	for _, record := range routeData.Paths {
+		lifespan, ok = record.GetLifespanWithin(window)
+		if record.Hops[1] == netip.MustParseAddr("10.0.2.1") {
+			expectLifespan("primary path", lifespan, ok, 1800*time.Second, 1800*time.Second)
+		} else {
+			expectLifespan("backup path", lifespan, ok, 900*time.Second, 900*time.Second)
+		}
+	}
+
+	// Periods are clipped to the window
+	clipped := TimeRange{Start: time.Unix(1672531200+900, 0), End: time.Unix(1672531200+1800, 0)}
+	lifespan, ok = routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.1.1"))].GetLifespanWithin(clipped)
+	expectLifespan("clipped first hop", lifespan, ok, 900*time.Second, 900*time.Second)
+}
+
+func TestLifespansOutOfOrder(t *testing.T) {
+	start := 1672531200
+	secondHops := []string{"10.0.2.1", "10.0.2.1", "10.0.3.1", "10.0.3.1", "10.0.2.1", "10.0.2.1", "10.0.3.1"}
+
+	buildData := func(order []int) TracerouteData {
+		tracerouteData := MakeTracerouteData()
+		for _, index := range order {
+			result := testResult{msmId: 1, probeId: 100, timestamp: start + index*900, parisId: 1, hops: [][]testReply{
+				replies(1.0, "10.0.1.1"),
+				replies(5.0, secondHops[index]),
+				replies(10.0, testDestination),
+			}}
+			tracerouteData.AppendMeasurement(result.build(t))
+		}
+
+		return tracerouteData
+	}
+
+	// Live results arrive first and history fills in the gap afterwards
+	inOrder := buildData([]int{0, 1, 2, 3, 4, 5, 6})
+	outOfOrder := buildData([]int{0, 5, 6, 3, 1, 4, 2})
+
+	window := StatisticsWindow(time.Unix(int64(start+7*900), 0))
+	expectedRoute, _ := inOrder.GetRouteData(100, netip.MustParseAddr(testDestination))
+	actualRoute, _ := outOfOrder.GetRouteData(100, netip.MustParseAddr(testDestination))
+
+	for _, address := range []string{"10.0.1.1", "10.0.2.1", "10.0.3.1"} {
+		id := WrapAddr(netip.MustParseAddr(address))
+		expected, _ := expectedRoute.Nodes[id].GetLifespanWithin(window)
+		actual, ok := actualRoute.Nodes[id].GetLifespanWithin(window)
+		if !ok || actual != expected {
+			t.Errorf("Expected %s to have lifespan %+v regardless of order, but got %+v", address, expected, actual)
+		}
+	}
+
+	// 10.0.2.1 was used for two periods of 1800 seconds
+	lifespan, _ := actualRoute.Nodes[WrapAddr(netip.MustParseAddr("10.0.2.1"))].GetLifespanWithin(window)
+	if lifespan.Average != 1800*time.Second || lifespan.Max != 1800*time.Second {
+		t.Errorf("Expected 10.0.2.1 to have average and max lifespans of 30m, but got %+v", lifespan)
+	}
+}
@@ -0,0 +1,78 @@
+package traceroute
+
+import (
+	"github.com/DNS-OARC/ripeatlas/measurement/traceroute"
+	"net/netip"
+	"time"
+)
+
+// sharedAddressSpace is the range reserved for carrier-grade NAT by RFC 6598
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Link is a pair of addresses which were the only replies at consecutive hops of a result
+type Link struct {
+	From, To netip.Addr
+}
+
+// isPublicAddress checks if an address belongs to a single interface on the internet. Private, shared and link-local
+// addresses are reused by many networks, such as the LAN gateway of every probe, so they do not identify an interface.
+func isPublicAddress(addr netip.Addr) bool {
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// soleResponder gets the address which replied to a hop if every reply came from the same address
+func soleResponder(hop []*traceroute.Reply) (responder netip.Addr, ok bool) {
+	for _, reply := range hop {
+		if reply.X() == "*" {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(reply.From())
+		if err != nil || (responder.IsValid() && addr != responder) {
+			return netip.Addr{}, false
+		}
+
+		responder = addr
+	}
+
+	return responder, responder.IsValid()
+}
+
+// recordLinks remembers the links between public addresses which were the only replies at consecutive hops. Unlike
+// the clean graph, hops are not joined across timeouts or between every reply of neighboring hops, so each link is a
+// router handing the probe to the next one.
+func (routeData *RouteData) recordLinks(hops [][]*traceroute.Reply, timestamp time.Time) {
+	for index := 1; index < len(hops); index++ {
+		from, ok := soleResponder(hops[index-1])
+		if !ok || !isPublicAddress(from) {
+			continue
+		}
+
+		to, ok := soleResponder(hops[index])
+		if !ok || !isPublicAddress(to) || to == from {
+			continue
+		}
+
+		link := Link{From: from, To: to}
+		if lastSeen, ok := routeData.links[link]; !ok || lastSeen.Before(timestamp) {
+			routeData.links[link] = timestamp
+		}
+	}
+}
+
+func (routeData *RouteData) evictLinksBefore(oldestAllowed time.Time) {
+	for link, lastSeen := range routeData.links {
+		if lastSeen.Before(oldestAllowed) {
+			delete(routeData.links, link)
+		}
+	}
+}
+
+// GetLinks gets the links between public addresses seen at consecutive hops within the statistics period
+func (routeData *RouteData) GetLinks() (links []Link) {
+	for link := range routeData.links {
+		links = append(links, link)
+	}
+
+	return
+}
@@ -0,0 +1,52 @@
+package traceroute
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/alias"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestLinksOnlyJoinSingleReplyPublicHops(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	paths := map[int][][]testReply{
+		// Both probes sit behind a LAN gateway with the same private address
+		100: {replies(1.0, "192.168.1.1"), replies(5.0, "198.51.100.2"), replies(10.0, testDestination)},
+		101: {replies(1.0, "192.168.1.1"), replies(5.0, "198.51.100.9"), replies(10.0, testDestination)},
+		102: {replies(5.0, "198.51.100.1"), replies(10.0, testDestination)},
+		103: {replies(5.0, "198.51.100.10"), replies(10.0, testDestination)},
+		// Hops on either side of a timeout are not known to be neighbors
+		104: {replies(5.0, "203.0.113.1"), replies(0, "*"), replies(7.0, "203.0.113.6"), replies(10.0, testDestination)},
+		105: {replies(5.0, "203.0.113.5"), replies(10.0, testDestination)},
+		// Neither reply of a hop which reached two routers is known to have sent the probe to the next hop
+		106: {replies(5.0, "203.0.113.13", "203.0.113.17"), replies(7.0, "203.0.113.22"), replies(10.0, testDestination)},
+		107: {replies(5.0, "203.0.113.21"), replies(10.0, testDestination)},
+	}
+
+	for probeId, hops := range paths {
+		result := testResult{msmId: 1, probeId: probeId, timestamp: timestamp, parisId: 1, hops: hops}
+		tracerouteData.AppendMeasurement(result.build(t))
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	expected := []Link{{From: netip.MustParseAddr("198.51.100.2"), To: netip.MustParseAddr(testDestination)}}
+	if links := routeData.GetLinks(); !reflect.DeepEqual(links, expected) {
+		t.Errorf("Expected only the link between public addresses, but got %v", links)
+	}
+
+	evidence := alias.MakeEvidence()
+	for _, routeData := range tracerouteData.Routes() {
+		for _, link := range routeData.GetLinks() {
+			evidence.AddLink(link.From, link.To)
+		}
+	}
+
+	resolver := alias.Infer(evidence)
+	for _, addr := range []string{"198.51.100.1", "198.51.100.10", "203.0.113.1", "203.0.113.13", "203.0.113.17"} {
+		if aliases := resolver.Aliases(netip.MustParseAddr(addr)); len(aliases) > 1 {
+			t.Errorf("Expected %s to not be merged with another router, but got %v", addr, aliases)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package traceroute
+
+import (
+	"github.com/DNS-OARC/ripeatlas/measurement/traceroute"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Loop is the set of addresses which formed a cycle within a single result
+type Loop []netip.Addr
+
+func (loop Loop) key() string {
+	var builder strings.Builder
+	for _, addr := range loop {
+		builder.WriteString(addr.String())
+		builder.WriteByte(' ')
+	}
+
+	return builder.String()
+}
+
+func (loop Loop) contains(addr netip.Addr) bool {
+	for _, member := range loop {
+		if member == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (loop Loop) Strings() (output []string) {
+	for _, addr := range loop {
+		output = append(output, addr.String())
+	}
+
+	return
+}
+
+// findLoops finds groups of addresses which form cycles within the hops of a single result. An address forms a cycle if
+// it replies at more than one hop, in which case every address that replied between those hops is part of the cycle.
+// Cycles with overlapping hops are merged into a single loop.
+func findLoops(hops [][]*traceroute.Reply) (loops []Loop) {
+	type span struct {
+		first, last int
+	}
+
+	spans := make(map[netip.Addr]span)
+	for index, hop := range hops {
+		for _, reply := range hop {
+			if reply.X() == "*" {
+				continue
+			}
+
+			// We know that the address must be valid because we verified it while checking reply for errors
+			addr := netip.MustParseAddr(reply.From())
+			if existing, ok := spans[addr]; ok {
+				existing.last = index
+				spans[addr] = existing
+			} else {
+				spans[addr] = span{first: index, last: index}
+			}
+		}
+	}
+
+	var cycles []span
+	for _, addrSpan := range spans {
+		if addrSpan.last > addrSpan.first {
+			cycles = append(cycles, addrSpan)
+		}
+	}
+
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i].first < cycles[j].first
+	})
+
+	// Merge overlapping cycles
+	var merged []span
+	for _, cycle := range cycles {
+		if len(merged) > 0 && cycle.first <= merged[len(merged)-1].last {
+			if cycle.last > merged[len(merged)-1].last {
+				merged[len(merged)-1].last = cycle.last
+			}
+			continue
+		}
+
+		merged = append(merged, cycle)
+	}
+
+	for _, cycle := range merged {
+		var loop Loop
+		for addr, addrSpan := range spans {
+			if addrSpan.first <= cycle.last && addrSpan.last >= cycle.first {
+				loop = append(loop, addr)
+			}
+		}
+
+		sort.Slice(loop, func(i, j int) bool {
+			return loop[i].Less(loop[j])
+		})
+
+		loops = append(loops, loop)
+	}
+
+	return
+}
+
+// LoopRecord tracks how often a loop has been observed on a route
+type LoopRecord struct {
+	Addresses   Loop
+	occurrences util.MovingSummation
+	lastSeen    time.Time
+	// Number of consecutive results the loop has been observed in
+	consecutive int
+	persistent  bool
+}
+
+func makeLoopRecord(loop Loop) *LoopRecord {
+	return &LoopRecord{
+		Addresses:   loop,
+		occurrences: util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		lastSeen:    time.Unix(0, 0),
+	}
+}
+
+func (record *LoopRecord) GetOccurrencesWithin(window TimeRange) int64 {
+	return countOf(record.occurrences.SumWithin(window.Start, window.End))
+}
+
+func (record *LoopRecord) GetLastSeen() time.Time {
+	return record.lastSeen
+}
+
+// IsPersistent checks if the loop has been observed in at least LOOP_PERSISTENCE_THRESHOLD consecutive results. Loops
+// which have not are treated as transient.
+func (record *LoopRecord) IsPersistent() bool {
+	return record.persistent
+}
+
+// LoopEvent records when a loop first appeared on a route or when it became persistent
+type LoopEvent struct {
+	Timestamp   time.Time
+	ProbeId     int
+	Destination netip.Addr
+	Addresses   Loop
+	Persistent  bool
+}
+
+// detectLoops records the loops within a result and flags the nodes and edges which are part of them
+func (routeData *RouteData) detectLoops(hops [][]*traceroute.Reply, layers [][]NodeId, timestamp time.Time) {
+	loops := findLoops(hops)
+	inOrder := !timestamp.Before(routeData.latestLoopCheck)
+	if inOrder {
+		routeData.latestLoopCheck = timestamp
+	}
+
+	observed := make(map[string]struct{})
+	for _, loop := range loops {
+		key := loop.key()
+		observed[key] = struct{}{}
+
+		record, existed := routeData.Loops[key]
+		if !existed {
+			record = makeLoopRecord(loop)
+			routeData.Loops[key] = record
+			routeData.recordLoopEvent(record, timestamp)
+		}
+
+		record.occurrences.Append(1.0, timestamp)
+		if record.lastSeen.Before(timestamp) {
+			record.lastSeen = timestamp
+		}
+
+		// Results received out of order can not tell us if a loop was seen in consecutive results
+		if inOrder {
+			record.consecutive += 1
+			if !record.persistent && record.consecutive >= config.LoopPersistenceThreshold.GetInt() {
+				record.persistent = true
+				routeData.recordLoopEvent(record, timestamp)
+			}
+		}
+
+		routeData.flagLoop(loop, layers, timestamp)
+	}
+
+	if inOrder {
+		for key, record := range routeData.Loops {
+			if _, ok := observed[key]; !ok {
+				record.consecutive = 0
+			}
+		}
+	}
+
+	if len(loops) > 0 {
+		routeData.loopingResults.Append(1.0, timestamp)
+	}
+}
+
+func (routeData *RouteData) recordLoopEvent(record *LoopRecord, timestamp time.Time) {
+	if routeData.events == nil {
+		return
+	}
+
+	routeData.events.Loops.Append(LoopEvent{
+		Timestamp:   timestamp,
+		ProbeId:     routeData.probeId,
+		Destination: routeData.destination,
+		Addresses:   record.Addresses,
+		Persistent:  record.persistent,
+	})
+}
+
+// flagLoop marks the nodes and edges between members of a loop as being part of a loop for a result
+func (routeData *RouteData) flagLoop(loop Loop, layers [][]NodeId, timestamp time.Time) {
+	for _, addr := range loop {
+		if node, ok := routeData.Nodes[WrapAddr(addr)]; ok {
+			node.loopUsage.Append(1.0, timestamp)
+		}
+	}
+
+	// Follow the same layering used when adding edges so both raw and clean edges get flagged
+	flagged := make(map[*Edge]struct{})
+	previousKnown := layers[0]
+
+	for index := 1; index < len(layers); index++ {
+		var known []NodeId
+		for _, id := range layers[index] {
+			if !id.IsTimeout() {
+				known = append(known, id)
+			}
+		}
+
+		flagLoopEdges(routeData.Edges, loop, layers[index-1], layers[index], timestamp, flagged)
+
+		if len(known) > 0 {
+			flagLoopEdges(routeData.CleanEdges, loop, previousKnown, known, timestamp, flagged)
+			previousKnown = known
+		}
+	}
+}
+
+func flagLoopEdges(edges map[DirectedGraphEdge]*Edge, loop Loop, from, to []NodeId, timestamp time.Time, flagged map[*Edge]struct{}) {
+	for _, src := range from {
+		if src.IsTimeout() || !loop.contains(src.Ip) {
+			continue
+		}
+
+		for _, dst := range to {
+			if dst.IsTimeout() || !loop.contains(dst.Ip) {
+				continue
+			}
+
+			edge, ok := edges[DirectedGraphEdge{Start: src, Stop: dst}]
+			if _, seen := flagged[edge]; !ok || seen {
+				continue
+			}
+
+			flagged[edge] = struct{}{}
+			edge.loopUsage.Append(1.0, timestamp)
+		}
+	}
+}
+
+func (routeData *RouteData) GetLoopingResultsWithin(window TimeRange) int64 {
+	return countOf(routeData.loopingResults.SumWithin(window.Start, window.End))
+}
@@ -0,0 +1,103 @@
+package traceroute
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoopDetection(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	appendPath := func(addresses ...string) {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1}
+		for index, address := range addresses {
+			result.hops = append(result.hops, replies(float64(index+1), address))
+		}
+
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	appendPath("10.0.1.1", "10.0.2.1", testDestination)
+	if events := tracerouteData.Events.Loops.Events(); len(events) != 0 {
+		t.Fatalf("Expected no loops on a loop free path, but found %d", len(events))
+	}
+
+	threshold := config.LoopPersistenceThreshold.GetInt()
+	for i := 0; i < threshold; i++ {
+		appendPath("10.0.1.1", "10.0.2.1", "10.0.3.1", "10.0.2.1", "10.0.3.1", "*")
+	}
+
+	events := tracerouteData.Events.Loops.Events()
+	if len(events) != 2 {
+		t.Fatalf("Expected a transient and a persistent loop event, but found %d", len(events))
+	}
+
+	expectedLoop := []string{"10.0.2.1", "10.0.3.1"}
+	if !reflect.DeepEqual(events[0].Addresses.Strings(), expectedLoop) || events[0].Persistent {
+		t.Errorf("Expected transient loop over %v, but got %+v", expectedLoop, events[0])
+	}
+
+	if !events[1].Persistent {
+		t.Errorf("Expected second loop event to be persistent, but got %+v", events[1])
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := StatisticsWindow(time.Unix(int64(timestamp), 0))
+
+	if looping := routeData.GetLoopingResultsWithin(window); looping != int64(threshold) {
+		t.Errorf("Expected %d looping results, but got %d", threshold, looping)
+	}
+
+	looped := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.2.1"))]
+	if count := looped.GetLoopUsagesWithin(window); count != int64(threshold) {
+		t.Errorf("Expected loop member to be flagged %d times, but got %d", threshold, count)
+	}
+
+	outside := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.1.1"))]
+	if count := outside.GetLoopUsagesWithin(window); count != 0 {
+		t.Errorf("Expected node outside the loop to not be flagged, but got %d", count)
+	}
+
+	// A result without the loop resets the persistence count, so the next appearance is not persistent yet
+	appendPath("10.0.1.1", "10.0.2.1", testDestination)
+	appendPath("10.0.1.1", "10.0.2.1", "10.0.3.1", "10.0.2.1", "*")
+
+	for _, record := range routeData.Loops {
+		if record.consecutive > 1 {
+			t.Errorf("Expected consecutive count to be reset, but got %d for %v", record.consecutive, record.Addresses)
+		}
+	}
+}
+
+func TestFindLoopsMergesOverlappingCycles(t *testing.T) {
+	result := testResult{
+		msmId:     1,
+		probeId:   100,
+		timestamp: 1672531200,
+		parisId:   1,
+		hops: [][]testReply{
+			replies(1.0, "10.0.1.1"),
+			replies(2.0, "10.0.2.1"),
+			replies(3.0, "10.0.3.1"),
+			replies(4.0, "10.0.2.1"),
+			replies(5.0, "10.0.4.1"),
+			replies(6.0, "10.0.3.1"),
+			replies(7.0, testDestination),
+		},
+	}.build(t)
+
+	loops := findLoops(filterValidReplies(result.TracerouteResults()))
+	if len(loops) != 1 {
+		t.Fatalf("Expected overlapping cycles to be merged into a single loop, but found %d", len(loops))
+	}
+
+	expected := []string{"10.0.2.1", "10.0.3.1", "10.0.4.1"}
+	if !reflect.DeepEqual(loops[0].Strings(), expected) {
+		t.Errorf("Expected loop over %v, but got %v", expected, loops[0].Strings())
+	}
+}
@@ -0,0 +1,109 @@
+package traceroute
+
+import (
+	"github.com/DNS-OARC/ripeatlas/measurement/traceroute"
+	"net/netip"
+	"time"
+)
+
+// hopResponses counts the packets sent at a single hop along with the valid replies received from each address
+type hopResponses struct {
+	attempts int
+	replies  map[netip.Addr]int
+	// fastest holds the lowest RTT of the replies from each address
+	fastest map[netip.Addr]float64
+}
+
+// lost gets the number of packets sent at the hop which did not receive a valid reply
+func (responses hopResponses) lost() int {
+	lost := responses.attempts
+	for _, count := range responses.replies {
+		lost -= count
+	}
+
+	return lost
+}
+
+// attemptsFor estimates the number of packets sent towards an address. Packets which were lost could have been sent to
+// any of the addresses at the hop, so they are counted against each of them.
+func (responses hopResponses) attemptsFor(addr netip.Addr) int {
+	return responses.replies[addr] + responses.lost()
+}
+
+// countResponses counts the packets sent and replies received at each hop. Replies with errors are counted as lost.
+func countResponses(results []*traceroute.Result, validReplies [][]*traceroute.Reply) []hopResponses {
+	responses := make([]hopResponses, len(results))
+
+	for index, hop := range results {
+		responses[index] = hopResponses{
+			attempts: len(hop.Replies()),
+			replies:  make(map[netip.Addr]int),
+			fastest:  make(map[netip.Addr]float64),
+		}
+
+		for _, reply := range validReplies[index] {
+			if reply.X() != "" {
+				continue
+			}
+
+			// We know that the address must be valid because we verified it while checking reply for errors
+			addr := netip.MustParseAddr(reply.From())
+			responses[index].replies[addr] += 1
+
+			if fastest, ok := responses[index].fastest[addr]; !ok || reply.Rtt() < fastest {
+				responses[index].fastest[addr] = reply.Rtt()
+			}
+		}
+	}
+
+	return responses
+}
+
+// recordResponses adds the number of packets sent to and answered by each address, and records if the destination was
+// reached
+func (routeData *RouteData) recordResponses(responses []hopResponses, timestamp time.Time) {
+	reached := false
+
+	for _, hop := range responses {
+		for addr, count := range hop.replies {
+			node := routeData.getOrCreateNode(WrapAddr(addr))
+			node.replies.Append(float64(count), timestamp)
+			node.attempts.Append(float64(hop.attemptsFor(addr)), timestamp)
+
+			if addr == routeData.destination {
+				reached = true
+			}
+		}
+	}
+
+	if reached {
+		routeData.reachedResults.Append(1.0, timestamp)
+	}
+}
+
+// responseRate divides replies by attempts, returning false when nothing was sent
+func responseRate(replies, attempts float64) (float64, bool) {
+	if attempts == 0 {
+		return 0, false
+	}
+
+	return replies / attempts, true
+}
+
+// GetResponseRateWithin gets the fraction of packets sent towards this node which it replied to. The second return
+// value is false for timeouts and nodes which have not been used within the window.
+func (node *Node) GetResponseRateWithin(window TimeRange) (float64, bool) {
+	return responseRate(node.replies.SumWithin(window.Start, window.End), node.attempts.SumWithin(window.Start, window.End))
+}
+
+// GetLossRateWithin estimates the fraction of packets which were lost on the way to the end of this edge, based on how
+// often the end node replied in the results which used this edge
+func (edge *Edge) GetLossRateWithin(window TimeRange) (float64, bool) {
+	rate, ok := responseRate(edge.replies.SumWithin(window.Start, window.End), edge.attempts.SumWithin(window.Start, window.End))
+	return 1 - rate, ok
+}
+
+// GetReachabilityWithin gets the fraction of results within the window which received a reply from the destination
+func (routeData *RouteData) GetReachabilityWithin(window TimeRange) (float64, bool) {
+	return responseRate(routeData.reachedResults.SumWithin(window.Start, window.End), float64(routeData.GetTotalUsagesWithin(window)))
+}
@@ -0,0 +1,61 @@
+package traceroute
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestResponseRates(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	appendResult := func(hops ...[]testReply) {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1, hops: hops}
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	appendResult(
+		replies(1.0, "10.0.1.1", "10.0.1.1", "10.0.1.1"),
+		replies(5.0, "10.0.2.1", "*", "*"),
+		replies(10.0, testDestination, testDestination, testDestination),
+	)
+
+	appendResult(
+		replies(1.0, "10.0.1.1", "10.0.1.1", "10.0.1.1"),
+		replies(5.0, "10.0.2.1", "10.0.2.1", "10.0.2.1"),
+		replies(0, "*", "*", "*"),
+	)
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := StatisticsWindow(time.Unix(int64(timestamp), 0))
+
+	expectRate := func(name string, actual float64, ok bool, expected float64) {
+		if !ok || math.Abs(actual-expected) > 1e-9 {
+			t.Errorf("Expected %s to be %v, but got %v (present: %v)", name, expected, actual, ok)
+		}
+	}
+
+	rate, ok := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.1.1"))].GetResponseRateWithin(window)
+	expectRate("response rate of first hop", rate, ok, 1)
+
+	rate, ok = routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.2.1"))].GetResponseRateWithin(window)
+	expectRate("response rate of second hop", rate, ok, 4.0/6.0)
+
+	edge := routeData.CleanEdges[DirectedGraphEdge{
+		Start: WrapAddr(netip.MustParseAddr("10.0.1.1")),
+		Stop:  WrapAddr(netip.MustParseAddr("10.0.2.1")),
+	}]
+	rate, ok = edge.GetLossRateWithin(window)
+	expectRate("loss rate of edge", rate, ok, 2.0/6.0)
+
+	rate, ok = routeData.GetReachabilityWithin(window)
+	expectRate("reachability", rate, ok, 0.5)
+
+	timeout := NodeId{Ip: netip.MustParseAddr("10.0.2.1"), TimeoutsSinceKnown: 1}
+	if _, ok = routeData.Nodes[timeout].GetResponseRateWithin(window); ok {
+		t.Error("Expected timeouts to not have a response rate")
+	}
+}
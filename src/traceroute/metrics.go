@@ -2,6 +2,7 @@ package traceroute
 
 import (
 	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
 	"time"
 )
 
@@ -9,6 +10,14 @@ type TimeRange struct {
 	Start, End time.Time
 }
 
+// StatisticsWindow is the TimeRange covering the full statistics period up until the given time
+func StatisticsWindow(end time.Time) TimeRange {
+	return TimeRange{
+		Start: end.Add(-config.StatisticsPeriod.GetDuration()),
+		End:   end,
+	}
+}
+
 func (timeRange TimeRange) append(timestamp time.Time) TimeRange {
 	if timeRange.Start.After(timestamp) {
 		timeRange.Start = timestamp
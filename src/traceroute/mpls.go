@@ -0,0 +1,254 @@
+package traceroute
+
+import (
+	"encoding/json"
+	"github.com/DNS-OARC/ripeatlas/measurement/traceroute"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ICMP extension object class and type used for MPLS label stacks (RFC 4950)
+const (
+	mplsObjectClass = 1
+	mplsObjectType  = 1
+)
+
+// MplsLabel is a single entry of the MPLS label stack quoted in an ICMP time exceeded message
+type MplsLabel struct {
+	Label         uint32 `json:"label"`
+	Exp           int    `json:"exp"`
+	BottomOfStack bool   `json:"-"`
+	Ttl           int    `json:"ttl"`
+}
+
+func (label *MplsLabel) UnmarshalJSON(b []byte) error {
+	type rawLabel MplsLabel
+	var data struct {
+		rawLabel
+		S int `json:"s"`
+	}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	*label = MplsLabel(data.rawLabel)
+	label.BottomOfStack = data.S != 0
+	return nil
+}
+
+// parseMplsLabels reads the MPLS label stack from the ICMP extension objects of a reply. Objects which can not be read
+// are skipped since the extension format is not validated by the ripeatlas library.
+func parseMplsLabels(reply *traceroute.Reply) (labels []MplsLabel) {
+	icmpext := reply.Icmpext()
+	if icmpext == nil {
+		return
+	}
+
+	for _, object := range icmpext.Objects() {
+		encoded, err := json.Marshal(object)
+		if err != nil {
+			continue
+		}
+
+		var parsed struct {
+			Class int         `json:"class"`
+			Type  int         `json:"type"`
+			Mpls  []MplsLabel `json:"mpls"`
+		}
+
+		if json.Unmarshal(encoded, &parsed) != nil || parsed.Class != mplsObjectClass || parsed.Type != mplsObjectType {
+			continue
+		}
+
+		labels = append(labels, parsed.Mpls...)
+	}
+
+	return
+}
+
+// TunnelKind is the way an MPLS tunnel was revealed by a traceroute
+type TunnelKind string
+
+const (
+	// ExplicitTunnel hops quote their MPLS label stack and propagate the IP TTL into the tunnel
+	ExplicitTunnel TunnelKind = "explicit"
+	// ImplicitTunnel hops propagate the IP TTL into the tunnel, but do not quote their label stack. They are revealed by
+	// a quoted IP TTL (ittl) greater than 1 since the IP TTL is only decremented when leaving the tunnel.
+	ImplicitTunnel TunnelKind = "implicit"
+	// OpaqueTunnel hops are hidden from the traceroute, but the egress router quotes a label with a TTL other than 1
+	OpaqueTunnel TunnelKind = "opaque"
+)
+
+// tunnelHop holds the MPLS signatures found in the replies for a single hop
+type tunnelHop struct {
+	addr     netip.Addr
+	labels   []MplsLabel
+	implicit bool
+}
+
+func readTunnelHop(hop []*traceroute.Reply) (result tunnelHop) {
+	for _, reply := range hop {
+		if reply.X() == "*" {
+			continue
+		}
+
+		// We know that the address must be valid because we verified it while checking reply for errors
+		addr := netip.MustParseAddr(reply.From())
+		if labels := parseMplsLabels(reply); len(labels) > 0 {
+			return tunnelHop{addr: addr, labels: labels}
+		}
+
+		if reply.Ittl() > 1 && !result.implicit {
+			result = tunnelHop{addr: addr, implicit: true}
+		}
+	}
+
+	return
+}
+
+// Tunnel is a sequence of hops which were found to be part of an MPLS tunnel within a single result
+type Tunnel struct {
+	Kind TunnelKind
+	Hops Path
+}
+
+func (tunnel Tunnel) key() string {
+	var builder strings.Builder
+	builder.WriteString(string(tunnel.Kind))
+	builder.WriteByte(' ')
+	builder.WriteString(tunnel.Hops.key())
+	return builder.String()
+}
+
+// findTunnels infers MPLS tunnels from runs of consecutive hops with the same MPLS signature
+func findTunnels(hops [][]*traceroute.Reply) (tunnels []Tunnel) {
+	var current *Tunnel
+	var lastLabels []MplsLabel
+
+	finish := func() {
+		if current == nil {
+			return
+		}
+
+		// A lone labelled hop with a label TTL greater than 1 is the egress of a tunnel whose other hops were hidden
+		if current.Kind == ExplicitTunnel && len(current.Hops) == 1 && len(lastLabels) > 0 && lastLabels[0].Ttl > 1 {
+			current.Kind = OpaqueTunnel
+		}
+
+		tunnels = append(tunnels, *current)
+		current = nil
+	}
+
+	for _, hop := range hops {
+		signature := readTunnelHop(hop)
+
+		var kind TunnelKind
+		switch {
+		case len(signature.labels) > 0:
+			kind = ExplicitTunnel
+		case signature.implicit:
+			kind = ImplicitTunnel
+		default:
+			finish()
+			continue
+		}
+
+		if current != nil && current.Kind != kind {
+			finish()
+		}
+
+		if current == nil {
+			current = &Tunnel{Kind: kind}
+		}
+
+		current.Hops = append(current.Hops, signature.addr)
+		lastLabels = signature.labels
+	}
+
+	finish()
+	return
+}
+
+// TunnelRecord tracks how often an MPLS tunnel has been observed on a route
+type TunnelRecord struct {
+	Tunnel
+	occurrences util.MovingSummation
+	seen        usageRanges
+}
+
+func makeTunnelRecord(tunnel Tunnel) *TunnelRecord {
+	return &TunnelRecord{
+		Tunnel:      tunnel,
+		occurrences: util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+	}
+}
+
+func (record *TunnelRecord) GetOccurrencesWithin(window TimeRange) int64 {
+	return countOf(record.occurrences.SumWithin(window.Start, window.End))
+}
+
+// GetLastSeenWithin gets the last time the tunnel was observed within the window
+func (record *TunnelRecord) GetLastSeenWithin(window TimeRange) (time.Time, bool) {
+	return record.seen.lastUsedWithin(window)
+}
+
+// detectTunnels records the MPLS labels quoted by each node and the tunnels they form
+func (routeData *RouteData) detectTunnels(hops [][]*traceroute.Reply, timestamp time.Time) {
+	for _, hop := range hops {
+		for _, reply := range hop {
+			if reply.X() == "*" {
+				continue
+			}
+
+			labels := parseMplsLabels(reply)
+			if len(labels) == 0 {
+				continue
+			}
+
+			node := routeData.getOrCreateNode(WrapAddr(netip.MustParseAddr(reply.From())))
+			for _, label := range labels {
+				util.MapGetOrCreate(node.mplsLabels, label.Label, func() *usageRanges {
+					return new(usageRanges)
+				}).record(timestamp)
+			}
+		}
+	}
+
+	for _, tunnel := range findTunnels(hops) {
+		record := util.MapGetOrCreate(routeData.Tunnels, tunnel.key(), func() *TunnelRecord {
+			return makeTunnelRecord(tunnel)
+		})
+
+		record.occurrences.Append(1.0, timestamp)
+		record.seen.record(timestamp)
+	}
+}
+
+// GetMplsLabelsWithin gets the MPLS labels this node quoted within the window
+func (node *Node) GetMplsLabelsWithin(window TimeRange) (labels []uint32) {
+	for label, usage := range node.mplsLabels {
+		if usage.usedWithin(window) {
+			labels = append(labels, label)
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i] < labels[j]
+	})
+
+	return
+}
+
+func (node *Node) evictMplsLabelsBefore(oldestAllowed time.Time) {
+	for label, usage := range node.mplsLabels {
+		usage.evictBefore(oldestAllowed)
+		if usage.isEmpty() {
+			delete(node.mplsLabels, label)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package traceroute
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func labelled(address string, label uint32, ttl int) []testReply {
+	return []testReply{{
+		from:   address,
+		rtt:    10.0,
+		labels: []MplsLabel{{Label: label, BottomOfStack: true, Ttl: ttl}},
+	}}
+}
+
+func TestFindTunnels(t *testing.T) {
+	result := testResult{
+		msmId:     1,
+		probeId:   100,
+		timestamp: 1672531200,
+		parisId:   1,
+		hops: [][]testReply{
+			replies(1.0, "10.0.1.1"),
+			labelled("10.0.2.1", 16001, 1),
+			labelled("10.0.3.1", 16002, 1),
+			replies(12.0, "10.0.4.1"),
+			{{from: "10.0.5.1", rtt: 14.0, ittl: 2}},
+			{{from: "10.0.6.1", rtt: 15.0, ittl: 3}},
+			replies(16.0, "*"),
+			labelled("10.0.7.1", 24000, 253),
+			replies(20.0, testDestination),
+		},
+	}.build(t)
+
+	tunnels := findTunnels(filterValidReplies(result.TracerouteResults()))
+
+	expected := []struct {
+		kind TunnelKind
+		hops []string
+	}{
+		{ExplicitTunnel, []string{"10.0.2.1", "10.0.3.1"}},
+		{ImplicitTunnel, []string{"10.0.5.1", "10.0.6.1"}},
+		{OpaqueTunnel, []string{"10.0.7.1"}},
+	}
+
+	if len(tunnels) != len(expected) {
+		t.Fatalf("Expected %d tunnels, but found %d: %+v", len(expected), len(tunnels), tunnels)
+	}
+
+	for index, tunnel := range tunnels {
+		if tunnel.Kind != expected[index].kind || !reflect.DeepEqual(tunnel.Hops.Strings(), expected[index].hops) {
+			t.Errorf("Expected %s tunnel over %v, but got %s tunnel over %v", expected[index].kind, expected[index].hops, tunnel.Kind, tunnel.Hops.Strings())
+		}
+	}
+}
+
+func TestNodesRecordMplsLabels(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	for _, label := range []uint32{16001, 16005} {
+		result := testResult{
+			msmId:     1,
+			probeId:   100,
+			timestamp: timestamp,
+			parisId:   1,
+			hops: [][]testReply{
+				replies(1.0, "10.0.1.1"),
+				labelled("10.0.2.1", label, 1),
+				replies(20.0, testDestination),
+			},
+		}
+
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := StatisticsWindow(time.Unix(int64(timestamp), 0))
+
+	node := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.2.1"))]
+	if labels := node.GetMplsLabelsWithin(window); !reflect.DeepEqual(labels, []uint32{16001, 16005}) {
+		t.Errorf("Expected node to have quoted labels 16001 and 16005, but got %v", labels)
+	}
+
+	if len(routeData.Tunnels) != 1 {
+		t.Fatalf("Expected a single tunnel, but found %d", len(routeData.Tunnels))
+	}
+
+	for _, tunnel := range routeData.Tunnels {
+		if occurrences := tunnel.GetOccurrencesWithin(window); occurrences != 2 {
+			t.Errorf("Expected tunnel to be seen twice, but got %d", occurrences)
+		}
+	}
+}
+
+func TestMplsLabelsWithinEarlierWindow(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	start := 1672531200
+
+	// The same label is quoted again two days later, after a different label was quoted in between
+	for index, label := range []uint32{16001, 16005, 16001} {
+		timestamp := start + index*24*3600
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1, hops: [][]testReply{
+			replies(1.0, "10.0.1.1"),
+			labelled("10.0.2.1", label, 1),
+			replies(20.0, testDestination),
+		}}
+
+		tracerouteData.AppendMeasurement(result.build(t))
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	node := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.2.1"))]
+
+	earlier := TimeRange{Start: time.Unix(int64(start), 0), End: time.Unix(int64(start+3600), 0)}
+	if labels := node.GetMplsLabelsWithin(earlier); !reflect.DeepEqual(labels, []uint32{16001}) {
+		t.Errorf("Expected label 16001 within the earlier window, but got %v", labels)
+	}
+
+	middle := TimeRange{Start: time.Unix(int64(start+23*3600), 0), End: time.Unix(int64(start+25*3600), 0)}
+	if labels := node.GetMplsLabelsWithin(middle); !reflect.DeepEqual(labels, []uint32{16005}) {
+		t.Errorf("Expected label 16005 within the middle window, but got %v", labels)
+	}
+
+	for _, tunnel := range routeData.Tunnels {
+		if lastSeen, ok := tunnel.GetLastSeenWithin(earlier); !ok || lastSeen.Unix() != int64(start) {
+			t.Errorf("Expected tunnel to be last seen at the start of the earlier window, but got %v", lastSeen)
+		}
+	}
+}
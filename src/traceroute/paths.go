@@ -0,0 +1,276 @@
+package traceroute
+
+import (
+	"github.com/DNS-OARC/ripeatlas/measurement/traceroute"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Path is the sequence of addresses which replied at each hop of a traceroute. Hops where no reply was received are
+// represented by an invalid (zero) address.
+type Path []netip.Addr
+
+func (path Path) key() string {
+	var builder strings.Builder
+	for _, hop := range path {
+		builder.WriteString(hop.String())
+		builder.WriteByte(' ')
+	}
+
+	return builder.String()
+}
+
+func (path Path) Strings() (output []string) {
+	for _, hop := range path {
+		if hop.IsValid() {
+			output = append(output, hop.String())
+		} else {
+			output = append(output, "*")
+		}
+	}
+
+	return
+}
+
+// toPath picks the address which replied the most at each hop to form the hop sequence for a single result. When
+// multiple addresses reply equally often, the one which replied first is used.
+func toPath(hops [][]*traceroute.Reply) (path Path) {
+	for _, hop := range hops {
+		counts := make(map[netip.Addr]int)
+		var selected netip.Addr
+
+		for _, reply := range hop {
+			if reply.X() == "*" {
+				continue
+			}
+
+			// We know that the address must be valid because we verified it while checking reply for errors
+			addr := netip.MustParseAddr(reply.From())
+			counts[addr] += 1
+
+			if !selected.IsValid() || counts[addr] > counts[selected] {
+				selected = addr
+			}
+		}
+
+		path = append(path, selected)
+	}
+
+	return
+}
+
+// PathRecord holds the usage of a single hop sequence on a route
+type PathRecord struct {
+	Hops     Path
+	key      string
+	usage    util.MovingSummation
+	lastUsed time.Time
+	// Periods during which every result of the route used this path
+	lifespan lifespanTracker
+}
+
+func makePathRecord(hops Path) *PathRecord {
+	return &PathRecord{
+		Hops:     hops,
+		key:      hops.key(),
+		usage:    util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		lastUsed: time.Unix(0, 0),
+	}
+}
+
+func (record *PathRecord) GetUsageWithin(window TimeRange) int64 {
+	return countOf(record.usage.SumWithin(window.Start, window.End))
+}
+
+func (record *PathRecord) GetLastUsed() time.Time {
+	return record.lastUsed
+}
+
+// PathChange records when the dominant path between a probe and destination changed
+type PathChange struct {
+	Timestamp   time.Time
+	ProbeId     int
+	Destination netip.Addr
+	OldPath     Path
+	NewPath     Path
+}
+
+// pathTracker finds the dominant path of a route from its most recent results and detects when it changes. History is
+// downloaded concurrently with live collection, so results can arrive in any order. The tracker keeps every result
+// within the statistics period sorted by timestamp and replays the dominant path from wherever a result is inserted.
+type pathTracker struct {
+	results []pathObservation
+	// Dominant path before the first result still held by the tracker
+	initial *PathRecord
+}
+
+// pathObservation is the path used by a single result and the dominant path once that result was taken into account
+type pathObservation struct {
+	timestamp int64
+	path      *PathRecord
+	dominant  *PathRecord
+}
+
+// pathTransition is a change in the dominant path found while observing a result
+type pathTransition struct {
+	timestamp int64
+	previous  *PathRecord
+	current   *PathRecord
+}
+
+// samePath checks if two records hold the same hop sequence. A record may be replaced by a new one for the same path if
+// it was evicted while the tracker still held results using it.
+func samePath(first *PathRecord, second *PathRecord) bool {
+	if first == nil || second == nil {
+		return first == second
+	}
+
+	return first.key == second.key
+}
+
+// dominantAfter finds the dominant path after the result at index given the dominant path before it. The path of the
+// result only becomes dominant once it has been used by the majority of the results in the window ending at index.
+func (tracker *pathTracker) dominantAfter(index int, previous *PathRecord) *PathRecord {
+	path := tracker.results[index].path
+	if previous == nil {
+		return path
+	}
+	if samePath(path, previous) {
+		return previous
+	}
+
+	windowStart := index + 1 - config.PathChangeWindow.GetInt()
+	if windowStart < 0 {
+		windowStart = 0
+	}
+
+	count := 0
+	for _, result := range tracker.results[windowStart : index+1] {
+		if samePath(result.path, path) {
+			count += 1
+		}
+	}
+
+	if 2*count > index+1-windowStart {
+		return path
+	}
+
+	return previous
+}
+
+// observe inserts the path used by a new result and returns the changes to the dominant path which were not already
+// reported. Changes reported by earlier calls can not be withdrawn if a late result shows they did not happen.
+func (tracker *pathTracker) observe(path *PathRecord, timestamp time.Time) (transitions []pathTransition) {
+	unix := timestamp.Unix()
+	index := sort.Search(len(tracker.results), func(i int) bool {
+		return tracker.results[i].timestamp > unix
+	})
+
+	tracker.results = append(tracker.results, pathObservation{})
+	copy(tracker.results[index+1:], tracker.results[index:])
+	tracker.results[index] = pathObservation{timestamp: unix, path: path}
+
+	previous := tracker.initial
+	if index > 0 {
+		previous = tracker.results[index-1].dominant
+	}
+
+	// Dominant path before each result prior to this insertion, so changes which were already reported are skipped
+	oldPrevious := previous
+	windowSize := config.PathChangeWindow.GetInt()
+	for position := index; position < len(tracker.results); position++ {
+		oldDominant := tracker.results[position].dominant
+		dominant := tracker.dominantAfter(position, previous)
+		tracker.results[position].dominant = dominant
+
+		// A change into the same path at the same result was already reported, even if it was from another path
+		reported := position != index && oldPrevious != nil && !samePath(oldPrevious, oldDominant) && samePath(oldDominant, dominant)
+		if previous != nil && !samePath(dominant, previous) && !reported {
+			transitions = append(transitions, pathTransition{
+				timestamp: tracker.results[position].timestamp,
+				previous:  previous,
+				current:   dominant,
+			})
+		}
+
+		// Once the inserted result has left the window, nothing after an unchanged result can change either
+		if position != index && position-index >= windowSize && samePath(oldDominant, dominant) {
+			break
+		}
+
+		if position != index {
+			oldPrevious = oldDominant
+		}
+		previous = dominant
+	}
+
+	return
+}
+
+// evictBefore removes results older than the oldest allowed timestamp. The most recent window of results is always kept
+// so the dominant path of a route which has not been measured recently does not change on its next result.
+func (tracker *pathTracker) evictBefore(oldestAllowed time.Time) {
+	cutoff := sort.Search(len(tracker.results), func(i int) bool {
+		return tracker.results[i].timestamp >= oldestAllowed.Unix()
+	})
+
+	if keep := len(tracker.results) - config.PathChangeWindow.GetInt(); cutoff > keep {
+		cutoff = keep
+	}
+
+	if cutoff <= 0 {
+		return
+	}
+
+	tracker.initial = tracker.results[cutoff-1].dominant
+	tracker.results = append([]pathObservation(nil), tracker.results[cutoff:]...)
+}
+
+// current gets the dominant path after the latest result
+func (tracker *pathTracker) current() *PathRecord {
+	if len(tracker.results) == 0 {
+		return tracker.initial
+	}
+
+	return tracker.results[len(tracker.results)-1].dominant
+}
+
+func (routeData *RouteData) updatePaths(path Path, timestamp time.Time) {
+	key := path.key()
+	record := util.MapGetOrCreate(routeData.Paths, key, func() *PathRecord {
+		return makePathRecord(path)
+	})
+
+	record.usage.Append(1.0, timestamp)
+	if record.lastUsed.Before(timestamp) {
+		record.lastUsed = timestamp
+	}
+
+	transitions := routeData.pathTracker.observe(record, timestamp)
+	if routeData.events == nil {
+		return
+	}
+
+	for _, transition := range transitions {
+		routeData.events.PathChanges.Append(PathChange{
+			Timestamp:   time.Unix(transition.timestamp, 0),
+			ProbeId:     routeData.probeId,
+			Destination: routeData.destination,
+			OldPath:     transition.previous.Hops,
+			NewPath:     transition.current.Hops,
+		})
+	}
+}
+
+// GetDominantPath gets the path currently used by the majority of recent results
+func (routeData *RouteData) GetDominantPath() (Path, bool) {
+	if record := routeData.pathTracker.current(); record != nil {
+		return record.Hops, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,102 @@
+package traceroute
+
+import (
+	"math/rand"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestPathChangeDetection(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	appendPath := func(addresses ...string) {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1}
+		for index, address := range addresses {
+			result.hops = append(result.hops, replies(float64(index+1), address))
+		}
+
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	for i := 0; i < 3; i++ {
+		appendPath("10.0.1.1", "10.0.2.1", testDestination)
+	}
+
+	// A single result on a different path should not be treated as a change
+	appendPath("10.0.1.1", "10.0.3.1", testDestination)
+	appendPath("10.0.1.1", "10.0.2.1", testDestination)
+
+	if changes := tracerouteData.Events.PathChanges.Events(); len(changes) != 0 {
+		t.Fatalf("Expected no path changes, but found %d", len(changes))
+	}
+
+	changeTimestamp := timestamp + 900
+	appendPath("10.0.1.1", "*", "10.0.3.1", testDestination)
+	appendPath("10.0.1.1", "*", "10.0.3.1", testDestination)
+
+	changes := tracerouteData.Events.PathChanges.Events()
+	if len(changes) != 1 {
+		t.Fatalf("Expected a single path change, but found %d", len(changes))
+	}
+
+	change := changes[0]
+	if change.Timestamp.Unix() != int64(changeTimestamp) || change.ProbeId != 100 {
+		t.Errorf("Path change has unexpected timestamp or probe: %+v", change)
+	}
+
+	expectedOld := []string{"10.0.1.1", "10.0.2.1", testDestination}
+	expectedNew := []string{"10.0.1.1", "*", "10.0.3.1", testDestination}
+	if !reflect.DeepEqual(change.OldPath.Strings(), expectedOld) || !reflect.DeepEqual(change.NewPath.Strings(), expectedNew) {
+		t.Errorf("Expected change from %v to %v, but got %v to %v", expectedOld, expectedNew, change.OldPath.Strings(), change.NewPath.Strings())
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	if dominant, ok := routeData.GetDominantPath(); !ok || !reflect.DeepEqual(dominant.Strings(), expectedNew) {
+		t.Errorf("Expected dominant path to be %v, but got %v", expectedNew, dominant.Strings())
+	}
+}
+
+func TestPathChangeDetectionOutOfOrder(t *testing.T) {
+	oldPath := []string{"10.0.1.1", "10.0.2.1", testDestination}
+	newPath := []string{"10.0.1.1", "*", "10.0.3.1", testDestination}
+	paths := [][]string{oldPath, oldPath, oldPath, {"10.0.1.1", "10.0.3.1", testDestination}, oldPath, newPath, newPath}
+
+	start := 1672531200
+	changeTimestamp := int64(start + 6*900)
+
+	// History and live collection deliver results in any order, but the change should still be found at the same result
+	orders := [][]int{{6, 5, 4, 3, 2, 1, 0}, {3, 5, 6, 0, 4, 2, 1}}
+	for seed := int64(0); seed < 20; seed++ {
+		orders = append(orders, rand.New(rand.NewSource(seed)).Perm(len(paths)))
+	}
+
+	for _, order := range orders {
+		tracerouteData := MakeTracerouteData()
+		for _, index := range order {
+			result := testResult{msmId: 1, probeId: 100, timestamp: start + index*900, parisId: 1}
+			for hop, address := range paths[index] {
+				result.hops = append(result.hops, replies(float64(hop+1), address))
+			}
+
+			tracerouteData.AppendMeasurement(result.build(t))
+		}
+
+		changes := tracerouteData.Events.PathChanges.Events()
+		if len(changes) != 1 {
+			t.Errorf("Expected a single path change for order %v, but found %d", order, len(changes))
+			continue
+		}
+
+		if change := changes[0]; change.Timestamp.Unix() != changeTimestamp || !reflect.DeepEqual(change.NewPath.Strings(), newPath) {
+			t.Errorf("Expected change to %v at %d for order %v, but got %v at %d", newPath, changeTimestamp, order, change.NewPath.Strings(), change.Timestamp.Unix())
+		}
+
+		routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+		if dominant, ok := routeData.GetDominantPath(); !ok || !reflect.DeepEqual(dominant.Strings(), newPath) {
+			t.Errorf("Expected dominant path to be %v for order %v, but got %v", newPath, order, dominant.Strings())
+		}
+	}
+}
@@ -10,31 +10,59 @@ import (
 	"time"
 )
 
-func (tracerouteData *TracerouteData) AppendMeasurement(measurement *measurement.Result) {
+// AppendMeasurement adds a result to the traceroute data. If the same result has already been added, it is ignored and
+// false is returned.
+// AppendOutcome describes what AppendMeasurement did with a result
+type AppendOutcome int
+
+const (
+	// ResultAppended means the result was added to the data of its route
+	ResultAppended AppendOutcome = iota
+	// ResultDuplicate means the result was ignored since it had already been received
+	ResultDuplicate
+	// ResultRejected means the result was ignored since it contained errors
+	ResultRejected
+)
+
+func (tracerouteData *TracerouteData) AppendMeasurement(measurement *measurement.Result) AppendOutcome {
+	// History and live collection may overlap, so skip results we have already seen to avoid counting them twice
+	if !tracerouteData.markResultSeen(measurement) {
+		return ResultDuplicate
+	}
 
 	//Get the netip from the destination of the measurement
 	destination, err := netip.ParseAddr(measurement.DstName())
 	if err != nil {
 		log.Println("Unable to parse measurement ( id:", measurement.MsmId(), " timestamp: ", measurement.Timestamp(), "):", err)
-		return
+		return ResultRejected
+	}
+
+	// Check for errors before creating a route so rejected results do not leave behind empty routes
+	if checkMeasurementForErrors(measurement) {
+		return ResultRejected
 	}
 
 	//Get the traceroute path information from the source and destination addresses
 	data := tracerouteData.getOrCreateRouteData(measurement.PrbId(), destination)
 	//Add the measurement to the existing traceroute path information
-	data.AppendMeasurement(measurement)
+	if !data.AppendMeasurement(measurement) {
+		return ResultRejected
+	}
+
+	return ResultAppended
 }
 
-func (routeData *RouteData) AppendMeasurement(measurement *measurement.Result) {
+// AppendMeasurement adds a result to the route and returns false if it was skipped since it contained errors
+func (routeData *RouteData) AppendMeasurement(measurement *measurement.Result) bool {
 	// Skip measurements with errors
 	if checkMeasurementForErrors(measurement) {
-		return
+		return false
 	}
 
 	probeIp, err := netip.ParseAddr(measurement.SrcAddr())
 	if err != nil {
 		log.Printf("Failed to parse probe IP %q: %v\n", measurement.SrcAddr(), err)
-		return
+		return false
 	}
 
 	// Get Traceroute replies that don't contain errors
@@ -42,12 +70,21 @@ func (routeData *RouteData) AppendMeasurement(measurement *measurement.Result) {
 
 	// Add the filtered replies as Nodes
 	internalFormat := toNodeId(probeIp, validReplies)
+	responses := countResponses(measurement.TracerouteResults(), validReplies)
 
 	// Apply updates to edges
 	timestamp := time.Unix(int64(measurement.Timestamp()), 0)
+	routeData.detectAnomalies(responses, timestamp)
 	routeData.addNodesToGraph(probeIp, validReplies, timestamp)
-	routeData.addEdgesToGraph(internalFormat, timestamp)
-	routeData.addCleanEdgesToGraph(internalFormat, timestamp)
+	routeData.addEdgesToGraph(internalFormat, measurement.ParisId(), timestamp)
+	routeData.addCleanEdgesToGraph(internalFormat, responses, measurement.ParisId(), timestamp)
+	routeData.recordResponses(responses, timestamp)
+	path := toPath(validReplies)
+	routeData.updatePaths(path, timestamp)
+	routeData.recordPenultimateHop(path, timestamp)
+	routeData.recordLinks(validReplies, timestamp)
+	routeData.detectLoops(validReplies, internalFormat, timestamp)
+	routeData.detectTunnels(validReplies, timestamp)
 
 	probeNode := routeData.getOrCreateNode(NodeId{
 		Ip:                 probeIp,
@@ -58,15 +95,75 @@ func (routeData *RouteData) AppendMeasurement(measurement *measurement.Result) {
 	// assume an RTT of 0 to reach the origin. This is necessary to avoid NaNs from entering the data when trying to
 	// send information about the probe node.
 	probeNode.averageRtt.Append(0.0, timestamp)
+	probeNode.rttDistribution.Append(0.0, timestamp)
 	probeNode.totalUsage.Append(1.0, timestamp)
 	probeNode.lastUsed = timestamp
 	routeData.probeIps[probeIp] = timestamp
+	routeData.updateLifespans(internalFormat, path, timestamp)
 
 	// Increment route usage
 	routeData.routeUsage.Append(1.0, timestamp)
 
 	// Add metrics for route
 	routeData.Metrics.AppendMeasurement(measurement)
+	return true
+}
+
+// CleanGraphOf gets the known nodes and the clean edges which a result adds to the graph of its route. Nothing is
+// returned for results which AppendMeasurement would skip.
+func CleanGraphOf(measurement *measurement.Result) (nodes []NodeId, edges []DirectedGraphEdge) {
+	if checkMeasurementForErrors(measurement) {
+		return
+	}
+
+	probeIp, err := netip.ParseAddr(measurement.SrcAddr())
+	if err != nil {
+		return
+	}
+
+	layers := toNodeId(probeIp, filterValidReplies(measurement.TracerouteResults()))
+	visitedNodes := make(map[NodeId]struct{})
+	visitedEdges := make(map[DirectedGraphEdge]struct{})
+
+	// Follow the same steps as addCleanEdgesToGraph, where timeouts are skipped over
+	previousLayer := layers[0]
+	for _, id := range previousLayer {
+		visitedNodes[id] = struct{}{}
+		nodes = append(nodes, id)
+	}
+
+	for _, nextHop := range layers[1:] {
+		var nextLayer []NodeId
+		for _, id := range nextHop {
+			if id.IsTimeout() {
+				continue
+			}
+
+			nextLayer = append(nextLayer, id)
+			if _, ok := visitedNodes[id]; !ok {
+				visitedNodes[id] = struct{}{}
+				nodes = append(nodes, id)
+			}
+		}
+
+		if len(nextLayer) == 0 {
+			continue
+		}
+
+		for _, src := range previousLayer {
+			for _, dst := range nextLayer {
+				edge := DirectedGraphEdge{Start: src, Stop: dst}
+				if _, ok := visitedEdges[edge]; !ok {
+					visitedEdges[edge] = struct{}{}
+					edges = append(edges, edge)
+				}
+			}
+		}
+
+		previousLayer = nextLayer
+	}
+
+	return
 }
 
 func uniqueNodeIdsForLayer(replies []*traceroute.Reply, prevLayerCount int) int {
@@ -115,7 +212,8 @@ func (routeData *RouteData) addNodesToGraph(probeAddr netip.Addr, replies [][]*t
 	previousHop := []NodeId{WrapAddr(probeAddr)}
 	visitedNodes := map[NodeId]struct{}{}
 
-	for _, hop := range replies {
+	for index, hop := range replies {
+		hopNumber := index + 1
 		var nextHop []NodeId
 		expectedLayerNodes := uniqueNodeIdsForLayer(hop, len(previousHop))
 		handledTimeout := false
@@ -124,7 +222,7 @@ func (routeData *RouteData) addNodesToGraph(probeAddr netip.Addr, replies [][]*t
 			if reply.X() != "" {
 				for _, prevNodeId := range previousHop {
 					prevNodeId.TimeoutsSinceKnown += 1
-					routeData.updateGraphNode(prevNodeId, reply, timestamp, visitedNodes)
+					routeData.updateGraphNode(prevNodeId, reply, hopNumber, timestamp, visitedNodes)
 
 					if !handledTimeout {
 						nextHop = append(nextHop, prevNodeId)
@@ -138,7 +236,7 @@ func (routeData *RouteData) addNodesToGraph(probeAddr netip.Addr, replies [][]*t
 			// We know that the address must be valid because we verified it while checking reply for errors
 			ip := netip.MustParseAddr(reply.From())
 			nodeId := WrapAddr(ip)
-			routeData.updateGraphNode(nodeId, reply, timestamp, visitedNodes)
+			routeData.updateGraphNode(nodeId, reply, hopNumber, timestamp, visitedNodes)
 			nextHop = append(nextHop, nodeId)
 		}
 
@@ -149,13 +247,17 @@ func (routeData *RouteData) addNodesToGraph(probeAddr netip.Addr, replies [][]*t
 	}
 }
 
-func (routeData *RouteData) updateGraphNode(id NodeId, reply *traceroute.Reply, timestamp time.Time, visitedNodes map[NodeId]struct{}) {
+func (routeData *RouteData) updateGraphNode(id NodeId, reply *traceroute.Reply, hopNumber int, timestamp time.Time, visitedNodes map[NodeId]struct{}) {
 	//Get the Node related to this id
 	node := routeData.getOrCreateNode(id)
 	//Update the moving statistics of the node
 	node.lastUsed = timestamp
 
 	node.averageRtt.Append(reply.Rtt(), timestamp)
+	if reply.X() == "" {
+		node.rttDistribution.Append(reply.Rtt(), timestamp)
+	}
+	node.recordReplyTtl(reply, hopNumber, timestamp)
 
 	if _, ok := visitedNodes[id]; !ok {
 		node.totalUsage.Append(1.0, timestamp)
@@ -163,7 +265,7 @@ func (routeData *RouteData) updateGraphNode(id NodeId, reply *traceroute.Reply,
 	}
 }
 
-func (routeData *RouteData) addEdgesToGraph(res [][]NodeId, timestamp time.Time) {
+func (routeData *RouteData) addEdgesToGraph(res [][]NodeId, flowId int, timestamp time.Time) {
 	//The starting layer is the source probe or considered as Hop 0
 	previousHop := res[0]
 
@@ -181,16 +283,19 @@ func (routeData *RouteData) addEdgesToGraph(res [][]NodeId, timestamp time.Time)
 				routeData.getOrCreateNode(src).totalOutboundUsage.Append(1.0, timestamp)
 				targetEdge.usage.Append(1.0, timestamp)
 				targetEdge.netUsage.Append(1.0/float64(len(nextHop)), timestamp)
+				targetEdge.recordFlow(flowId, timestamp)
 			}
 		}
 
 		previousHop = nextHop
 	}
 }
-func (routeData *RouteData) addCleanEdgesToGraph(res [][]NodeId, timestamp time.Time) {
+func (routeData *RouteData) addCleanEdgesToGraph(res [][]NodeId, responses []hopResponses, flowId int, timestamp time.Time) {
 	previousLayer := res[0]
+	// The probe is at index -1 since it does not have an entry in responses
+	previousIndex := -1
 
-	for _, nextHop := range res[1:] {
+	for index, nextHop := range res[1:] {
 		var nextLayer []NodeId
 		for _, id := range nextHop {
 			if !id.IsTimeout() {
@@ -220,10 +325,18 @@ func (routeData *RouteData) addCleanEdgesToGraph(res [][]NodeId, timestamp time.
 				routeData.getOrCreateNode(src).totalCleanOutboundUsage.Append(1.0, timestamp)
 				targetEdge.usage.Append(1.0, timestamp)
 				targetEdge.netUsage.Append(1.0/float64(len(nextHop)), timestamp)
+				targetEdge.recordFlow(flowId, timestamp)
+				targetEdge.replies.Append(float64(responses[index].replies[dst.Ip]), timestamp)
+				targetEdge.attempts.Append(float64(responses[index].attemptsFor(dst.Ip)), timestamp)
+
+				if delta, ok := rttDelta(responses, previousIndex, src, index, dst); ok {
+					targetEdge.rttDelta.Append(delta, timestamp)
+				}
 			}
 		}
 
 		previousLayer = nextLayer
+		previousIndex = index
 	}
 }
 
@@ -328,6 +441,7 @@ func checkMeasurementForErrors(measurement *measurement.Result) bool {
 		return true
 	}
 
-	//TODO Check for same IP showing up in a path at multiple points
+	// Results where the same IP shows up at multiple points in the path are still added to the graph, but the loop is
+	// recorded by detectLoops so the affected nodes and edges can be flagged
 	return false
 }
@@ -0,0 +1,49 @@
+package traceroute
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"math"
+)
+
+// GetUsageSeriesWithin gets the number of results which used this node in each bin overlapping the window
+func (node *Node) GetUsageSeriesWithin(window TimeRange) []util.SeriesPoint {
+	return node.totalUsage.SeriesWithin(window.Start, window.End)
+}
+
+// GetAverageRttSeriesWithin gets the average RTT of this node in each bin overlapping the window. Bins without any
+// replies have an average of NaN.
+func (node *Node) GetAverageRttSeriesWithin(window TimeRange) []util.SeriesPoint {
+	return node.averageRtt.SeriesWithin(window.Start, window.End)
+}
+
+// GetCleanOutboundUsageSeriesWithin gets the number of results leaving this node along a clean edge in each bin
+// overlapping the window
+func (node *Node) GetCleanOutboundUsageSeriesWithin(window TimeRange) []util.SeriesPoint {
+	return node.totalCleanOutboundUsage.SeriesWithin(window.Start, window.End)
+}
+
+// GetUsageSeriesWithin gets the number of results which used this edge in each bin overlapping the window
+func (edge *Edge) GetUsageSeriesWithin(window TimeRange) []util.SeriesPoint {
+	return edge.usage.SeriesWithin(window.Start, window.End)
+}
+
+// CoverageSeries divides each point of a usage series by the point of the total series covering the same bin. Bins
+// which are missing from the total or have a total of zero are NaN.
+func CoverageSeries(usage, total []util.SeriesPoint) []util.SeriesPoint {
+	totals := make(map[int64]float64, len(total))
+	for _, point := range total {
+		totals[point.Start.UnixNano()] = point.Value
+	}
+
+	coverage := make([]util.SeriesPoint, 0, len(usage))
+	for _, point := range usage {
+		value := math.NaN()
+		if totalValue := totals[point.Start.UnixNano()]; totalValue != 0 {
+			value = point.Value / totalValue
+		}
+
+		coverage = append(coverage, util.SeriesPoint{Start: point.Start, End: point.End, Value: value})
+	}
+
+	return coverage
+}
@@ -0,0 +1,73 @@
+package traceroute
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestEdgeCoverageSeries(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	appendResult := func(secondHop string) {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1, hops: [][]testReply{
+			replies(1.0, "10.0.1.1"),
+			replies(5.0, secondHop),
+			replies(10.0, testDestination),
+		}}
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	// Traffic moves from one second hop to another part way through
+	for i := 0; i < 20; i++ {
+		appendResult("10.0.2.1")
+	}
+	for i := 0; i < 20; i++ {
+		appendResult("10.0.3.1")
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	routeData.AlignStatisticsEndTime(time.Unix(int64(timestamp), 0))
+	window := StatisticsWindow(time.Unix(int64(timestamp), 0))
+
+	firstHop := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.1.1"))]
+	usage := firstHop.GetUsageSeriesWithin(window)
+
+	var total float64
+	for index, point := range usage {
+		total += point.Value
+		if index > 0 && !point.Start.Equal(usage[index-1].End) {
+			t.Errorf("Expected series to be ordered without gaps, but got %+v", usage)
+		}
+	}
+
+	if total != 40 {
+		t.Errorf("Expected series to sum to 40 results, but got %v", total)
+	}
+
+	for _, point := range firstHop.GetAverageRttSeriesWithin(window) {
+		if !math.IsNaN(point.Value) && point.Value != 1.0 {
+			t.Errorf("Expected average RTT of 1 or NaN for empty bins, but got %v", point.Value)
+		}
+	}
+
+	edge := routeData.CleanEdges[DirectedGraphEdge{
+		Start: WrapAddr(netip.MustParseAddr("10.0.1.1")),
+		Stop:  WrapAddr(netip.MustParseAddr("10.0.2.1")),
+	}]
+	coverage := CoverageSeries(edge.GetUsageSeriesWithin(window), firstHop.GetCleanOutboundUsageSeriesWithin(window))
+
+	var used []float64
+	for _, point := range coverage {
+		if !math.IsNaN(point.Value) {
+			used = append(used, point.Value)
+		}
+	}
+
+	if len(used) < 2 || used[0] != 1 || used[len(used)-1] != 0 {
+		t.Errorf("Expected coverage to fall from 1 to 0, but got %v", used)
+	}
+}
@@ -2,23 +2,67 @@ package traceroute
 
 import (
 	"fmt"
+	"github.com/DNS-OARC/ripeatlas/measurement"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
 	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
 	"log"
+	"math"
 	"net/netip"
+	"sort"
 	"time"
 )
 
 type TracerouteData struct {
 	inner map[probeDestinationPair]*RouteData
+	// Results which have already been added for each measurement so duplicate results can be ignored
+	seenResults map[int]*util.BoundedSet[resultKey]
+	Events      *Events
 }
 
 func MakeTracerouteData() TracerouteData {
 	return TracerouteData{
-		inner: make(map[probeDestinationPair]*RouteData),
+		inner:       make(map[probeDestinationPair]*RouteData),
+		seenResults: make(map[int]*util.BoundedSet[resultKey]),
+		Events:      MakeEvents(),
 	}
 }
 
+// Events holds the events detected while adding results to the traceroute data
+type Events struct {
+	PathChanges *util.EventLog[PathChange]
+	Loops       *util.EventLog[LoopEvent]
+	Anomalies   *util.EventLog[Anomaly]
+}
+
+func MakeEvents() *Events {
+	logSize := config.EventLogSize.GetInt()
+
+	return &Events{
+		PathChanges: util.MakeEventLog[PathChange](logSize),
+		Loops:       util.MakeEventLog[LoopEvent](logSize),
+		Anomalies:   util.MakeEventLog[Anomaly](logSize),
+	}
+}
+
+// resultKey identifies a single result within a measurement
+type resultKey struct {
+	probeId   int
+	timestamp int
+}
+
+// markResultSeen records that a result has been received and returns false if it has been received previously
+func (tracerouteData *TracerouteData) markResultSeen(measurement *measurement.Result) bool {
+	seen := util.MapGetOrCreate(tracerouteData.seenResults, measurement.MsmId(), func() *util.BoundedSet[resultKey] {
+		set := util.MakeBoundedSet[resultKey](config.DedupIndexSize.GetInt())
+		return &set
+	})
+
+	return seen.Insert(resultKey{
+		probeId:   measurement.PrbId(),
+		timestamp: measurement.Timestamp(),
+	})
+}
+
 func (tracerouteData *TracerouteData) EvictOutdatedData() {
 	var stats EvictionStats
 	evictionTime := time.Now()
@@ -37,6 +81,9 @@ func (tracerouteData *TracerouteData) DropMeasurementData(measurement int) {
 			delete(tracerouteData.inner, id)
 		}
 	}
+
+	// Forget which results were received so the measurement can be loaded again
+	delete(tracerouteData.seenResults, measurement)
 }
 
 func (tracerouteData *TracerouteData) getOrCreateRouteData(probeId int, destination netip.Addr) *RouteData {
@@ -44,6 +91,8 @@ func (tracerouteData *TracerouteData) getOrCreateRouteData(probeId int, destinat
 	key := probeDestinationPair{probeId, destination}
 	route := util.MapGetOrCreate(tracerouteData.inner, key, MakeRouteData)
 	route.probeId = probeId
+	route.destination = destination
+	route.events = tracerouteData.Events
 	return route
 }
 
@@ -56,19 +105,54 @@ func (tracerouteData *TracerouteData) GetRouteData(probe int, destination netip.
 	return routeData, ok
 }
 
+// Routes gets the data for every probe and destination pair
+func (tracerouteData *TracerouteData) Routes() (routes []*RouteData) {
+	for _, route := range tracerouteData.inner {
+		routes = append(routes, route)
+	}
+
+	return
+}
+
 type probeDestinationPair struct {
 	probeId     int
 	destination netip.Addr
 }
 
 type RouteData struct {
-	probeId    int
-	probeIps   map[netip.Addr]time.Time
-	routeUsage util.MovingSummation
-	Nodes      map[NodeId]*Node
-	Edges      map[DirectedGraphEdge]*Edge
-	CleanEdges map[DirectedGraphEdge]*Edge
-	Metrics    RouteUsageMetrics
+	probeId     int
+	destination netip.Addr
+	probeIps    map[netip.Addr]time.Time
+	routeUsage  util.MovingSummation
+	Nodes       map[NodeId]*Node
+	Edges       map[DirectedGraphEdge]*Edge
+	CleanEdges  map[DirectedGraphEdge]*Edge
+	Metrics     RouteUsageMetrics
+
+	// Paths holds each distinct hop sequence seen on this route keyed by Path.key
+	Paths       map[string]*PathRecord
+	pathTracker pathTracker
+	events      *Events
+
+	// Loops holds each distinct routing loop seen on this route keyed by Loop.key
+	Loops           map[string]*LoopRecord
+	loopingResults  util.MovingSummation
+	latestLoopCheck time.Time
+
+	// Tunnels holds each distinct MPLS tunnel seen on this route keyed by Tunnel.key
+	Tunnels map[string]*TunnelRecord
+
+	// Number of results which received a reply from the destination
+	reachedResults util.MovingSummation
+	// Sorted timestamps of the results used to update the lifespans of nodes and paths
+	lifespanResults []time.Time
+	// Timestamp of the latest result checked for anomalies
+	latestAnomalyCheck time.Time
+
+	// penultimateHops holds the penultimate hop of each result which reached the destination sorted by timestamp
+	penultimateHops []PenultimateHop
+	// links holds when each link between addresses at consecutive hops was last seen
+	links map[Link]time.Time
 }
 
 type EvictionStats struct {
@@ -95,20 +179,54 @@ func (routeData *RouteData) EvictToStatisticsPeriod(timestamp time.Time) Evictio
 		if node.lastUsed.Before(oldestAllowed) {
 			delete(routeData.Nodes, id)
 			stats.Nodes += 1
+		} else {
+			node.evictMplsLabelsBefore(oldestAllowed)
+			node.lifespan.evictBefore(oldestAllowed)
+			node.fastestRtt.evictBefore(oldestAllowed)
 		}
 	}
 	for id, edge := range routeData.Edges {
 		if edge.lastUsed.Before(oldestAllowed) {
 			delete(routeData.Edges, id)
 			stats.RawEdges += 1
+		} else {
+			edge.evictFlowsBefore(oldestAllowed)
 		}
 	}
 	for id, edge := range routeData.CleanEdges {
 		if edge.lastUsed.Before(oldestAllowed) {
 			delete(routeData.CleanEdges, id)
 			stats.CleanEdges += 1
+		} else {
+			edge.evictFlowsBefore(oldestAllowed)
+		}
+	}
+
+	for key, path := range routeData.Paths {
+		if path.lastUsed.Before(oldestAllowed) {
+			delete(routeData.Paths, key)
+		} else {
+			path.lifespan.evictBefore(oldestAllowed)
 		}
 	}
+	routeData.evictLifespanResultsBefore(oldestAllowed)
+	routeData.pathTracker.evictBefore(oldestAllowed)
+
+	for key, loop := range routeData.Loops {
+		if loop.lastSeen.Before(oldestAllowed) {
+			delete(routeData.Loops, key)
+		}
+	}
+
+	for key, tunnel := range routeData.Tunnels {
+		tunnel.seen.evictBefore(oldestAllowed)
+		if tunnel.seen.isEmpty() {
+			delete(routeData.Tunnels, key)
+		}
+	}
+
+	routeData.evictPenultimateHopsBefore(oldestAllowed)
+	routeData.evictLinksBefore(oldestAllowed)
 
 	for ip, lastSeen := range routeData.probeIps {
 		if lastSeen.Before(oldestAllowed) {
@@ -117,27 +235,63 @@ func (routeData *RouteData) EvictToStatisticsPeriod(timestamp time.Time) Evictio
 	}
 
 	routeData.AlignStatisticsEndTime(timestamp)
+	for _, node := range routeData.Nodes {
+		node.evictEmptyReplyTtls()
+	}
+
 	return stats
 }
 
 func (routeData *RouteData) AlignStatisticsEndTime(timestamp time.Time) {
 	routeData.routeUsage.IncrementUpperBound(timestamp)
+	routeData.loopingResults.IncrementUpperBound(timestamp)
+	routeData.reachedResults.IncrementUpperBound(timestamp)
 
 	for _, node := range routeData.Nodes {
 		node.averageRtt.IncrementUpperBound(timestamp)
+		node.rttDistribution.IncrementUpperBound(timestamp)
 		node.totalOutboundUsage.IncrementUpperBound(timestamp)
 		node.totalCleanOutboundUsage.IncrementUpperBound(timestamp)
 		node.totalUsage.IncrementUpperBound(timestamp)
+		node.loopUsage.IncrementUpperBound(timestamp)
+		node.forwardHops.IncrementUpperBound(timestamp)
+		node.returnHops.IncrementUpperBound(timestamp)
+		node.replies.IncrementUpperBound(timestamp)
+		node.attempts.IncrementUpperBound(timestamp)
+
+		for _, distribution := range node.replyTtls {
+			distribution.IncrementUpperBound(timestamp)
+		}
 	}
 
 	for _, edge := range routeData.Edges {
 		edge.usage.IncrementUpperBound(timestamp)
 		edge.netUsage.IncrementUpperBound(timestamp)
+		edge.loopUsage.IncrementUpperBound(timestamp)
+		edge.replies.IncrementUpperBound(timestamp)
+		edge.attempts.IncrementUpperBound(timestamp)
+		edge.rttDelta.IncrementUpperBound(timestamp)
 	}
 
 	for _, edge := range routeData.CleanEdges {
 		edge.usage.IncrementUpperBound(timestamp)
 		edge.netUsage.IncrementUpperBound(timestamp)
+		edge.loopUsage.IncrementUpperBound(timestamp)
+		edge.replies.IncrementUpperBound(timestamp)
+		edge.attempts.IncrementUpperBound(timestamp)
+		edge.rttDelta.IncrementUpperBound(timestamp)
+	}
+
+	for _, path := range routeData.Paths {
+		path.usage.IncrementUpperBound(timestamp)
+	}
+
+	for _, loop := range routeData.Loops {
+		loop.occurrences.IncrementUpperBound(timestamp)
+	}
+
+	for _, tunnel := range routeData.Tunnels {
+		tunnel.occurrences.IncrementUpperBound(timestamp)
 	}
 }
 
@@ -149,11 +303,45 @@ func MakeRouteData() *RouteData {
 		Edges:      make(map[DirectedGraphEdge]*Edge),
 		CleanEdges: make(map[DirectedGraphEdge]*Edge),
 		Metrics:    makeRouteUsageMetrics(),
+		Paths:      make(map[string]*PathRecord),
+
+		Loops:           make(map[string]*LoopRecord),
+		loopingResults:  util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		latestLoopCheck: time.Unix(0, 0),
+
+		Tunnels: make(map[string]*TunnelRecord),
+
+		reachedResults: util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+
+		links: make(map[Link]time.Time),
+	}
+}
+
+// countOf converts a moving summation of results into a count. Exponentially weighted summations give fractional
+// estimates, so they are rounded instead of truncated, and any non-zero estimate counts as at least one result so ratios
+// against it never divide by zero.
+func countOf(sum float64) int64 {
+	if !(sum > 0) {
+		return 0
 	}
+
+	return int64(math.Max(1, math.Round(sum)))
 }
 
 func (routeData *RouteData) GetTotalUsages() int64 {
-	return int64(routeData.routeUsage.Sum())
+	return countOf(routeData.routeUsage.Sum())
+}
+
+func (routeData *RouteData) GetTotalUsagesWithin(window TimeRange) int64 {
+	return countOf(routeData.routeUsage.SumWithin(window.Start, window.End))
+}
+
+func (routeData *RouteData) GetProbeId() int {
+	return routeData.probeId
+}
+
+func (routeData *RouteData) GetDestination() netip.Addr {
+	return routeData.destination
 }
 
 func (routeData *RouteData) GetProbeIps() (probeAddresses []netip.Addr) {
@@ -168,6 +356,17 @@ func (routeData *RouteData) IsEmpty() bool {
 	return len(routeData.Nodes) == 0
 }
 
+// GetDestinationRttWithin gets the average RTT of replies from the destination. The second return value is false if
+// the destination never replied within the window.
+func (routeData *RouteData) GetDestinationRttWithin(window TimeRange) (float64, bool) {
+	node, ok := routeData.Nodes[WrapAddr(routeData.destination)]
+	if !ok || node.GetNumUsagesWithin(window) == 0 {
+		return 0, false
+	}
+
+	return finiteAverage(node.GetAverageRttWithin(window))
+}
+
 func (routeData *RouteData) getOrCreateEdge(src, dst NodeId) *Edge {
 	edgeKey := DirectedGraphEdge{
 		Start: src,
@@ -186,20 +385,55 @@ type Node struct {
 	// service here.
 	averageRtt util.MovingAverage
 	lastUsed   time.Time
+	// Distribution of the RTT of replies, which excludes timeouts
+	rttDistribution util.MovingDistribution
 
 	// Used to determine the outboundCoverage of outbound edges
 	totalOutboundUsage      util.MovingSummation
 	totalCleanOutboundUsage util.MovingSummation
 	totalUsage              util.MovingSummation
+
+	// Number of results where this node was part of a routing loop
+	loopUsage util.MovingSummation
+
+	// MPLS labels quoted by this node along with when each was quoted
+	mplsLabels map[uint32]*usageRanges
+
+	// The hop number this node replied at and the number of hops replies are estimated to have taken back to the probe
+	forwardHops util.MovingAverage
+	returnHops  util.MovingAverage
+	// Number of replies received with each TTL
+	replyTtls map[int]util.MovingSummation
+
+	// Number of valid replies received from this node and the number of packets estimated to have been sent to it
+	replies  util.MovingSummation
+	attempts util.MovingSummation
+
+	// Periods during which every result of the route used this node
+	lifespan lifespanTracker
+
+	// The fastest reply from this node in each result, which is used as the baseline for finding anomalies
+	fastestRtt      rttSamples
+	baseline        rttBaseline
+	anomalySeverity AnomalySeverity
 }
 
 func MakeNode() *Node {
 	return &Node{
 		averageRtt:              util.MakeMovingAverage(config.StatisticsPeriod.GetDuration()),
 		lastUsed:                time.Unix(0, 0),
+		rttDistribution:         util.MakeMovingDistribution(config.StatisticsPeriod.GetDuration()),
 		totalOutboundUsage:      util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
 		totalCleanOutboundUsage: util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
 		totalUsage:              util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		loopUsage:               util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		mplsLabels:              make(map[uint32]*usageRanges),
+		forwardHops:             util.MakeMovingAverage(config.StatisticsPeriod.GetDuration()),
+		returnHops:              util.MakeMovingAverage(config.StatisticsPeriod.GetDuration()),
+		replyTtls:               make(map[int]util.MovingSummation),
+		replies:                 util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		attempts:                util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		fastestRtt:              makeRttSamples(config.AnomalyBaselineSamples.GetInt()),
 	}
 }
 
@@ -207,20 +441,45 @@ func (node *Node) GetAverageRtt() float64 {
 	return node.averageRtt.Average()
 }
 
+func (node *Node) GetAverageRttWithin(window TimeRange) float64 {
+	return node.averageRtt.AverageWithin(window.Start, window.End)
+}
+
+// GetRttDistributionWithin gets the distribution of reply RTTs within the window
+func (node *Node) GetRttDistributionWithin(window TimeRange) util.Distribution {
+	return node.rttDistribution.DistributionWithin(window.Start, window.End)
+}
+
 func (node *Node) GetLastUsed() time.Time {
 	return node.lastUsed
 }
 
 func (node *Node) GetNumUsages() int64 {
-	return int64(node.totalUsage.Sum())
+	return countOf(node.totalUsage.Sum())
+}
+
+func (node *Node) GetNumUsagesWithin(window TimeRange) int64 {
+	return countOf(node.totalUsage.SumWithin(window.Start, window.End))
 }
 
 func (node *Node) GetOutboundUsages() int64 {
-	return int64(node.totalOutboundUsage.Sum())
+	return countOf(node.totalOutboundUsage.Sum())
+}
+
+func (node *Node) GetOutboundUsagesWithin(window TimeRange) int64 {
+	return countOf(node.totalOutboundUsage.SumWithin(window.Start, window.End))
 }
 
 func (node *Node) GetCleanOutboundUsages() int64 {
-	return int64(node.totalCleanOutboundUsage.Sum())
+	return countOf(node.totalCleanOutboundUsage.Sum())
+}
+
+func (node *Node) GetCleanOutboundUsagesWithin(window TimeRange) int64 {
+	return countOf(node.totalCleanOutboundUsage.SumWithin(window.Start, window.End))
+}
+
+func (node *Node) GetLoopUsagesWithin(window TimeRange) int64 {
+	return countOf(node.loopUsage.SumWithin(window.Start, window.End))
 }
 
 type NodeId struct {
@@ -253,13 +512,31 @@ type Edge struct {
 	usage    util.MovingSummation
 	netUsage util.MovingSummation
 	lastUsed time.Time
+
+	// Number of results where this edge was part of a routing loop
+	loopUsage util.MovingSummation
+
+	// The Paris flow ids of results which used this edge along with when each was used
+	flows map[int]*usageRanges
+
+	// Replies from the end node and packets sent towards it in results which used this edge
+	replies  util.MovingSummation
+	attempts util.MovingSummation
+
+	// Distribution of the RTT added between the start and end nodes in each result which used this edge
+	rttDelta util.MovingDistribution
 }
 
 func MakeEdge() *Edge {
 	return &Edge{
-		usage:    util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
-		netUsage: util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
-		lastUsed: time.Unix(0, 0),
+		usage:     util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		netUsage:  util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		lastUsed:  time.Unix(0, 0),
+		loopUsage: util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		flows:     make(map[int]*usageRanges),
+		replies:   util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		attempts:  util.MakeMovingSummation(config.StatisticsPeriod.GetDuration()),
+		rttDelta:  util.MakeMovingDistribution(config.StatisticsPeriod.GetDuration()),
 	}
 }
 
@@ -268,9 +545,48 @@ func (edge *Edge) GetLastUsed() time.Time {
 }
 
 func (edge *Edge) GetUsage() int64 {
-	return int64(edge.usage.Sum())
+	return countOf(edge.usage.Sum())
+}
+
+func (edge *Edge) GetUsageWithin(window TimeRange) int64 {
+	return countOf(edge.usage.SumWithin(window.Start, window.End))
 }
 
 func (edge *Edge) GetNetUsage() float64 {
 	return edge.usage.Sum()
 }
+
+func (edge *Edge) GetNetUsageWithin(window TimeRange) float64 {
+	return edge.usage.SumWithin(window.Start, window.End)
+}
+
+func (edge *Edge) GetLoopUsagesWithin(window TimeRange) int64 {
+	return countOf(edge.loopUsage.SumWithin(window.Start, window.End))
+}
+
+func (edge *Edge) recordFlow(flowId int, timestamp time.Time) {
+	util.MapGetOrCreate(edge.flows, flowId, func() *usageRanges {
+		return new(usageRanges)
+	}).record(timestamp)
+}
+
+func (edge *Edge) evictFlowsBefore(oldestAllowed time.Time) {
+	for flowId, usage := range edge.flows {
+		usage.evictBefore(oldestAllowed)
+		if usage.isEmpty() {
+			delete(edge.flows, flowId)
+		}
+	}
+}
+
+// GetFlowIdsWithin gets the Paris flow ids which were used on this edge within the window
+func (edge *Edge) GetFlowIdsWithin(window TimeRange) (flowIds []int) {
+	for flowId, usage := range edge.flows {
+		if usage.usedWithin(window) {
+			flowIds = append(flowIds, flowId)
+		}
+	}
+
+	sort.Ints(flowIds)
+	return
+}
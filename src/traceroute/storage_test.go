@@ -0,0 +1,242 @@
+package traceroute
+
+import (
+	"encoding/json"
+	"github.com/DNS-OARC/ripeatlas/measurement"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+const testSource = "10.0.0.1"
+const testDestination = "192.0.2.1"
+
+// testReply is a single reply within a hop of a testResult. An address of "*" represents a timeout.
+type testReply struct {
+	from string
+	rtt  float64
+	// ttl is the TTL the reply arrived with, which defaults to 250 when zero
+	ttl int
+	// ittl is the quoted IP TTL, which is omitted when zero
+	ittl int
+	// labels is the MPLS label stack included as an ICMP extension when not empty
+	labels []MplsLabel
+}
+
+type testResult struct {
+	msmId     int
+	probeId   int
+	timestamp int
+	parisId   int
+	hops      [][]testReply
+}
+
+// replies creates a hop where each of the given addresses replied with the same RTT
+func replies(rtt float64, addresses ...string) (hop []testReply) {
+	for _, address := range addresses {
+		hop = append(hop, testReply{from: address, rtt: rtt})
+	}
+
+	return
+}
+
+func (result testResult) build(t *testing.T) *measurement.Result {
+	var hops []map[string]any
+	for index, hop := range result.hops {
+		var hopReplies []map[string]any
+		for _, reply := range hop {
+			if reply.from == "*" {
+				hopReplies = append(hopReplies, map[string]any{"x": "*"})
+			} else {
+				ttl := reply.ttl
+				if ttl == 0 {
+					ttl = 250
+				}
+
+				encodedReply := map[string]any{"from": reply.from, "rtt": reply.rtt, "ttl": ttl, "size": 28}
+				if reply.ittl != 0 {
+					encodedReply["ittl"] = reply.ittl
+				}
+
+				if len(reply.labels) > 0 {
+					var stack []map[string]any
+					for _, label := range reply.labels {
+						bottom := 0
+						if label.BottomOfStack {
+							bottom = 1
+						}
+
+						stack = append(stack, map[string]any{"label": label.Label, "exp": label.Exp, "s": bottom, "ttl": label.Ttl})
+					}
+
+					encodedReply["icmpext"] = map[string]any{
+						"version": 2,
+						"rfc4884": 1,
+						"obj":     []map[string]any{{"class": mplsObjectClass, "type": mplsObjectType, "mpls": stack}},
+					}
+				}
+
+				hopReplies = append(hopReplies, encodedReply)
+			}
+		}
+
+		hops = append(hops, map[string]any{"hop": index + 1, "result": hopReplies})
+	}
+
+	encoded, err := json.Marshal(map[string]any{
+		"type":      "traceroute",
+		"af":        4,
+		"msm_id":    result.msmId,
+		"prb_id":    result.probeId,
+		"timestamp": result.timestamp,
+		"paris_id":  result.parisId,
+		"src_addr":  testSource,
+		"dst_addr":  testDestination,
+		"dst_name":  testDestination,
+		"result":    hops,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed measurement.Result
+	if err = json.Unmarshal(encoded, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	return &parsed
+}
+
+func makeSimpleTestResult(msmId, probeId, timestamp int) testResult {
+	return testResult{
+		msmId:     msmId,
+		probeId:   probeId,
+		timestamp: timestamp,
+		parisId:   1,
+		hops: [][]testReply{
+			replies(1.0, "10.0.1.1"),
+			replies(5.0, "10.0.2.1"),
+			replies(10.0, testDestination),
+		},
+	}
+}
+
+func TestTracerouteDataIgnoresDuplicates(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	result := makeSimpleTestResult(1, 100, 1672531200).build(t)
+
+	if tracerouteData.AppendMeasurement(result) != ResultAppended {
+		t.Fatal("Expected first result to be accepted")
+	}
+
+	if tracerouteData.AppendMeasurement(result) != ResultDuplicate {
+		t.Fatal("Expected duplicate result to be ignored")
+	}
+
+	// The same probe and timestamp in a different measurement is a different result
+	if tracerouteData.AppendMeasurement(makeSimpleTestResult(2, 100, 1672531200).build(t)) != ResultAppended {
+		t.Fatal("Expected result from another measurement to be accepted")
+	}
+
+	routeData, ok := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	if !ok {
+		t.Fatal("Expected route data to be created")
+	}
+
+	routeData.AlignStatisticsEndTime(routeData.probeIps[netip.MustParseAddr(testSource)])
+	if usages := routeData.GetTotalUsages(); usages != 2 {
+		t.Fatalf("Expected 2 usages of route, but got %d", usages)
+	}
+}
+
+func TestTracerouteDataRejectsInvalidResults(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+
+	// Measurements to hostnames have a dst_name which is not an address
+	var result measurement.Result
+	encoded := `{"type":"traceroute","af":4,"msm_id":1,"prb_id":100,"timestamp":1672531200,"src_addr":"192.0.2.2","dst_addr":"198.51.100.1","dst_name":"example.com","result":[]}`
+	if err := json.Unmarshal([]byte(encoded), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if outcome := tracerouteData.AppendMeasurement(&result); outcome != ResultRejected {
+		t.Fatalf("Expected result with an unparsable destination to be rejected, but got %v", outcome)
+	}
+
+	if outcome := tracerouteData.AppendMeasurement(&result); outcome != ResultDuplicate {
+		t.Fatalf("Expected repeated result to be a duplicate, but got %v", outcome)
+	}
+
+	if routes := tracerouteData.Routes(); len(routes) != 0 {
+		t.Fatalf("Expected rejected result to not create a route, but found %d", len(routes))
+	}
+}
+
+func TestTracerouteDataForgetsDroppedMeasurements(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	result := makeSimpleTestResult(1, 100, 1672531200).build(t)
+
+	tracerouteData.AppendMeasurement(result)
+	tracerouteData.DropMeasurementData(1)
+
+	if tracerouteData.AppendMeasurement(result) != ResultAppended {
+		t.Fatal("Expected result to be accepted again after dropping its measurement")
+	}
+}
+
+func TestNodeRttDistribution(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	timestamp := 1672531200
+
+	for _, rtt := range []float64{4.0, 8.0, 6.0} {
+		result := testResult{msmId: 1, probeId: 100, timestamp: timestamp, parisId: 1}
+		result.hops = [][]testReply{
+			replies(rtt, "10.0.1.1"),
+			replies(0, "*"),
+			replies(10.0, testDestination),
+		}
+
+		tracerouteData.AppendMeasurement(result.build(t))
+		timestamp += 900
+	}
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := StatisticsWindow(time.Unix(int64(timestamp), 0))
+
+	distribution := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.1.1"))].GetRttDistributionWithin(window)
+	if distribution.Count != 3 || distribution.Min != 4.0 || distribution.Max != 8.0 {
+		t.Errorf("Expected 3 RTTs from 4 to 8, but got %d from %v to %v", distribution.Count, distribution.Min, distribution.Max)
+	}
+
+	// Timeouts do not have an RTT, so they should not add to the distribution
+	timeout := NodeId{Ip: netip.MustParseAddr("10.0.1.1"), TimeoutsSinceKnown: 1}
+	if distribution = routeData.Nodes[timeout].GetRttDistributionWithin(window); distribution.Count != 0 {
+		t.Errorf("Expected timeout to have no RTTs, but got %d", distribution.Count)
+	}
+}
+
+func TestCountOfDecayingSummation(t *testing.T) {
+	summation := util.MakeDecayingMovingSummation(time.Hour)
+	start := time.Unix(1672531200, 0)
+	for minute := 0; minute < 60; minute += 15 {
+		summation.Append(1, start.Add(time.Duration(minute)*time.Minute))
+	}
+
+	// The estimate for a short window is a fraction of a result, which still needs to count as a use so the usage of
+	// an edge never exceeds the usage of the route it belongs to
+	end := start.Add(45 * time.Minute)
+	sum := summation.SumWithin(end.Add(-5*time.Minute), end)
+	if sum <= 0 || sum >= 1 {
+		t.Fatalf("Expected a fractional estimate, but got %f", sum)
+	}
+
+	if count := countOf(sum); count != 1 {
+		t.Errorf("Expected a fractional estimate to count as 1 result, but got %d", count)
+	}
+
+	if count := countOf(summation.Sum()); count != int64(math.Round(summation.Sum())) {
+		t.Errorf("Expected the estimate of %f to be rounded, but got %d", summation.Sum(), count)
+	}
+}
@@ -0,0 +1,102 @@
+package traceroute
+
+import (
+	"github.com/DNS-OARC/ripeatlas/measurement/traceroute"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"github.com/jmeggitt/fastly_anycast_experiments.git/util"
+	"math"
+	"time"
+)
+
+// commonInitialTtls are the initial TTL values used by most operating systems and router vendors
+var commonInitialTtls = []int{32, 64, 128, 255}
+
+// estimateReturnHops estimates the number of hops a reply took to get back to the probe from the TTL it arrived with.
+// The initial TTL is guessed to be the smallest common initial TTL which is at least the reply TTL. The result is
+// comparable to the hop number the reply was received at, so a symmetric path gives the same value.
+func estimateReturnHops(replyTtl int) (int, bool) {
+	if replyTtl <= 0 {
+		return 0, false
+	}
+
+	for _, initialTtl := range commonInitialTtls {
+		if replyTtl <= initialTtl {
+			return initialTtl - replyTtl + 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// recordReplyTtl adds the TTL of a reply received at the given hop number to the distributions of the node
+func (node *Node) recordReplyTtl(reply *traceroute.Reply, hopNumber int, timestamp time.Time) {
+	if reply.X() != "" {
+		return
+	}
+
+	node.forwardHops.Append(float64(hopNumber), timestamp)
+
+	returnHops, ok := estimateReturnHops(reply.Ttl())
+	if !ok {
+		return
+	}
+
+	distribution := util.MapGetOrCreate(node.replyTtls, reply.Ttl(), func() util.MovingSummation {
+		return util.MakeMovingSummation(config.StatisticsPeriod.GetDuration())
+	})
+
+	distribution.Append(1.0, timestamp)
+	node.returnHops.Append(float64(returnHops), timestamp)
+}
+
+// evictEmptyReplyTtls removes TTL values which no longer have any replies within the statistics period
+func (node *Node) evictEmptyReplyTtls() {
+	for ttl, distribution := range node.replyTtls {
+		if distribution.Sum() == 0 {
+			delete(node.replyTtls, ttl)
+		}
+	}
+}
+
+// GetReplyTtlsWithin gets the number of replies received with each TTL value within the window
+func (node *Node) GetReplyTtlsWithin(window TimeRange) map[int]int64 {
+	counts := make(map[int]int64)
+	for ttl, distribution := range node.replyTtls {
+		if count := countOf(distribution.SumWithin(window.Start, window.End)); count > 0 {
+			counts[ttl] = count
+		}
+	}
+
+	return counts
+}
+
+// GetForwardHopsWithin gets the average hop number this node replied at within the window
+func (node *Node) GetForwardHopsWithin(window TimeRange) (float64, bool) {
+	return finiteAverage(node.forwardHops.AverageWithin(window.Start, window.End))
+}
+
+// GetReturnHopsWithin gets the average estimated number of hops replies from this node took to return to the probe
+func (node *Node) GetReturnHopsWithin(window TimeRange) (float64, bool) {
+	return finiteAverage(node.returnHops.AverageWithin(window.Start, window.End))
+}
+
+// IsAsymmetricWithin checks if the estimated return path of this node differs from the forward path by more than
+// ASYMMETRIC_PATH_THRESHOLD hops
+func (node *Node) IsAsymmetricWithin(window TimeRange) bool {
+	forwardHops, hasForward := node.GetForwardHopsWithin(window)
+	returnHops, hasReturn := node.GetReturnHopsWithin(window)
+	if !hasForward || !hasReturn {
+		return false
+	}
+
+	return math.Abs(returnHops-forwardHops) > config.AsymmetricPathThreshold.GetFloat()
+}
+
+// finiteAverage filters out the NaN produced when averaging a window without any values
+func finiteAverage(average float64) (float64, bool) {
+	if math.IsNaN(average) || math.IsInf(average, 0) {
+		return 0, false
+	}
+
+	return average, true
+}
@@ -0,0 +1,71 @@
+package traceroute
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEstimateReturnHops(t *testing.T) {
+	cases := map[int]int{
+		255: 1,
+		250: 6,
+		128: 1,
+		120: 9,
+		64:  1,
+		57:  8,
+	}
+
+	for replyTtl, expected := range cases {
+		if hops, ok := estimateReturnHops(replyTtl); !ok || hops != expected {
+			t.Errorf("Expected reply TTL %d to take %d hops, but got %d", replyTtl, expected, hops)
+		}
+	}
+
+	if _, ok := estimateReturnHops(0); ok {
+		t.Error("Expected a missing reply TTL to not have an estimate")
+	}
+}
+
+func TestAsymmetricReturnPath(t *testing.T) {
+	tracerouteData := MakeTracerouteData()
+	result := testResult{
+		msmId:     1,
+		probeId:   100,
+		timestamp: 1672531200,
+		parisId:   1,
+		hops: [][]testReply{
+			{{from: "10.0.1.1", rtt: 1.0, ttl: 255}},
+			{{from: "10.0.2.1", rtt: 2.0, ttl: 249}, {from: "10.0.2.1", rtt: 2.0, ttl: 249}},
+			{{from: testDestination, rtt: 3.0, ttl: 61}},
+		},
+	}
+
+	tracerouteData.AppendMeasurement(result.build(t))
+
+	routeData, _ := tracerouteData.GetRouteData(100, netip.MustParseAddr(testDestination))
+	window := StatisticsWindow(time.Unix(int64(result.timestamp), 0))
+
+	symmetric := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.1.1"))]
+	if symmetric.IsAsymmetricWithin(window) {
+		t.Error("Expected first hop to have a symmetric return path")
+	}
+
+	asymmetric := routeData.Nodes[WrapAddr(netip.MustParseAddr("10.0.2.1"))]
+	if returnHops, _ := asymmetric.GetReturnHopsWithin(window); returnHops != 7 {
+		t.Errorf("Expected 7 return hops, but got %f", returnHops)
+	}
+
+	if !asymmetric.IsAsymmetricWithin(window) {
+		t.Error("Expected second hop to have an asymmetric return path")
+	}
+
+	if ttls := asymmetric.GetReplyTtlsWithin(window); !reflect.DeepEqual(ttls, map[int]int64{249: 2}) {
+		t.Errorf("Expected two replies with a TTL of 249, but got %v", ttls)
+	}
+
+	if destination := routeData.Nodes[WrapAddr(netip.MustParseAddr(testDestination))]; destination.IsAsymmetricWithin(window) {
+		t.Error("Expected destination to have a symmetric return path")
+	}
+}
@@ -0,0 +1,101 @@
+package traceroute
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"sort"
+	"time"
+)
+
+// usageRanges records when something, such as a flow id on an edge, was used as a sorted list of non-overlapping time
+// ranges. Uses which are closer together than a bin of the moving statistics are joined into one range, so something
+// used by every result only needs a single range. Like the moving statistics, windows shorter than a bin may include
+// uses from just outside the window.
+type usageRanges struct {
+	ranges []TimeRange
+}
+
+// usageMergeGap is the longest gap between two uses which are joined into the same range
+func usageMergeGap() time.Duration {
+	binCount := config.SummationBinCount.GetInt()
+	if binCount < 1 {
+		binCount = 1
+	}
+
+	return config.StatisticsPeriod.GetDuration() / time.Duration(binCount)
+}
+
+// record adds a use at the given time. Uses may be recorded in any order.
+func (usage *usageRanges) record(timestamp time.Time) {
+	gap := usageMergeGap()
+
+	// Find the first range which ends at or after the timestamp
+	index := sort.Search(len(usage.ranges), func(i int) bool {
+		return !usage.ranges[i].End.Before(timestamp)
+	})
+
+	if index < len(usage.ranges) && !usage.ranges[index].Start.After(timestamp) {
+		return
+	}
+
+	joinsPrevious := index > 0 && timestamp.Sub(usage.ranges[index-1].End) <= gap
+	joinsNext := index < len(usage.ranges) && usage.ranges[index].Start.Sub(timestamp) <= gap
+
+	switch {
+	case joinsPrevious && joinsNext:
+		usage.ranges[index-1].End = usage.ranges[index].End
+		usage.ranges = append(usage.ranges[:index], usage.ranges[index+1:]...)
+	case joinsPrevious:
+		usage.ranges[index-1].End = timestamp
+	case joinsNext:
+		usage.ranges[index].Start = timestamp
+	default:
+		usage.ranges = append(usage.ranges, TimeRange{})
+		copy(usage.ranges[index+1:], usage.ranges[index:])
+		usage.ranges[index] = TimeRange{Start: timestamp, End: timestamp}
+	}
+}
+
+// evictBefore forgets uses before the given time
+func (usage *usageRanges) evictBefore(oldestAllowed time.Time) {
+	index := 0
+	for index < len(usage.ranges) && usage.ranges[index].End.Before(oldestAllowed) {
+		index++
+	}
+
+	usage.ranges = usage.ranges[index:]
+	if len(usage.ranges) > 0 && usage.ranges[0].Start.Before(oldestAllowed) {
+		usage.ranges[0].Start = oldestAllowed
+	}
+}
+
+func (usage *usageRanges) isEmpty() bool {
+	return len(usage.ranges) == 0
+}
+
+// usedWithin checks if there was a use within the window
+func (usage *usageRanges) usedWithin(window TimeRange) bool {
+	index := sort.Search(len(usage.ranges), func(i int) bool {
+		return !usage.ranges[i].End.Before(window.Start)
+	})
+
+	return index < len(usage.ranges) && !usage.ranges[index].Start.After(window.End)
+}
+
+// lastUsedWithin gets the time of the latest use within the window. The second return value is false if there was no
+// use within the window.
+func (usage *usageRanges) lastUsedWithin(window TimeRange) (time.Time, bool) {
+	// Find the last range which starts at or before the end of the window
+	index := sort.Search(len(usage.ranges), func(i int) bool {
+		return usage.ranges[i].Start.After(window.End)
+	}) - 1
+
+	if index < 0 || usage.ranges[index].End.Before(window.Start) {
+		return time.Time{}, false
+	}
+
+	if usage.ranges[index].End.After(window.End) {
+		return window.End, true
+	}
+
+	return usage.ranges[index].End, true
+}
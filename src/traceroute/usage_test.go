@@ -0,0 +1,49 @@
+package traceroute
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageRanges(t *testing.T) {
+	gap := usageMergeGap()
+	base := time.Unix(1672531200, 0)
+	at := func(gaps float64) time.Time {
+		return base.Add(time.Duration(gaps * float64(gap)))
+	}
+
+	var usage usageRanges
+	// Out of order uses which are far apart stay separate until a use in between joins them
+	usage.record(at(10))
+	usage.record(at(0))
+	usage.record(at(5))
+	usage.record(at(5.5))
+	if len(usage.ranges) != 3 {
+		t.Fatalf("Expected 3 ranges, but got %+v", usage.ranges)
+	}
+
+	usage.record(at(1))
+	usage.record(at(2))
+	usage.record(at(3))
+	usage.record(at(4))
+	if len(usage.ranges) != 2 || !usage.ranges[0].Start.Equal(at(0)) || !usage.ranges[0].End.Equal(at(5.5)) {
+		t.Fatalf("Expected the uses up until 5.5 gaps to be joined, but got %+v", usage.ranges)
+	}
+
+	if usage.usedWithin(TimeRange{Start: at(6), End: at(9)}) {
+		t.Error("Expected no use between the ranges")
+	}
+
+	if lastUsed, ok := usage.lastUsedWithin(TimeRange{Start: at(9), End: at(11)}); !ok || !lastUsed.Equal(at(10)) {
+		t.Errorf("Expected the latest use to be found, but got %v", lastUsed)
+	}
+
+	if lastUsed, ok := usage.lastUsedWithin(TimeRange{Start: at(1), End: at(7)}); !ok || !lastUsed.Equal(at(5.5)) {
+		t.Errorf("Expected the latest use before the end of the window, but got %v", lastUsed)
+	}
+
+	usage.evictBefore(at(7))
+	if len(usage.ranges) != 1 || usage.usedWithin(TimeRange{Start: at(0), End: at(6)}) {
+		t.Errorf("Expected only the latest range to remain, but got %+v", usage.ranges)
+	}
+}
@@ -0,0 +1,105 @@
+package util
+
+// BoundedSet is a set which holds at most a fixed number of keys. Once the set is full, inserting a new key evicts the
+// oldest key in the set. Memory is only allocated as keys are inserted, so the capacity can be much larger than the
+// number of keys the set usually holds.
+type BoundedSet[K comparable] struct {
+	keys     map[K]struct{}
+	order    []K
+	next     int
+	capacity int
+}
+
+func MakeBoundedSet[K comparable](capacity int) BoundedSet[K] {
+	return BoundedSet[K]{
+		keys:     make(map[K]struct{}),
+		capacity: capacity,
+	}
+}
+
+func (set *BoundedSet[K]) Contains(key K) bool {
+	_, ok := set.keys[key]
+	return ok
+}
+
+// Insert adds a key to the set. If the key was already present, false is returned and the set is left unchanged.
+func (set *BoundedSet[K]) Insert(key K) bool {
+	if set.Contains(key) {
+		return false
+	}
+
+	if len(set.order) < set.capacity {
+		set.order = append(set.order, key)
+	} else if len(set.order) > 0 {
+		// The set is full, so replace the oldest key
+		delete(set.keys, set.order[set.next])
+		set.order[set.next] = key
+		set.next = (set.next + 1) % len(set.order)
+	} else {
+		// A set without any capacity never holds any keys
+		return true
+	}
+
+	set.keys[key] = struct{}{}
+	return true
+}
+
+func (set *BoundedSet[K]) Len() int {
+	return len(set.keys)
+}
+
+// EventLog is a bounded log of events. Once the log reaches its capacity, appending an event removes the oldest event.
+// Every event is assigned an increasing sequence number so readers can find the events added since they last read the
+// log.
+type EventLog[T any] struct {
+	events []T
+	// Index in events of the oldest event once the log is full
+	start int
+	// Sequence number which will be assigned to the next event
+	nextSequence uint64
+}
+
+func MakeEventLog[T any](capacity int) *EventLog[T] {
+	return &EventLog[T]{
+		events: make([]T, 0, capacity),
+	}
+}
+
+func (eventLog *EventLog[T]) Append(event T) {
+	eventLog.nextSequence += 1
+
+	if len(eventLog.events) < cap(eventLog.events) {
+		eventLog.events = append(eventLog.events, event)
+	} else if len(eventLog.events) > 0 {
+		eventLog.events[eventLog.start] = event
+		eventLog.start = (eventLog.start + 1) % len(eventLog.events)
+	}
+}
+
+// Events returns all events in the log from oldest to newest
+func (eventLog *EventLog[T]) Events() []T {
+	events := make([]T, 0, len(eventLog.events))
+	events = append(events, eventLog.events[eventLog.start:]...)
+	return append(events, eventLog.events[:eventLog.start]...)
+}
+
+// Since returns the events with a sequence number of at least the given sequence number along with the sequence number
+// to use when next reading the log. Events which have already been removed from the log are skipped.
+func (eventLog *EventLog[T]) Since(sequence uint64) ([]T, uint64) {
+	if sequence >= eventLog.nextSequence {
+		return nil, eventLog.nextSequence
+	}
+
+	available := uint64(len(eventLog.events))
+	if eventLog.nextSequence-sequence < available {
+		available = eventLog.nextSequence - sequence
+	}
+
+	events := eventLog.Events()
+	return events[uint64(len(events))-available:], eventLog.nextSequence
+}
+
+// NextSequence is the sequence number that will be assigned to the next event added to the log
+func (eventLog *EventLog[T]) NextSequence() uint64 {
+	return eventLog.nextSequence
+}
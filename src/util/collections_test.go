@@ -0,0 +1,38 @@
+package util
+
+import "testing"
+
+func TestBoundedSetEvictsOldest(t *testing.T) {
+	set := MakeBoundedSet[int](3)
+	for key := 0; key < 5; key++ {
+		if !set.Insert(key) {
+			t.Errorf("Expected %d to be new", key)
+		}
+	}
+
+	if set.Insert(4) {
+		t.Error("Expected inserting a key twice to be ignored")
+	}
+
+	if set.Len() != 3 {
+		t.Errorf("Expected the set to stay at its capacity, got %d keys", set.Len())
+	}
+
+	for key := 0; key < 5; key++ {
+		if expected := key >= 2; set.Contains(key) != expected {
+			t.Errorf("Expected Contains(%d) to be %t", key, expected)
+		}
+	}
+}
+
+func TestBoundedSetGrowsOnDemand(t *testing.T) {
+	set := MakeBoundedSet[int](1 << 20)
+	if cap(set.order) != 0 {
+		t.Errorf("Expected an empty set to not reserve its capacity, got %d", cap(set.order))
+	}
+
+	set.Insert(1)
+	if cap(set.order) >= 1<<20 {
+		t.Errorf("Expected the set to grow with its keys, got a capacity of %d", cap(set.order))
+	}
+}
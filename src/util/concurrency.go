@@ -3,6 +3,7 @@ package util
 import (
 	"runtime"
 	"sync/atomic"
+	"time"
 )
 
 // ArcCloser is an Atomic Reference Counter that assists in closing shared in a simple and readable manor once all
@@ -69,3 +70,35 @@ func MakeWorkGroup[I, O any](buffered int, handler func(I, chan O)) (chan I, <-c
 	inputChannel := make(chan I, buffered)
 	return inputChannel, MakeWorkGroupWith(buffered, inputChannel, handler)
 }
+
+// Backoff produces exponentially increasing delays between attempts of an operation which may repeatedly fail. The
+// delay starts at the minimum and doubles after every attempt until it reaches the maximum.
+type Backoff struct {
+	min, max time.Duration
+	next     time.Duration
+}
+
+func MakeBackoff(min, max time.Duration) Backoff {
+	return Backoff{
+		min:  min,
+		max:  max,
+		next: min,
+	}
+}
+
+// Next returns the delay to wait before the next attempt
+func (backoff *Backoff) Next() time.Duration {
+	delay := backoff.next
+
+	backoff.next *= 2
+	if backoff.next > backoff.max {
+		backoff.next = backoff.max
+	}
+
+	return delay
+}
+
+// Reset returns the delay to the minimum after an attempt succeeds
+func (backoff *Backoff) Reset() {
+	backoff.next = backoff.min
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"math"
+	"time"
+)
+
+// decayingMovingSummation keeps an exponentially weighted sum of the observed values. The weight of a value falls by a
+// factor of e every timeConstant, which is half of the period so the weighted values have the same average age as values
+// spread evenly over the period. Only a single sum is stored, so windows are estimated from it instead of being exact.
+type decayingMovingSummation struct {
+	period       time.Duration
+	timeConstant time.Duration
+	upperBound   time.Time
+	// Timestamp of the oldest value which has been observed
+	first    time.Time
+	weighted float64
+	empty    bool
+}
+
+// decay gets the weight of a value the given duration before the upper bound
+func (decaying *decayingMovingSummation) decay(age time.Duration) float64 {
+	return math.Exp(-age.Seconds() / decaying.timeConstant.Seconds())
+}
+
+func (decaying *decayingMovingSummation) IncrementUpperBound(timestamp time.Time) {
+	if !timestamp.After(decaying.upperBound) {
+		return
+	}
+
+	decaying.weighted *= decaying.decay(timestamp.Sub(decaying.upperBound))
+	decaying.upperBound = timestamp
+}
+
+func (decaying *decayingMovingSummation) Append(value float64, timestamp time.Time) {
+	decaying.IncrementUpperBound(timestamp)
+
+	// Values which would no longer fall within the period are dropped like they are for binned statistics
+	age := decaying.upperBound.Sub(timestamp)
+	if age > decaying.period {
+		return
+	}
+
+	decaying.weighted += value * decaying.decay(age)
+	if decaying.empty || timestamp.Before(decaying.first) {
+		decaying.first = timestamp
+		decaying.empty = false
+	}
+}
+
+// coveredSpan gets how much of the period has been observed
+func (decaying *decayingMovingSummation) coveredSpan() time.Duration {
+	if decaying.empty {
+		return 0
+	}
+
+	span := decaying.upperBound.Sub(decaying.first)
+	if span > decaying.period {
+		return decaying.period
+	}
+
+	return span
+}
+
+// Sum estimates the summation over the period by assuming the values were spread evenly over the observed time. The
+// weighted sum is divided by the total weight of the observed time and scaled back up to the covered part of the
+// period, so a steady stream of values gives the same sum as an exact window would.
+func (decaying *decayingMovingSummation) Sum() float64 {
+	if decaying.empty {
+		return 0
+	}
+
+	observed := decaying.upperBound.Sub(decaying.first).Seconds()
+	timeConstant := decaying.timeConstant.Seconds()
+	totalWeight := timeConstant * (1 - math.Exp(-observed/timeConstant))
+
+	// A burst of values at a single point in time has no decay to correct for
+	if totalWeight <= 0 {
+		return decaying.weighted
+	}
+
+	return decaying.weighted * decaying.coveredSpan().Seconds() / totalWeight
+}
+
+// SumWithin estimates the summation within the window as the share of Sum given by the fraction of the covered period
+// which overlaps the window
+func (decaying *decayingMovingSummation) SumWithin(start, end time.Time) float64 {
+	span := decaying.coveredSpan()
+	coveredStart := decaying.upperBound.Add(-span)
+
+	if span == 0 {
+		if !decaying.upperBound.Before(start) && !decaying.upperBound.After(end) {
+			return decaying.Sum()
+		}
+
+		return 0
+	}
+
+	if start.Before(coveredStart) {
+		start = coveredStart
+	}
+
+	if end.After(decaying.upperBound) {
+		end = decaying.upperBound
+	}
+
+	if !end.After(start) {
+		return 0
+	}
+
+	return decaying.Sum() * end.Sub(start).Seconds() / span.Seconds()
+}
+
+// SeriesWithin gives a single point covering the part of the window which has been observed since the exponentially
+// weighted sum cannot be split back up over time
+func (decaying *decayingMovingSummation) SeriesWithin(start, end time.Time) []SeriesPoint {
+	coveredStart := decaying.upperBound.Add(-decaying.coveredSpan())
+	if decaying.empty || end.Before(coveredStart) || start.After(decaying.upperBound) {
+		return nil
+	}
+
+	if start.Before(coveredStart) {
+		start = coveredStart
+	}
+
+	if end.After(decaying.upperBound) {
+		end = decaying.upperBound
+	}
+
+	return []SeriesPoint{{Start: start, End: end, Value: decaying.SumWithin(start, end)}}
+}
+
+// MakeDecayingMovingSummation creates an exponentially weighted summation over the period
+func MakeDecayingMovingSummation(period time.Duration) MovingSummation {
+	return &decayingMovingSummation{
+		period:       period,
+		timeConstant: period / 2,
+		upperBound:   time.Unix(0, 0),
+		empty:        true,
+	}
+}
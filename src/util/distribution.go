@@ -0,0 +1,243 @@
+package util
+
+import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// sketchBucketsPerDecade sets the resolution of quantile estimates. Each bucket covers about 10% of its lower bound.
+	sketchBucketsPerDecade = 24
+	// sketchMinValue is the upper bound of the lowest bucket, which holds every value at or below it
+	sketchMinValue = 0.01
+	// histogramBucketsPerDecade sets the resolution of the compact histogram returned by Distribution.Histogram
+	histogramBucketsPerDecade = 3
+)
+
+// sketchBucket finds the bucket of the quantile sketch a value belongs to. Bucket i holds values in the range
+// (sketchMinValue * 10^((i-1)/sketchBucketsPerDecade), sketchMinValue * 10^(i/sketchBucketsPerDecade)]. Negative values
+// use the negated bucket of their magnitude, and values close to zero all fall in bucket 0.
+func sketchBucket(value float64) int {
+	if value < -sketchMinValue {
+		return -sketchBucket(-value)
+	}
+
+	if value <= sketchMinValue {
+		return 0
+	}
+
+	return int(math.Ceil(math.Log10(value/sketchMinValue)*sketchBucketsPerDecade - 1e-9))
+}
+
+func sketchUpperBound(bucket int) float64 {
+	return sketchMinValue * math.Pow(10, float64(bucket)/sketchBucketsPerDecade)
+}
+
+// sketchEstimate gets the value used to represent every value in a bucket
+func sketchEstimate(bucket int) float64 {
+	if bucket < 0 {
+		return -sketchEstimate(-bucket)
+	}
+
+	if bucket == 0 {
+		return 0
+	}
+
+	// Use the geometric midpoint of the bucket
+	return sketchUpperBound(bucket) * math.Pow(10, -0.5/sketchBucketsPerDecade)
+}
+
+// Distribution summarizes a group of values. Min, Max, Mean and StdDev are exact while quantiles and histograms are
+// estimated from a logarithmic sketch.
+type Distribution struct {
+	Count    int64
+	Min, Max float64
+	sum      float64
+	sumSq    float64
+	buckets  map[int]int64
+}
+
+func (distribution *Distribution) add(value float64) {
+	if distribution.Count == 0 || value < distribution.Min {
+		distribution.Min = value
+	}
+
+	if distribution.Count == 0 || value > distribution.Max {
+		distribution.Max = value
+	}
+
+	if distribution.buckets == nil {
+		distribution.buckets = make(map[int]int64)
+	}
+
+	distribution.Count += 1
+	distribution.sum += value
+	distribution.sumSq += value * value
+	distribution.buckets[sketchBucket(value)] += 1
+}
+
+func (distribution *Distribution) merge(other *Distribution) {
+	if other.Count == 0 {
+		return
+	}
+
+	if distribution.Count == 0 || other.Min < distribution.Min {
+		distribution.Min = other.Min
+	}
+
+	if distribution.Count == 0 || other.Max > distribution.Max {
+		distribution.Max = other.Max
+	}
+
+	if distribution.buckets == nil {
+		distribution.buckets = make(map[int]int64)
+	}
+
+	distribution.Count += other.Count
+	distribution.sum += other.sum
+	distribution.sumSq += other.sumSq
+	for bucket, count := range other.buckets {
+		distribution.buckets[bucket] += count
+	}
+}
+
+// Mean gets the average of the values. It is NaN if the distribution is empty.
+func (distribution Distribution) Mean() float64 {
+	return distribution.sum / float64(distribution.Count)
+}
+
+// StdDev gets the population standard deviation of the values. It is NaN if the distribution is empty.
+func (distribution Distribution) StdDev() float64 {
+	mean := distribution.Mean()
+	// Rounding errors can make the variance slightly negative when every value is the same
+	return math.Sqrt(math.Max(0, distribution.sumSq/float64(distribution.Count)-mean*mean))
+}
+
+func (distribution Distribution) sortedBuckets() []int {
+	buckets := make([]int, 0, len(distribution.buckets))
+	for bucket := range distribution.buckets {
+		buckets = append(buckets, bucket)
+	}
+
+	sort.Ints(buckets)
+	return buckets
+}
+
+// Quantile estimates the value below which the fraction q of values fall. It is NaN if the distribution is empty.
+func (distribution Distribution) Quantile(q float64) float64 {
+	if distribution.Count == 0 {
+		return math.NaN()
+	}
+
+	rank := int64(math.Ceil(q * float64(distribution.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for _, bucket := range distribution.sortedBuckets() {
+		seen += distribution.buckets[bucket]
+		if seen < rank {
+			continue
+		}
+
+		// Never report a value outside the observed range
+		return math.Min(distribution.Max, math.Max(distribution.Min, sketchEstimate(bucket)))
+	}
+
+	return distribution.Max
+}
+
+// HistogramBucket counts the values above the upper bound of the previous bucket and up to its own upper bound
+type HistogramBucket struct {
+	UpperBound float64
+	Count      int64
+}
+
+// Histogram groups the values into logarithmic buckets with histogramBucketsPerDecade buckets per power of 10. Empty
+// buckets below the smallest and above the largest value are left out. Negative values are counted in the first bucket
+// since histograms are only meant for values which can not be negative, such as RTTs.
+func (distribution Distribution) Histogram() (histogram []HistogramBucket) {
+	const ratio = sketchBucketsPerDecade / histogramBucketsPerDecade
+	previous := 0
+
+	for _, bucket := range distribution.sortedBuckets() {
+		count := distribution.buckets[bucket]
+		if bucket < 0 {
+			bucket = 0
+		}
+
+		// Round up to the histogram bucket whose upper bound is at or above the sketch bucket
+		coarse := (bucket + ratio - 1) / ratio * ratio
+
+		if len(histogram) > 0 && coarse == previous {
+			histogram[len(histogram)-1].Count += count
+			continue
+		}
+
+		// Fill in any empty buckets between this bucket and the previous one
+		if len(histogram) > 0 {
+			for next := previous + ratio; next < coarse; next += ratio {
+				histogram = append(histogram, HistogramBucket{UpperBound: sketchUpperBound(next)})
+			}
+		}
+
+		histogram = append(histogram, HistogramBucket{UpperBound: sketchUpperBound(coarse), Count: count})
+		previous = coarse
+	}
+
+	return
+}
+
+// MovingDistribution is a moving statistic which keeps the distribution of the observed values
+type MovingDistribution interface {
+	MovingStatistic
+	// DistributionWithin merges the values which may fall within the given time window. Values are grouped into the
+	// same bins as MovingSummation, so values from bins which partially overlap the window are included.
+	DistributionWithin(start, end time.Time) Distribution
+}
+
+type binnedMovingDistribution struct {
+	// Bins are only allocated once a value is added to them
+	timeBins[*Distribution]
+}
+
+func (binned *binnedMovingDistribution) Append(value float64, timestamp time.Time) {
+	binned.IncrementUpperBound(timestamp)
+
+	targetBin := binned.binFor(timestamp)
+	if targetBin < 0 || targetBin >= len(binned.bins) {
+		return
+	}
+
+	if binned.bins[targetBin] == nil {
+		binned.bins[targetBin] = new(Distribution)
+	}
+
+	binned.bins[targetBin].add(value)
+}
+
+func (binned *binnedMovingDistribution) DistributionWithin(start, end time.Time) (distribution Distribution) {
+	for index, bin := range binned.bins {
+		if bin == nil || !binned.binOverlaps(index, start, end) {
+			continue
+		}
+
+		distribution.merge(bin)
+	}
+
+	return
+}
+
+// MakeBinnedMovingDistribution creates a distribution which splits the period into the given number of bins
+func MakeBinnedMovingDistribution(period time.Duration, binCount int) MovingDistribution {
+	return &binnedMovingDistribution{makeTimeBins[*Distribution](period, binCount)}
+}
+
+// MakeMovingDistribution creates a distribution using the bin count set in the config. Distributions are binned
+// regardless of the statistics mode since the minimum and maximum of a distribution cannot be exponentially weighted.
+func MakeMovingDistribution(period time.Duration) MovingDistribution {
+	return MakeBinnedMovingDistribution(period, config.DistributionBinCount.GetInt())
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"sync/atomic"
 	"time"
 )
@@ -14,6 +15,12 @@ func CloseAndLogErrors(source string, closer io.Closer) {
 	}
 }
 
+func RemoveAndLogErrors(source string, path string) {
+	if err := os.Remove(path); err != nil {
+		log.Println(source, err)
+	}
+}
+
 // ProgressCounter is a helper for periodically logging the progress that has been made doing a task. It is completely
 // thread safe so pointers can be shared between multiple worker threads without any issues.
 type ProgressCounter struct {
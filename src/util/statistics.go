@@ -1,7 +1,10 @@
 package util
 
 import (
+	"github.com/jmeggitt/fastly_anycast_experiments.git/config"
 	"log"
+	"math"
+	"sync"
 	"time"
 )
 
@@ -17,57 +20,135 @@ type MovingStatistic interface {
 	Append(value float64, timestamp time.Time)
 }
 
+// SeriesPoint is the value of a moving statistic over a single bin. A bin holds values with timestamps after Start and
+// up to and including End.
+type SeriesPoint struct {
+	Start, End time.Time
+	Value      float64
+}
+
 // MovingSummation is a moving statistic that performs the summation of the observed values
 type MovingSummation interface {
 	MovingStatistic
 	Sum() float64
+	// SumWithin performs the summation of values which may fall within the given time window. Values are grouped into
+	// bins, so values from bins which partially overlap the window are included.
+	SumWithin(start, end time.Time) float64
+	// SeriesWithin splits SumWithin into the sum of each bin overlapping the window, from oldest to newest
+	SeriesWithin(start, end time.Time) []SeriesPoint
 }
 
-const binCount int = 100
+// Modes for STATISTICS_MODE
+const (
+	StatisticsModeBins = "bins"
+	StatisticsModeEwma = "ewma"
+)
 
-type binnedMovingSummation struct {
-	alignment time.Time             //Time that is aligned with the most recent time
-	binPeriod time.Duration         //Period for each bin
-	bins      [binCount + 1]float64 //An array of size binCount + 1; each element in the bin is the current value at a timestamp
+var invalidModeWarning sync.Once
+
+// useDecayingStatistics checks if moving statistics should be exponentially weighted instead of binned
+func useDecayingStatistics() bool {
+	switch mode := config.StatisticsMode.GetString(); mode {
+	case StatisticsModeBins:
+		return false
+	case StatisticsModeEwma:
+		return true
+	default:
+		invalidModeWarning.Do(func() {
+			log.Printf("Unknown statistics mode %q, expected %q or %q. Using %q\n", mode, StatisticsModeBins,
+				StatisticsModeEwma, StatisticsModeBins)
+		})
+		return false
+	}
+}
+
+// binPeriodFor splits the period into the given number of bins. At least one bin is always used.
+func binPeriodFor(period time.Duration, binCount int) time.Duration {
+	if binCount < 1 {
+		binCount = 1
+	}
+
+	if binPeriod := time.Duration(period.Nanoseconds()/int64(binCount)) * time.Nanosecond; binPeriod > 0 {
+		return binPeriod
+	}
+
+	return time.Nanosecond
+}
+
+// timeBins holds the bookkeeping shared by the binned moving statistics. The first bin holds the most recent values and
+// each following bin covers the bin period before the previous one.
+type timeBins[T any] struct {
+	alignment time.Time     //Time that is aligned with the most recent time
+	binPeriod time.Duration //Period for each bin
+	bins      []T           //A slice of size binCount + 1; each element holds the values added within its bin period
+}
+
+func makeTimeBins[T any](period time.Duration, binCount int) timeBins[T] {
+	binPeriod := binPeriodFor(period, binCount)
+
+	//Start at time 0 with one extra bin for the partially elapsed bin period
+	return timeBins[T]{
+		alignment: time.Unix(0, 0),
+		binPeriod: binPeriod,
+		bins:      make([]T, period/binPeriod+1),
+	}
 }
 
-func (binnedSummation *binnedMovingSummation) binFor(timestamp time.Time) int {
+func (bins *timeBins[T]) binFor(timestamp time.Time) int {
 	//Get the latest bin by current alignment + bin period
-	binLatest := binnedSummation.alignment.Add(binnedSummation.binPeriod)
+	binLatest := bins.alignment.Add(bins.binPeriod)
 	//Bin index is (binLatest - timestamp) / binPeriod
-	return int(binLatest.Sub(timestamp).Nanoseconds() / binnedSummation.binPeriod.Nanoseconds())
+	return int(binLatest.Sub(timestamp).Nanoseconds() / bins.binPeriod.Nanoseconds())
 }
 
-func (binnedSummation *binnedMovingSummation) shiftBins(shift int) {
+func (bins *timeBins[T]) shiftBins(shift int) {
 	// Adjust shift to maximum value if too large
-	if shift > binCount+1 {
-		shift = binCount + 1
+	if shift > len(bins.bins) {
+		shift = len(bins.bins)
 	}
 
 	// Shift bins over by the specified shift amount
-	copy(binnedSummation.bins[shift:], binnedSummation.bins[:])
+	copy(bins.bins[shift:], bins.bins[:])
 
-	// Zero new bins at beginning of group
+	// Clear new bins at beginning of group
+	var empty T
 	for index := 0; index < shift; index++ {
-		binnedSummation.bins[index] = 0.0
+		bins.bins[index] = empty
 	}
 }
 
-func (binnedSummation *binnedMovingSummation) IncrementUpperBound(timestamp time.Time) {
+func (bins *timeBins[T]) IncrementUpperBound(timestamp time.Time) {
 	//Find the difference in time from the timestamp and the current alignment
-	offset := timestamp.Sub(binnedSummation.alignment)
+	offset := timestamp.Sub(bins.alignment)
 	//Get the number of shifts we need to make from the offset / bin period
-	shift := int(offset.Nanoseconds() / binnedSummation.binPeriod.Nanoseconds())
+	shift := int(offset.Nanoseconds() / bins.binPeriod.Nanoseconds())
 
 	//If there is a shift (timestamp is greater than alignment) then shift bins
 	if shift > 0 {
 		//Shift bins
-		binnedSummation.shiftBins(shift)
+		bins.shiftBins(shift)
 		//Set the alignment to be current alignment + (shift * bin period)
-		binnedSummation.alignment = binnedSummation.alignment.Add(time.Duration(shift) * binnedSummation.binPeriod)
+		bins.alignment = bins.alignment.Add(time.Duration(shift) * bins.binPeriod)
 	}
 }
 
+// binRange gets the time period covered by the bin at the given index. A bin holds values with timestamps after start
+// and up to and including end.
+func (bins *timeBins[T]) binRange(index int) (start, end time.Time) {
+	end = bins.alignment.Add(time.Duration(1-index) * bins.binPeriod)
+	return end.Add(-bins.binPeriod), end
+}
+
+// binOverlaps checks if the bin at the given index may hold values from within the window
+func (bins *timeBins[T]) binOverlaps(index int, start, end time.Time) bool {
+	binStart, binEnd := bins.binRange(index)
+	return binStart.Before(end) && !binEnd.Before(start)
+}
+
+type binnedMovingSummation struct {
+	timeBins[float64]
+}
+
 func (binnedSummation *binnedMovingSummation) Append(value float64, timestamp time.Time) {
 	binnedSummation.IncrementUpperBound(timestamp)
 	//Get the target bin for this timestamp
@@ -77,7 +158,7 @@ func (binnedSummation *binnedMovingSummation) Append(value float64, timestamp ti
 	}
 
 	//Set the target bin's value to be the value given
-	if targetBin < binCount+1 {
+	if targetBin < len(binnedSummation.bins) {
 		binnedSummation.bins[targetBin] += value
 	}
 }
@@ -90,17 +171,51 @@ func (binnedSummation *binnedMovingSummation) Sum() (res float64) {
 	return
 }
 
+func (binnedSummation *binnedMovingSummation) SumWithin(start, end time.Time) (res float64) {
+	//Sum the values in bins which overlap the window
+	for index, value := range binnedSummation.bins {
+		if binnedSummation.binOverlaps(index, start, end) {
+			res += value
+		}
+	}
+	return
+}
+
+func (binnedSummation *binnedMovingSummation) SeriesWithin(start, end time.Time) (series []SeriesPoint) {
+	//Walk the bins backwards so the oldest bin comes first
+	for index := len(binnedSummation.bins) - 1; index >= 0; index-- {
+		if !binnedSummation.binOverlaps(index, start, end) {
+			continue
+		}
+
+		binStart, binEnd := binnedSummation.binRange(index)
+		series = append(series, SeriesPoint{Start: binStart, End: binEnd, Value: binnedSummation.bins[index]})
+	}
+	return
+}
+
+// MakeBinnedMovingSummation creates a summation which splits the period into the given number of bins
+func MakeBinnedMovingSummation(period time.Duration, binCount int) MovingSummation {
+	return &binnedMovingSummation{makeTimeBins[float64](period, binCount)}
+}
+
+// MakeMovingSummation creates a summation using the mode and bin count set in the config
 func MakeMovingSummation(period time.Duration) MovingSummation {
-	//Create a binnedMoving summation at time 0 and bin period to be total period / binCount
-	return &binnedMovingSummation{
-		alignment: time.Unix(0, 0),
-		binPeriod: time.Duration(period.Nanoseconds()/int64(binCount)) * time.Nanosecond,
+	if useDecayingStatistics() {
+		return MakeDecayingMovingSummation(period)
 	}
+
+	return MakeBinnedMovingSummation(period, config.SummationBinCount.GetInt())
 }
 
 type MovingAverage interface {
 	MovingStatistic
 	Average() float64
+	// AverageWithin finds the average of values which may fall within the given time window
+	AverageWithin(start, end time.Time) float64
+	// SeriesWithin splits AverageWithin into the average of each bin overlapping the window, from oldest to newest. Bins
+	// without any values have an average of NaN.
+	SeriesWithin(start, end time.Time) []SeriesPoint
 }
 
 type movingAverageImpl struct {
@@ -122,9 +237,53 @@ func (avg *movingAverageImpl) Average() float64 {
 	return avg.sum.Sum() / avg.count.Sum()
 }
 
-func MakeMovingAverage(period time.Duration) MovingAverage {
+func (avg *movingAverageImpl) AverageWithin(start, end time.Time) float64 {
+	return avg.sum.SumWithin(start, end) / avg.count.SumWithin(start, end)
+}
+
+func (avg *movingAverageImpl) SeriesWithin(start, end time.Time) []SeriesPoint {
+	// Both summations are created with the same period and bins, so their series line up
+	series := avg.sum.SeriesWithin(start, end)
+	counts := avg.count.SeriesWithin(start, end)
+	for index := range series {
+		series[index].Value /= counts[index].Value
+	}
+
+	return series
+}
+
+// MakeBinnedMovingAverage creates an average which splits the period into the given number of bins
+func MakeBinnedMovingAverage(period time.Duration, binCount int) MovingAverage {
 	return &movingAverageImpl{
-		sum:   MakeMovingSummation(period),
-		count: MakeMovingSummation(period),
+		sum:   MakeBinnedMovingSummation(period, binCount),
+		count: MakeBinnedMovingSummation(period, binCount),
+	}
+}
+
+// MakeDecayingMovingAverage creates an exponentially weighted average over the period
+func MakeDecayingMovingAverage(period time.Duration) MovingAverage {
+	return &movingAverageImpl{
+		sum:   MakeDecayingMovingSummation(period),
+		count: MakeDecayingMovingSummation(period),
+	}
+}
+
+// MakeMovingAverage creates an average using the mode set in the config. Averages use the same bin count as summations,
+// so any window with a non-zero summation also has values to average.
+func MakeMovingAverage(period time.Duration) MovingAverage {
+	if useDecayingStatistics() {
+		return MakeDecayingMovingAverage(period)
 	}
+
+	return MakeBinnedMovingAverage(period, config.SummationBinCount.GetInt())
+}
+
+// FiniteOrZero replaces NaN and infinite values with 0. Averages and ratios over a window without any values divide by
+// zero, and the result can not be encoded as JSON.
+func FiniteOrZero(value float64) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+
+	return value
 }
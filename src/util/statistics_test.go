@@ -0,0 +1,278 @@
+package util
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func expectClose(t *testing.T, name string, actual, expected float64) {
+	if math.Abs(actual-expected) > 1e-9 {
+		t.Errorf("Expected %s to be %v, but got %v", name, expected, actual)
+	}
+}
+
+func TestMovingSummationSumWithin(t *testing.T) {
+	summation := MakeMovingSummation(100 * time.Second)
+	start := time.Unix(1000, 0)
+
+	for offset := 0; offset < 50; offset++ {
+		summation.Append(1.0, start.Add(time.Duration(offset)*time.Second))
+	}
+
+	end := start.Add(49 * time.Second)
+	expectClose(t, "full sum", summation.Sum(), 50)
+	expectClose(t, "sum within full window", summation.SumWithin(end.Add(-100*time.Second), end), 50)
+	expectClose(t, "sum within last 10 seconds", summation.SumWithin(end.Add(-9*time.Second), end), 10)
+	expectClose(t, "sum before values", summation.SumWithin(start.Add(-20*time.Second), start.Add(-10*time.Second)), 0)
+}
+
+func TestMovingAverageWithin(t *testing.T) {
+	average := MakeMovingAverage(100 * time.Second)
+	start := time.Unix(1000, 0)
+
+	// Use a low value for the first half and a high value for the second half
+	for offset := 0; offset < 20; offset++ {
+		value := 1.0
+		if offset >= 10 {
+			value = 3.0
+		}
+
+		average.Append(value, start.Add(time.Duration(offset)*time.Second))
+	}
+
+	end := start.Add(19 * time.Second)
+	expectClose(t, "full average", average.Average(), 2)
+	expectClose(t, "average of second half", average.AverageWithin(start.Add(10*time.Second), end), 3)
+	expectClose(t, "average of first half", average.AverageWithin(start, start.Add(9*time.Second)), 1)
+}
+
+func TestMovingDistributionWithin(t *testing.T) {
+	distribution := MakeMovingDistribution(100 * time.Second)
+	start := time.Unix(1000, 0)
+
+	// Values 1 to 100 in the first half and a constant 500 in the second half
+	for offset := 0; offset < 100; offset++ {
+		distribution.Append(float64(offset+1), start.Add(time.Duration(offset)*time.Second/2))
+	}
+	for offset := 0; offset < 10; offset++ {
+		distribution.Append(500, start.Add(60*time.Second+time.Duration(offset)*time.Second))
+	}
+
+	firstHalf := distribution.DistributionWithin(start, start.Add(50*time.Second))
+	if firstHalf.Count != 100 {
+		t.Fatalf("Expected 100 values in the first half, but got %d", firstHalf.Count)
+	}
+
+	expectClose(t, "min", firstHalf.Min, 1)
+	expectClose(t, "max", firstHalf.Max, 100)
+	expectClose(t, "mean", firstHalf.Mean(), 50.5)
+	expectClose(t, "standard deviation", firstHalf.StdDev(), math.Sqrt((100*100-1)/12.0))
+
+	// Quantiles are estimated to within the width of a sketch bucket
+	for _, quantile := range []float64{0.5, 0.9, 0.99} {
+		expected := quantile * 100
+		if actual := firstHalf.Quantile(quantile); math.Abs(actual-expected)/expected > 0.1 {
+			t.Errorf("Expected p%.0f to be about %v, but got %v", quantile*100, expected, actual)
+		}
+	}
+
+	secondHalf := distribution.DistributionWithin(start.Add(60*time.Second), start.Add(69*time.Second))
+	expectClose(t, "constant standard deviation", secondHalf.StdDev(), 0)
+	expectClose(t, "constant median", secondHalf.Quantile(0.5), 500)
+
+	var total int64
+	histogram := distribution.DistributionWithin(start, start.Add(100*time.Second)).Histogram()
+	for index, bucket := range histogram {
+		total += bucket.Count
+		if index > 0 && bucket.UpperBound <= histogram[index-1].UpperBound {
+			t.Errorf("Expected histogram bounds to increase, but got %v", histogram)
+		}
+	}
+
+	if total != 110 || histogram[0].UpperBound < 1 || histogram[len(histogram)-1].UpperBound < 500 {
+		t.Errorf("Expected histogram to cover all 110 values from 1 to 500, but got %+v", histogram)
+	}
+
+	if empty := distribution.DistributionWithin(start.Add(-20*time.Second), start.Add(-10*time.Second)); empty.Count != 0 {
+		t.Errorf("Expected no values before the first value, but got %d", empty.Count)
+	}
+}
+
+func TestDistributionOfNegativeValues(t *testing.T) {
+	distribution := MakeMovingDistribution(100 * time.Second)
+	start := time.Unix(1000, 0)
+
+	// Values from -50 to 49
+	for offset := 0; offset < 100; offset++ {
+		distribution.Append(float64(offset-50), start.Add(time.Duration(offset)*time.Second/2))
+	}
+
+	summary := distribution.DistributionWithin(start, start.Add(50*time.Second))
+	expectClose(t, "min", summary.Min, -50)
+	expectClose(t, "mean", summary.Mean(), -0.5)
+
+	if p10 := summary.Quantile(0.1); math.Abs(p10+41) > 4 {
+		t.Errorf("Expected p10 to be about -41, but got %v", p10)
+	}
+
+	if p90 := summary.Quantile(0.9); math.Abs(p90-39) > 4 {
+		t.Errorf("Expected p90 to be about 39, but got %v", p90)
+	}
+}
+
+type timedValue struct {
+	value     float64
+	timestamp time.Time
+}
+
+// exactSum sums the values with timestamps after start and up to and including end
+func exactSum(values []timedValue, start, end time.Time) (sum float64, count int) {
+	for _, value := range values {
+		if value.timestamp.After(start) && !value.timestamp.After(end) {
+			sum += value.value
+			count += 1
+		}
+	}
+
+	return
+}
+
+func expectWithin(t *testing.T, name string, actual, expected, tolerance float64) {
+	if math.Abs(actual-expected) > tolerance {
+		t.Errorf("Expected %s to be within %v of %v, but got %v", name, tolerance, expected, actual)
+	}
+}
+
+func TestBinCountResolution(t *testing.T) {
+	period := 1000 * time.Second
+	coarse := MakeBinnedMovingSummation(period, 100)
+	fine := MakeBinnedMovingSummation(period, 1000)
+
+	var values []timedValue
+	start := time.Unix(1000, 0)
+	for offset := 0; offset < 2000; offset++ {
+		values = append(values, timedValue{value: 1, timestamp: start.Add(time.Duration(offset) * time.Second)})
+	}
+
+	for _, value := range values {
+		coarse.Append(value.value, value.timestamp)
+		fine.Append(value.value, value.timestamp)
+	}
+
+	// Values in bins partially overlapping the window are included, so the error is bounded by the width of a bin
+	end := values[len(values)-1].timestamp
+	windowStart := end.Add(-55 * time.Second)
+	exact, _ := exactSum(values, windowStart, end)
+	expectWithin(t, "sum with 10 second bins", coarse.SumWithin(windowStart, end), exact, 10)
+	expectWithin(t, "sum with 1 second bins", fine.SumWithin(windowStart, end), exact, 1)
+}
+
+func TestStatisticsModesAgainstExact(t *testing.T) {
+	period := 1000 * time.Second
+	modes := map[string]func() (MovingSummation, MovingAverage){
+		StatisticsModeBins: func() (MovingSummation, MovingAverage) {
+			return MakeBinnedMovingSummation(period, 100), MakeBinnedMovingAverage(period, 100)
+		},
+		StatisticsModeEwma: func() (MovingSummation, MovingAverage) {
+			return MakeDecayingMovingSummation(period), MakeDecayingMovingAverage(period)
+		},
+	}
+
+	for mode, makeStatistics := range modes {
+		summation, average := makeStatistics()
+
+		var values []timedValue
+		start := time.Unix(1000, 0)
+		appendUntil := func(value float64, duration time.Duration) time.Time {
+			var end time.Time
+			for offset := time.Duration(0); offset < duration; offset += time.Second {
+				end = start.Add(offset)
+				values = append(values, timedValue{value: value, timestamp: end})
+				summation.Append(value, end)
+				average.Append(value, end)
+			}
+
+			start = start.Add(duration)
+			return end
+		}
+
+		// Before the period has been covered
+		end := appendUntil(1, 300*time.Second)
+		exact, _ := exactSum(values, end.Add(-period), end)
+		expectWithin(t, mode+" sum of partial period", summation.Sum(), exact, exact*0.01)
+
+		// A steady stream of values
+		end = appendUntil(1, 1700*time.Second)
+		exact, _ = exactSum(values, end.Add(-period), end)
+		expectWithin(t, mode+" sum of steady stream", summation.Sum(), exact, exact*0.01)
+
+		exact, _ = exactSum(values, end.Add(-period/2), end)
+		expectWithin(t, mode+" sum of last half", summation.SumWithin(end.Add(-period/2), end), exact, exact*0.02)
+
+		// After a step change, older values still carry some weight in the exponentially weighted average
+		end = appendUntil(3, 1000*time.Second)
+		exact, count := exactSum(values, end.Add(-period), end)
+		exactAverage := exact / float64(count)
+		expectWithin(t, mode+" average after step", average.AverageWithin(end.Add(-period), end), exactAverage, 0.15*exactAverage)
+
+		lifetime, lifetimeCount := exactSum(values, time.Unix(0, 0), end)
+		if actual := average.Average(); math.Abs(actual-exactAverage) >= math.Abs(actual-lifetime/float64(lifetimeCount)) {
+			t.Errorf("Expected %s average %v to be closer to the recent average than the lifetime average", mode, actual)
+		}
+
+		// Values are forgotten once they fall out of the period
+		later := end.Add(10 * period)
+		summation.IncrementUpperBound(later)
+		expectWithin(t, mode+" sum after values expire", summation.Sum(), 0, 0.01)
+		expectWithin(t, mode+" sum before values", summation.SumWithin(time.Unix(0, 0), time.Unix(500, 0)), 0, 0)
+	}
+}
+
+func TestSeriesWithin(t *testing.T) {
+	summation := MakeBinnedMovingSummation(100*time.Second, 10)
+	average := MakeBinnedMovingAverage(100*time.Second, 10)
+	start := time.Unix(1000, 0)
+
+	// Leave a gap without any values in the middle
+	for offset := 0; offset < 60; offset++ {
+		if offset >= 20 && offset < 40 {
+			continue
+		}
+
+		timestamp := start.Add(time.Duration(offset) * time.Second)
+		summation.Append(1, timestamp)
+		average.Append(float64(offset), timestamp)
+	}
+
+	end := start.Add(59 * time.Second)
+	series := summation.SeriesWithin(end.Add(-100*time.Second), end)
+
+	var total float64
+	for index, point := range series {
+		total += point.Value
+		if index > 0 && !point.Start.Equal(series[index-1].End) {
+			t.Fatalf("Expected bins from oldest to newest, but got %+v", series)
+		}
+	}
+
+	expectClose(t, "series total", total, summation.SumWithin(end.Add(-100*time.Second), end))
+
+	var empty int
+	for _, point := range average.SeriesWithin(end.Add(-100*time.Second), end) {
+		if math.IsNaN(point.Value) {
+			empty++
+		}
+	}
+
+	if empty == 0 {
+		t.Error("Expected bins without values to have an average of NaN")
+	}
+
+	decaying := MakeDecayingMovingSummation(100 * time.Second)
+	decaying.Append(1, start)
+	decaying.Append(1, end)
+	if points := decaying.SeriesWithin(end.Add(-100*time.Second), end); len(points) != 1 || !points[0].Start.Equal(start) {
+		t.Errorf("Expected a single point covering the observed values, but got %+v", points)
+	}
+}